@@ -0,0 +1,431 @@
+/*
+Copyright © 2021 Ang Chin Han <ang.chin.han@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/angch/multibot/pkg/bothandler"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the bot's configuration file. Every setting with an env tag can
+// also be set with that environment variable, which wins over the file.
+// Secrets can be written as env:NAME or file:/path/to/secret, to keep them
+// out of the file.
+type Config struct {
+	// Admins are platform:username or platform:id:userid, allowed to run
+	// admin commands. Usernames can change hands, user IDs can't.
+	Admins []string `yaml:"admins,omitempty" env:"MULTIBOT_ADMINS"`
+	// Record is a file to record every message to the bot to.
+	Record string `yaml:"record,omitempty" env:"MULTIBOT_RECORD"`
+	// Plugins are the plugins to run, or all of them if empty.
+	Plugins []string `yaml:"plugins,omitempty" env:"MULTIBOT_PLUGINS"`
+	// PluginSettings are each plugin's settings, named like their
+	// environment variables, e.g. SD_URL.
+	PluginSettings map[string]map[string]string `yaml:"plugin_settings,omitempty"`
+
+	Channels  ChannelsConfig  `yaml:"channels,omitempty"`
+	Platforms PlatformsConfig `yaml:"platforms,omitempty"`
+	API       APIConfig       `yaml:"api,omitempty"`
+}
+
+type ChannelsConfig struct {
+	// File keeps the chats seen and their aliases.
+	File string `yaml:"file,omitempty" env:"MULTIBOT_CHANNELS"`
+	// Aliases are names for chats, by platform, e.g. telegram: {offtopic: "-1001234"}.
+	Aliases map[string]map[string]string `yaml:"aliases,omitempty"`
+}
+
+type PlatformsConfig struct {
+	Discord    DiscordConfig    `yaml:"discord,omitempty"`
+	Slack      SlackConfig      `yaml:"slack,omitempty"`
+	Telegram   TelegramConfig   `yaml:"telegram,omitempty"`
+	Mattermost MattermostConfig `yaml:"mattermost,omitempty"`
+	Matrix     MatrixConfig     `yaml:"matrix,omitempty"`
+	Zulip      ZulipConfig      `yaml:"zulip,omitempty"`
+	IRC        IRCConfig        `yaml:"irc,omitempty"`
+	HTTP       HTTPConfig       `yaml:"http,omitempty"`
+}
+
+type DiscordConfig struct {
+	Token string `yaml:"token,omitempty" env:"DISCORD_BOT_TOKEN,DISCORDTOKEN" secret:"true"`
+	// Webhook is used to send, without a bot, when there's no token.
+	Webhook         string `yaml:"webhook,omitempty" env:"DISCORD_WEBHOOK_URL" secret:"true"`
+	WebhookUsername string `yaml:"webhook_username,omitempty" env:"DISCORD_WEBHOOK_USERNAME"`
+	WebhookAvatar   string `yaml:"webhook_avatar,omitempty" env:"DISCORD_WEBHOOK_AVATAR"`
+}
+
+type SlackConfig struct {
+	AppToken        string `yaml:"app_token,omitempty" env:"SLACK_APP_TOKEN" secret:"true"`
+	BotToken        string `yaml:"bot_token,omitempty" env:"SLACK_BOT_TOKEN" secret:"true"`
+	Channel         string `yaml:"channel,omitempty" env:"SLACK_CHANNEL"`
+	Webhook         string `yaml:"webhook,omitempty" env:"SLACK_WEBHOOK_URL" secret:"true"`
+	WebhookUsername string `yaml:"webhook_username,omitempty" env:"SLACK_WEBHOOK_USERNAME"`
+	// WebhookIcon is an :emoji: or an image URL.
+	WebhookIcon string `yaml:"webhook_icon,omitempty" env:"SLACK_WEBHOOK_ICON"`
+}
+
+type TelegramConfig struct {
+	BotToken        string `yaml:"bot_token,omitempty" env:"TELEGRAM_BOT_TOKEN" secret:"true"`
+	Channel         string `yaml:"channel,omitempty" env:"TELEGRAM_CHANNEL"`
+	InlineCacheChat int64  `yaml:"inline_cache_chat,omitempty" env:"TELEGRAM_INLINE_CACHE_CHAT"`
+	// Webhook receives updates at WebhookURL instead of polling.
+	WebhookURL    string `yaml:"webhook_url,omitempty" env:"TELEGRAM_WEBHOOK_URL"`
+	WebhookListen string `yaml:"webhook_listen,omitempty" env:"TELEGRAM_WEBHOOK_LISTEN"`
+	WebhookSecret string `yaml:"webhook_secret,omitempty" env:"TELEGRAM_WEBHOOK_SECRET" secret:"true"`
+	WebhookCert   string `yaml:"webhook_cert,omitempty" env:"TELEGRAM_WEBHOOK_CERT"`
+	WebhookKey    string `yaml:"webhook_key,omitempty" env:"TELEGRAM_WEBHOOK_KEY"`
+}
+
+type MattermostConfig struct {
+	BotToken string `yaml:"bot_token,omitempty" env:"MATTERMOST_BOT_TOKEN" secret:"true"`
+	URL      string `yaml:"url,omitempty" env:"MATTERMOST_URL"`
+	Channel  string `yaml:"channel,omitempty" env:"MATTERMOST_CHANNEL"`
+}
+
+type MatrixConfig struct {
+	Homeserver  string `yaml:"homeserver,omitempty" env:"MATRIX_HOMESERVER"`
+	AccessToken string `yaml:"access_token,omitempty" env:"MATRIX_ACCESS_TOKEN" secret:"true"`
+	Room        string `yaml:"room,omitempty" env:"MATRIX_ROOM"`
+	Threads     bool   `yaml:"threads,omitempty" env:"MATRIX_THREADS"`
+}
+
+type ZulipConfig struct {
+	Zuliprc string `yaml:"zuliprc,omitempty" env:"ZULIPRC"`
+	Stream  string `yaml:"stream,omitempty" env:"ZULIP_STREAM"`
+}
+
+type IRCConfig struct {
+	// Conn is an irc:// or ircs:// URL, which may have a password.
+	Conn string `yaml:"conn,omitempty" env:"IRC_CONN" secret:"true"`
+}
+
+type HTTPConfig struct {
+	Listen string `yaml:"listen,omitempty" env:"HTTP_BOT_LISTEN"`
+	Token  string `yaml:"token,omitempty" env:"HTTP_BOT_TOKEN" secret:"true"`
+}
+
+type APIConfig struct {
+	Listen string `yaml:"listen,omitempty" env:"MULTIBOT_API_LISTEN"`
+	// Tokens are token:channel,channel;token2:*
+	Tokens string `yaml:"tokens,omitempty" env:"MULTIBOT_API_TOKENS" secret:"true"`
+}
+
+// defaultConfig is the configuration without a file or environment.
+func defaultConfig() *Config {
+	c := &Config{}
+	c.Channels.File = "channels.js"
+	c.Platforms.Slack.Channel = "random"
+	c.Platforms.Telegram.Channel = "offtopic"
+	c.Platforms.Telegram.WebhookListen = ":8443"
+	return c
+}
+
+// LoadConfig reads filename, if it isn't "", over the defaults, then the
+// environment over that. Settings the file doesn't know are an error.
+func LoadConfig(filename string) (*Config, error) {
+	c := defaultConfig()
+	if filename != "" {
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		d := yaml.NewDecoder(bytes.NewReader(b))
+		d.KnownFields(true)
+		err = d.Decode(c)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	err := c.applyEnv()
+	if err != nil {
+		return nil, err
+	}
+	err = c.resolveSecrets()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// setting is a field of the config, or of one of its sections.
+type setting struct {
+	path  string
+	field reflect.StructField
+	value reflect.Value
+}
+
+// settings are the config's fields, depth first, with their YAML paths,
+// e.g. platforms.discord.token.
+func settings(v reflect.Value, prefix string) []setting {
+	s := []setting{}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if f.Type.Kind() == reflect.Struct {
+			s = append(s, settings(v.Field(i), name)...)
+			continue
+		}
+		s = append(s, setting{name, f, v.Field(i)})
+	}
+	return s
+}
+
+// applyEnv sets the settings from their environment variables, if set.
+func (c *Config) applyEnv() error {
+	for _, s := range settings(reflect.ValueOf(c).Elem(), "") {
+		tag := s.field.Tag.Get("env")
+		if tag == "" {
+			continue
+		}
+		for _, name := range strings.Split(tag, ",") {
+			v := os.Getenv(name)
+			if v == "" {
+				continue
+			}
+			err := s.set(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// set parses v into the setting. Lists are comma separated.
+func (s setting) set(v string) error {
+	switch s.value.Kind() {
+	case reflect.String:
+		s.value.SetString(v)
+	case reflect.Bool:
+		b := v == "1" || strings.EqualFold(v, "true") || strings.EqualFold(v, "yes")
+		if !b && v != "0" && !strings.EqualFold(v, "false") && !strings.EqualFold(v, "no") {
+			return fmt.Errorf("%q isn't true or false", v)
+		}
+		s.value.SetBool(b)
+	case reflect.Int64:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(n)
+	case reflect.Slice:
+		list := []string{}
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		s.value.Set(reflect.ValueOf(list))
+	}
+	return nil
+}
+
+// resolveSecret reads an env:NAME or file:/path value.
+func resolveSecret(v string) (string, error) {
+	if name, ok := strings.CutPrefix(v, "env:"); ok {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("%s isn't set", name)
+		}
+		return v, nil
+	}
+	if filename, ok := strings.CutPrefix(v, "file:"); ok {
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return v, nil
+}
+
+func (c *Config) resolveSecrets() error {
+	for _, s := range settings(reflect.ValueOf(c).Elem(), "") {
+		if s.value.Kind() != reflect.String {
+			continue
+		}
+		v, err := resolveSecret(s.value.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.path, err)
+		}
+		s.value.SetString(v)
+	}
+	for plugin, settings := range c.PluginSettings {
+		for k, v := range settings {
+			v, err := resolveSecret(v)
+			if err != nil {
+				return fmt.Errorf("plugin_settings.%s.%s: %w", plugin, k, err)
+			}
+			settings[k] = v
+		}
+	}
+	return nil
+}
+
+// redacted hides secret settings, for showing the config.
+const redacted = "REDACTED"
+
+// isSecretSetting guesses which plugin settings are secrets.
+func isSecretSetting(name string) bool {
+	name = strings.ToUpper(name)
+	for _, v := range []string{"KEY", "TOKEN", "SECRET", "PASSWORD"} {
+		if strings.Contains(name, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted is a copy of the config with the secrets hidden.
+func (c *Config) Redacted() *Config {
+	r := *c
+	for _, s := range settings(reflect.ValueOf(&r).Elem(), "") {
+		if s.field.Tag.Get("secret") == "true" && !s.value.IsZero() {
+			s.value.SetString(redacted)
+		}
+	}
+	r.PluginSettings = map[string]map[string]string{}
+	for plugin, settings := range c.PluginSettings {
+		r.PluginSettings[plugin] = map[string]string{}
+		for k, v := range settings {
+			if isSecretSetting(k) && v != "" {
+				v = redacted
+			}
+			r.PluginSettings[plugin][k] = v
+		}
+	}
+	return &r
+}
+
+// configPlatforms are the platforms admins and channel aliases can be on.
+var configPlatforms = append(slices.Clone(sendmsgPlatforms), "http", "readline", "webchat")
+
+// Validate returns every problem with the config, joined.
+func (c *Config) Validate() error {
+	errs := []error{}
+	problem := func(format string, a ...any) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+	platform := func(path, name string) {
+		if !slices.Contains(configPlatforms, name) {
+			problem("%s: unknown platform %q, choose from %s", path, name, strings.Join(configPlatforms, ", "))
+		}
+	}
+
+	for _, v := range c.Admins {
+		p, username, ok := strings.Cut(v, ":")
+		if !ok || username == "" {
+			problem("admins: %q isn't platform:username or platform:id:userid", v)
+			continue
+		}
+		platform("admins", strings.ToLower(p))
+	}
+	plugins := bothandler.PluginNames()
+	for _, v := range c.Plugins {
+		if !slices.Contains(plugins, v) {
+			problem("plugins: unknown plugin %q, choose from %s", v, strings.Join(plugins, ", "))
+		}
+	}
+	for v := range c.PluginSettings {
+		if !slices.Contains(plugins, v) {
+			problem("plugin_settings: unknown plugin %q", v)
+		}
+	}
+	for p, aliases := range c.Channels.Aliases {
+		platform("channels.aliases", p)
+		for alias, id := range aliases {
+			if alias == "" || id == "" {
+				problem("channels.aliases.%s: need both an alias and a chat", p)
+			}
+		}
+	}
+
+	p := c.Platforms
+	if p.Discord.Webhook != "" {
+		if _, err := bothandler.ParseWebhooks(p.Discord.Webhook); err != nil {
+			problem("platforms.discord.webhook: %v", err)
+		}
+	}
+	if p.Slack.Webhook != "" {
+		if _, err := bothandler.ParseWebhooks(p.Slack.Webhook); err != nil {
+			problem("platforms.slack.webhook: %v", err)
+		}
+	}
+	if (p.Slack.AppToken == "") != (p.Slack.BotToken == "") {
+		problem("platforms.slack: need both app_token and bot_token")
+	}
+	if p.Slack.AppToken != "" && !strings.HasPrefix(p.Slack.AppToken, "xapp-") {
+		problem("platforms.slack.app_token: must start with xapp-")
+	}
+	if p.Slack.BotToken != "" && !strings.HasPrefix(p.Slack.BotToken, "xoxb-") {
+		problem("platforms.slack.bot_token: must start with xoxb-")
+	}
+	if (p.Telegram.WebhookCert == "") != (p.Telegram.WebhookKey == "") {
+		problem("platforms.telegram: need both webhook_cert and webhook_key")
+	}
+	if (p.Mattermost.BotToken == "") != (p.Mattermost.URL == "") {
+		problem("platforms.mattermost: need both bot_token and url")
+	}
+	if (p.Matrix.Homeserver == "") != (p.Matrix.AccessToken == "") {
+		problem("platforms.matrix: need both homeserver and access_token")
+	}
+	if p.IRC.Conn != "" {
+		if _, _, _, err := bothandler.ParseIrcURL(p.IRC.Conn); err != nil {
+			problem("platforms.irc.conn: %v", err)
+		}
+	}
+	if p.HTTP.Token != "" && p.HTTP.Listen == "" {
+		problem("platforms.http: token without listen")
+	}
+	if p.HTTP.Listen != "" && p.HTTP.Token == "" && !bothandler.LoopbackOnly(p.HTTP.Listen) {
+		problem("platforms.http: token is needed to listen on %q, or listen on localhost", p.HTTP.Listen)
+	}
+	if c.API.Listen != "" {
+		if _, err := bothandler.ParseAPITokens(c.API.Tokens); err != nil {
+			problem("api.tokens: %v", err)
+		}
+	} else if c.API.Tokens != "" {
+		problem("api: tokens without listen")
+	}
+	return errors.Join(errs...)
+}
+
+// PluginConfigs are the plugins' settings, for bothandler.InitPlugins.
+func (c *Config) PluginConfigs() map[string]bothandler.PluginConfig {
+	configs := map[string]bothandler.PluginConfig{}
+	for plugin, settings := range c.PluginSettings {
+		config := bothandler.PluginConfig{}
+		for k, v := range settings {
+			config[strings.ToUpper(k)] = v
+		}
+		configs[plugin] = config
+	}
+	return configs
+}
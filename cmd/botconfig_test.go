@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "multibot.yaml")
+	err := os.WriteFile(filename, []byte(config), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadConfig(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "telegram")
+	os.WriteFile(secret, []byte("123:abc\n"), 0600)
+	filename := writeConfig(t, `
+admins: [telegram:angch]
+plugins: [echo]
+plugin_settings:
+  stablediffusion:
+    sd_url: http://localhost:7860
+    API_KEY: env:TEST_SD_KEY
+channels:
+  aliases:
+    telegram: {OffTopic: "-100123"}
+platforms:
+  telegram:
+    bot_token: file:`+secret+`
+  discord:
+    token: from-file
+  matrix:
+    homeserver: https://matrix.org
+    access_token: syt_abc
+    threads: true
+`)
+	t.Setenv("TEST_SD_KEY", "sdkey")
+	t.Setenv("DISCORD_BOT_TOKEN", "")
+	t.Setenv("DISCORDTOKEN", "from-env")
+	t.Setenv("MULTIBOT_PLUGINS", "echo, xkcd")
+	t.Setenv("TELEGRAM_INLINE_CACHE_CHAT", "-100456")
+
+	c, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := c.Platforms
+	if p.Telegram.BotToken != "123:abc" || p.Telegram.Channel != "offtopic" || p.Telegram.InlineCacheChat != -100456 {
+		t.Errorf("expected telegram from the file, the defaults and the environment, got %+v", p.Telegram)
+	}
+	if p.Discord.Token != "from-env" {
+		t.Errorf("expected the environment to override the file, got %q", p.Discord.Token)
+	}
+	if !p.Matrix.Threads || strings.Join(c.Plugins, ",") != "echo,xkcd" || c.Channels.Aliases["telegram"]["OffTopic"] != "-100123" {
+		t.Errorf("unexpected config %+v", c)
+	}
+	configs := c.PluginConfigs()
+	if configs["stablediffusion"]["SD_URL"] != "http://localhost:7860" || configs["stablediffusion"]["API_KEY"] != "sdkey" {
+		t.Errorf("unexpected plugin settings %+v", configs)
+	}
+
+	r := c.Redacted()
+	if r.Platforms.Telegram.BotToken != "REDACTED" || r.Platforms.Matrix.AccessToken != "REDACTED" || r.PluginSettings["stablediffusion"]["API_KEY"] != "REDACTED" {
+		t.Errorf("expected the secrets redacted, got %+v", r)
+	}
+	if r.Platforms.Matrix.Homeserver != "https://matrix.org" || r.Platforms.Slack.AppToken != "" || r.PluginSettings["stablediffusion"]["sd_url"] != "http://localhost:7860" {
+		t.Errorf("expected only secrets redacted, got %+v", r)
+	}
+	if c.Platforms.Telegram.BotToken != "123:abc" || c.PluginSettings["stablediffusion"]["API_KEY"] != "sdkey" {
+		t.Error("expected the config to be left as is")
+	}
+
+	for _, v := range []struct{ config, err string }{
+		{"platforms:\n  discrod:\n    token: x\n", "field discrod not found"},
+		{"platforms:\n  matrix:\n    threads: maybe\n", "cannot unmarshal"},
+		{"platforms:\n  irc:\n    conn: env:TEST_UNSET_IRC\n", "platforms.irc.conn: TEST_UNSET_IRC isn't set"},
+	} {
+		_, err := LoadConfig(writeConfig(t, v.config))
+		if err == nil || !strings.Contains(err.Error(), v.err) {
+			t.Errorf("%q: expected %q, got %v", v.config, v.err, err)
+		}
+	}
+	t.Setenv("MATRIX_THREADS", "maybe")
+	if _, err := LoadConfig(""); err == nil || !strings.Contains(err.Error(), "MATRIX_THREADS") {
+		t.Errorf("expected a bad MATRIX_THREADS, got %v", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	c := defaultConfig()
+	c.Admins = []string{"telegram:angch"}
+	c.Plugins = []string{"dict"}
+	c.Platforms.Slack.Webhook = "alerts=https://hooks.slack.com/services/T/B/x"
+	c.Platforms.IRC.Conn = "ircs://bot@irc.libera.chat/engineers-my"
+	c.API.Listen, c.API.Tokens = "127.0.0.1:8091", "citoken:builds"
+	if err := c.Validate(); err != nil {
+		t.Errorf("expected a valid config, got %v", err)
+	}
+
+	c.Admins = []string{"angch", "telegarm:angch"}
+	c.Plugins = []string{"dict", "ocr"}
+	c.Channels.Aliases = map[string]map[string]string{"telegram": {"offtopic": ""}}
+	c.Platforms.Slack.BotToken = "xoxp-123"
+	c.Platforms.Slack.Webhook = "alerts=ftp://example.com"
+	c.Platforms.Matrix.Homeserver = "https://matrix.org"
+	c.Platforms.IRC.Conn = "ircs://bot@irc.libera.chat/engineers-my?sendburst=lots"
+	c.API.Tokens = "citoken"
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected problems")
+	}
+	want := []string{
+		`admins: "angch" isn't platform:username or platform:id:userid`,
+		`admins: unknown platform "telegarm"`,
+		`plugins: unknown plugin "ocr", choose from dict`,
+		`channels.aliases.telegram: need both an alias and a chat`,
+		`platforms.slack.webhook: invalid webhook URL for channel "alerts"`,
+		`platforms.slack: need both app_token and bot_token`,
+		`platforms.slack.bot_token: must start with xoxb-`,
+		`platforms.matrix: need both homeserver and access_token`,
+		`platforms.irc.conn: `,
+		`api.tokens: API tokens must be token:channel,channel`,
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != len(want) {
+		t.Errorf("expected %d problems, got\n%v", len(want), err)
+	}
+	for _, v := range want {
+		if !strings.Contains(err.Error(), v) {
+			t.Errorf("expected %q in\n%v", v, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright © 2021 Ang Chin Han <ang.chin.han@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var configRedacted bool
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Check and show the configuration",
+	Long: `Check and show the configuration, from the config file (--config,
+MULTIBOT_CONFIG or $HOME/.multibot.yaml) and the environment variables that
+override it.`,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration, exiting with 1 if it has problems",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := botConfig.Validate()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("OK")
+	},
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "Print the configuration in effect, as YAML",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := botConfig
+		if configRedacted {
+			c = c.Redacted()
+		}
+		e := yaml.NewEncoder(os.Stdout)
+		e.SetIndent(2)
+		err := e.Encode(c)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configPrintCmd)
+
+	configPrintCmd.Flags().BoolVar(&configRedacted, "redacted", false, "Hide tokens, passwords and other secrets")
+}
@@ -31,7 +31,11 @@ var discordlsCmd = &cobra.Command{
 	Short: "List members in a discord Guild",
 	Long:  `List all members in a discord guild`,
 	Run: func(cmd *cobra.Command, args []string) {
-		token := os.Getenv("TOKEN")
+		token := botConfig.Platforms.Discord.Token
+		if token == "" && os.Getenv("TOKEN") != "" {
+			log.Println("TOKEN is deprecated, use DISCORD_BOT_TOKEN")
+			token = os.Getenv("TOKEN")
+		}
 		dg, err := discordgo.New("Bot " + token)
 		if err != nil {
 			fmt.Println("error creating Discord session,", err)
@@ -0,0 +1,161 @@
+/*
+Copyright © 2021 Ang Chin Han <ang.chin.han@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/angch/multibot/pkg/bothandler"
+	"github.com/spf13/cobra"
+)
+
+// replayCmd represents the replay command
+var replayCmd = &cobra.Command{
+	Use:   "replay <file>",
+	Short: "Replay recorded messages through the current handlers and diff the responses",
+	Long: `Replay recorded messages through the current handlers and diff the responses.
+
+Messages are recorded by "multibot run" with MULTIBOT_RECORD=<file>. Each
+message whose responses changed is printed with the old responses (-) and
+the new ones (+). Exits with 1 if anything changed.
+
+Replay runs in a temporary directory with a copy of the bot's state files
+(the channels file, and the *.js, *.json and *.sqlite files in the current
+directory), so handlers that save state don't change the real ones. The
+clock is set to when each message was recorded. HTTP requests fail, so
+nothing is fetched or posted to other services, unless --online is given.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		recordings, err := bothandler.ReadRecordings(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		dir, err := replayDir()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if online, _ := cmd.Flags().GetBool("online"); !online {
+			http.DefaultTransport = offlineTransport{}
+		}
+		loadBotState()
+		initPlugins()
+
+		changed := 0
+		for _, v := range recordings {
+			diff := bothandler.DiffResponses(v.Responses, v.Replay())
+			if diff == "" {
+				continue
+			}
+			changed++
+			r := v.Request
+			said := r.Content
+			if v.Attachment != nil {
+				said = fmt.Sprintf("[%s, %s] %s", v.Attachment.Filename, v.Attachment.MimeType, said)
+			}
+			fmt.Printf("%s %s@%s/%s: %s\n%s\n", v.Time.Format("2006-01-02 15:04:05"), r.From, r.Platform, r.Channel, said, diff)
+		}
+		fmt.Printf("%d of %d messages changed\n", changed, len(recordings))
+		bothandler.Shutdown()
+		if changed > 0 {
+			os.RemoveAll(dir)
+			os.Exit(1)
+		}
+	},
+}
+
+// replayDir changes to a new temporary directory with a copy of the state
+// files, and returns it.
+func replayDir() (string, error) {
+	files, err := filepath.Glob("*.js")
+	if err != nil {
+		return "", err
+	}
+	for _, v := range []string{"*.json", "*.sqlite"} {
+		more, err := filepath.Glob(v)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, more...)
+	}
+
+	dir, err := os.MkdirTemp("", "multibot-replay")
+	if err != nil {
+		return "", err
+	}
+	for _, v := range files {
+		err = copyFile(v, filepath.Join(dir, v))
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	channels := botConfig.Channels.File
+	botConfig.Channels.File = filepath.Base(channels)
+	err = copyFile(channels, filepath.Join(dir, botConfig.Channels.File))
+	if err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// offlineTransport fails every request, so replaying doesn't fetch feeds,
+// draw with Stable Diffusion or post anywhere.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("replay is offline, not fetching %s", r.URL)
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+	replayCmd.Flags().Bool("online", false, "Let handlers make HTTP requests")
+}
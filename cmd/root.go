@@ -19,15 +19,19 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
+	"github.com/angch/multibot/pkg/bothandler"
 	"github.com/spf13/cobra"
 
 	homedir "github.com/mitchellh/go-homedir"
-	"github.com/spf13/viper"
 )
 
 var cfgFile string
 
+// botConfig is the configuration, from the config file and environment.
+var botConfig = defaultConfig()
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "multibot",
@@ -54,7 +58,8 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.multibot.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.multibot.yaml, or MULTIBOT_CONFIG)")
+	rootCmd.PersistentFlags().StringSlice("plugins", nil, "plugins to run, comma separated (default all of them)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -64,10 +69,11 @@ func init() {
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	log.SetFlags(log.Llongfile | log.LstdFlags)
-	if cfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
-	} else {
+	filename := cfgFile
+	if filename == "" {
+		filename = os.Getenv("MULTIBOT_CONFIG")
+	}
+	if filename == "" {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
@@ -75,15 +81,59 @@ func initConfig() {
 			os.Exit(1)
 		}
 
-		// Search config in home directory with name ".multibot" (without extension).
-		viper.AddConfigPath(home)
-		viper.SetConfigName(".multibot")
+		// The config file in the home directory is optional.
+		filename = home + "/.multibot.yaml"
+		if _, err := os.Stat(filename); err != nil {
+			filename = ""
+		}
+	}
+
+	c, err := LoadConfig(filename)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if filename != "" {
+		log.Println("Using config file:", filename)
+	}
+	if rootCmd.PersistentFlags().Changed("plugins") {
+		c.Plugins, _ = rootCmd.PersistentFlags().GetStringSlice("plugins")
+	}
+	botConfig = c
+}
+
+// loadBotState loads the state shared by the commands that talk to the chat
+// platforms: the channel registry, with the aliases in the config, and the
+// admins.
+func loadBotState() {
+	err := bothandler.Channels.Load(botConfig.Channels.File)
+	if err != nil {
+		log.Println("Can't load", botConfig.Channels.File, err)
+	}
+	for platform, aliases := range botConfig.Channels.Aliases {
+		for alias, id := range aliases {
+			// Only saved when it changes
+			if old, ok := bothandler.Channels.Resolve(platform, alias); ok && old == id {
+				continue
+			}
+			err := bothandler.Channels.Alias(platform, alias, id)
+			if err != nil {
+				log.Println("channels.aliases:", err)
+			}
+		}
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	for _, v := range botConfig.Admins {
+		bothandler.AddAdmin(v)
+	}
+}
 
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+// initPlugins initializes the plugins chosen with --plugins, or the config's
+// plugins list, or else all of them, registering their handlers.
+func initPlugins() {
+	err := bothandler.InitPlugins(botConfig.Plugins, botConfig.PluginConfigs())
+	if err != nil {
+		log.Fatal(err)
 	}
+	log.Println("Plugins:", strings.Join(bothandler.EnabledPlugins(), ", "))
 }
@@ -16,17 +16,13 @@ limitations under the License.
 package cmd
 
 import (
-	"fmt"
 	"log"
-	"net/url"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 
 	"github.com/angch/multibot/pkg/bothandler"
 	"github.com/spf13/cobra"
-	"gopkg.in/irc.v3"
 )
 
 // runCmd represents the run command
@@ -36,8 +32,25 @@ var runCmd = &cobra.Command{
 	Long:  `Run the multibot`,
 	Run: func(cmd *cobra.Command, args []string) {
 		sc := make(chan os.Signal, 1)
+		err := botConfig.Validate()
+		if err != nil {
+			log.Fatal(err)
+		}
+		loadBotState()
+		initPlugins()
+		platforms := botConfig.Platforms
+
+		// Opt-in, it has everything everyone says to the bot
+		recordFile := botConfig.Record
+		if recordFile != "" {
+			err := bothandler.StartRecording(recordFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer bothandler.StopRecording()
+		}
 
-		discordtoken := os.Getenv("DISCORDTOKEN")
+		discordtoken := platforms.Discord.Token
 		if discordtoken != "" {
 			n, err := bothandler.NewMessagePlatformFromDiscord(discordtoken)
 			if err != nil {
@@ -46,48 +59,68 @@ var runCmd = &cobra.Command{
 			// log.Println("Discord Bot is now running.")
 			bothandler.RegisterMessagePlatform(n)
 			go n.ProcessMessages()
-		}
-
-		slackAppToken := os.Getenv("SLACK_APP_TOKEN")
-		slackBotToken := os.Getenv("SLACK_BOT_TOKEN")
-		if slackAppToken != "" && slackBotToken != "" {
-			if !strings.HasPrefix(slackAppToken, "xapp-") {
-				fmt.Fprintf(os.Stderr, "SLACK_APP_TOKEN must have the prefix \"xapp-\".")
+		} else {
+			// Send-only, when there's no bot
+			n, err := newDiscordWebhook()
+			if err != nil {
+				log.Fatal(err)
 			}
-			if !strings.HasPrefix(slackBotToken, "xoxb-") {
-				fmt.Fprintf(os.Stderr, "SLACK_BOT_TOKEN must have the prefix \"xoxb-\".")
+			if n != nil {
+				bothandler.RegisterMessagePlatform(n)
 			}
+		}
 
+		slackAppToken := platforms.Slack.AppToken
+		slackBotToken := platforms.Slack.BotToken
+		if slackAppToken != "" && slackBotToken != "" {
 			s, err := bothandler.NewMessagePlatformFromSlack(slackBotToken, slackAppToken)
 			if err != nil {
 				log.Fatal(err)
 			}
-			s.DefaultChannel = "random"
+			s.DefaultChannel = platforms.Slack.Channel
 			// log.Println("Slack bot is now running.")
 			bothandler.RegisterMessagePlatform(s)
 			go s.ProcessMessages()
+		} else {
+			s, err := newSlackWebhook()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if s != nil {
+				bothandler.RegisterMessagePlatform(s)
+			}
 		}
 
-		telegramBotToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-		if telegramBotToken != "" {
-			s, err := bothandler.NewMessagePlatformFromTelegram(telegramBotToken)
+		telegram := platforms.Telegram
+		if telegram.BotToken != "" {
+			s, err := bothandler.NewMessagePlatformFromTelegram(telegram.BotToken)
 			if err != nil {
 				log.Fatal(err)
 			}
-			s.DefaultChannel = "offtopic"
+			s.DefaultChannel = telegram.Channel
+			if telegram.WebhookURL != "" {
+				s.Webhook = &bothandler.TelegramWebhookConfig{
+					URL:         telegram.WebhookURL,
+					Listen:      telegram.WebhookListen,
+					SecretToken: telegram.WebhookSecret,
+					CertFile:    telegram.WebhookCert,
+					KeyFile:     telegram.WebhookKey,
+				}
+			}
+			s.InlineCacheChat = telegram.InlineCacheChat
 			// log.Println("Telegram bot is now running.")
 			bothandler.RegisterMessagePlatform(s)
 			go s.ProcessMessages()
 		}
 
-		mattermostBotToken := os.Getenv("MATTERMOST_BOT_TOKEN")
-		mattermostURL := os.Getenv("MATTERMOST_URL")
+		mattermostBotToken := platforms.Mattermost.BotToken
+		mattermostURL := platforms.Mattermost.URL
 		if mattermostBotToken != "" && mattermostURL != "" {
 			s, err := bothandler.NewMessagePlatformFromMattermost(mattermostBotToken, mattermostURL)
 			if err != nil {
 				log.Fatal(err)
 			}
-			mattermost_channel := os.Getenv("MATTERMOST_CHANNEL")
+			mattermost_channel := platforms.Mattermost.Channel
 			if mattermost_channel != "" {
 				s.DefaultChannel = mattermost_channel
 			}
@@ -96,30 +129,67 @@ var runCmd = &cobra.Command{
 			go s.ProcessMessages()
 		}
 
-		ircConn := os.Getenv("IRC_CONN")
+		matrixHomeserver := platforms.Matrix.Homeserver
+		matrixAccessToken := platforms.Matrix.AccessToken
+		if matrixHomeserver != "" && matrixAccessToken != "" {
+			s, err := bothandler.NewMessagePlatformFromMatrix(matrixHomeserver, matrixAccessToken)
+			if err != nil {
+				log.Fatal(err)
+			}
+			s.DefaultChannel = platforms.Matrix.Room
+			s.ThreadReplies = platforms.Matrix.Threads
+			// log.Println("Matrix bot is now running.")
+			bothandler.RegisterMessagePlatform(s)
+			go s.ProcessMessages()
+		}
+
+		zuliprc := platforms.Zulip.Zuliprc
+		if zuliprc != "" {
+			s, err := bothandler.NewMessagePlatformFromZuliprc(zuliprc)
+			if err != nil {
+				log.Fatal(err)
+			}
+			s.DefaultChannel = platforms.Zulip.Stream
+			// log.Println("Zulip bot is now running.")
+			bothandler.RegisterMessagePlatform(s)
+			go s.ProcessMessages()
+		}
+
+		httpBotListen := platforms.HTTP.Listen
+		if httpBotListen != "" {
+			s, err := bothandler.NewMessagePlatformFromHTTP(httpBotListen, platforms.HTTP.Token)
+			if err != nil {
+				log.Fatal(err)
+			}
+			bothandler.RegisterMessagePlatform(s)
+			go s.ProcessMessages()
+		}
+
+		ircConn := platforms.IRC.Conn
 		if ircConn != "" {
-			ircParams, err := url.Parse(ircConn)
-			if err == nil {
-				password, _ := ircParams.User.Password()
-				username := ircParams.User.Username()
-				config := irc.ClientConfig{
-					User: username,
-					Nick: username,
-					Name: username,
-					Pass: password,
-				}
-				s, err := bothandler.NewMessagePlatformFromIrc(ircParams.Host, &config, sc)
-				if err != nil {
-					log.Fatal(err)
-				}
-				s.DefaultChannel = strings.TrimPrefix(ircParams.Path, "/")
+			s, err := bothandler.NewMessagePlatformFromIrcURL(ircConn, sc)
+			if err != nil {
+				log.Fatal(err)
+			}
 
-				// log.Println("Irc bot is now running.")
-				bothandler.RegisterMessagePlatform(s)
-				go s.ProcessMessages()
+			// log.Println("Irc bot is now running.")
+			bothandler.RegisterMessagePlatform(s)
+			go s.ProcessMessages()
+		}
+
+		apiListen := botConfig.API.Listen
+		if apiListen != "" {
+			tokens, err := bothandler.ParseAPITokens(botConfig.API.Tokens)
+			if err != nil {
+				log.Fatal("api.tokens: ", err)
 			}
+			api := bothandler.NewAPIServer(apiListen, tokens)
+			go api.ListenAndServe()
+			defer api.Close()
 		}
 
+		bothandler.StartPlugins()
+
 		signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 		<-sc
 
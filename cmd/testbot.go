@@ -27,46 +27,81 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var testbotPlatform, testbotChannel, testbotUser, testbotImages, testbotScript string
+
 // testbotCmd represents the testbot command
 var testbotCmd = &cobra.Command{
 	Use:   "testbot",
 	Short: "Test the bot on the command line, without connecting to discord/slack",
-	Long:  `Test the bot on the command line, without connecting to discord/slack`,
+	Long: `Test the bot on the command line, without connecting to discord/slack.
+
+Messages appear to come from --platform, --channel and --user, e.g.
+--platform discord --channel <id> to try a plugin that only answers in one
+channel. "/attach <file> [caption]" sends a file, and "/press <n>" clicks a
+button. Image responses are written to --images.
+
+With --script, messages are read from a file, one per line, and the
+transcript is printed.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		sc := make(chan os.Signal, 1)
-		signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-		if true {
-			home, err := homedir.Dir()
+		loadBotState()
+		initPlugins()
+		if testbotImages != "" {
+			err := os.MkdirAll(testbotImages, 0755)
 			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+				log.Fatal(err)
 			}
-			historyfile := home + "/." + rootCmd.Use + "_history"
+		}
 
-			n, err := bothandler.NewMessagePlatformFromReadline(historyfile, sc)
+		if testbotScript != "" {
+			f, err := os.Open(testbotScript)
 			if err != nil {
 				log.Fatal(err)
 			}
-			bothandler.RegisterMessagePlatform(n)
-			go n.ProcessMessages()
-			fmt.Println("Test Bot is now running.  Press CTRL-C to exit.")
+			defer f.Close()
+			n := bothandler.NewMessagePlatformFromScript(f, os.Stdout)
+			setTestbotIdentity(n)
+			n.ProcessMessages()
+			bothandler.Shutdown()
+			return
 		}
 
+		sc := make(chan os.Signal, 1)
+		signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+		home, err := homedir.Dir()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		historyfile := home + "/." + rootCmd.Use + "_history"
+
+		n, err := bothandler.NewMessagePlatformFromReadline(historyfile, sc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		setTestbotIdentity(n)
+		bothandler.RegisterMessagePlatform(n)
+		go n.ProcessMessages()
+		bothandler.StartPlugins()
+		fmt.Println("Test Bot is now running.  Press CTRL-C to exit.")
+
 		<-sc
 		bothandler.Shutdown()
 	},
 }
 
+func setTestbotIdentity(n *bothandler.ReadlineMessagePlatform) {
+	n.Platform = testbotPlatform
+	n.Channel = testbotChannel
+	n.User = testbotUser
+	n.ImageDir = testbotImages
+}
+
 func init() {
 	rootCmd.AddCommand(testbotCmd)
 
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// testbotCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// testbotCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	testbotCmd.Flags().StringVar(&testbotPlatform, "platform", "readline", "Platform messages appear to come from, e.g. discord")
+	testbotCmd.Flags().StringVar(&testbotChannel, "channel", "", "Channel messages appear to come from")
+	testbotCmd.Flags().StringVar(&testbotUser, "user", "", "User messages appear to come from")
+	testbotCmd.Flags().StringVar(&testbotImages, "images", "", "Directory to write image responses to")
+	testbotCmd.Flags().StringVar(&testbotScript, "script", "", "Read messages from a file and print the transcript")
 }
@@ -0,0 +1,62 @@
+/*
+Copyright © 2021 Ang Chin Han <ang.chin.han@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/angch/multibot/pkg/bothandler"
+	"github.com/spf13/cobra"
+)
+
+var webchatListen string
+
+// webchatCmd represents the webchat command
+var webchatCmd = &cobra.Command{
+	Use:   "webchat",
+	Short: "Test the bot in the browser, with images, without connecting to a chat service",
+	Long: `Test the bot in the browser, without connecting to a chat service.
+
+Serves a chat page where image responses are shown inline, images can be
+uploaded to try image handlers, and the platform, channel and user can be
+picked to try plugins that only answer in some channels.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		sc := make(chan os.Signal, 1)
+		signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+		loadBotState()
+		initPlugins()
+
+		n, err := bothandler.NewMessagePlatformFromWebchat(webchatListen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bothandler.RegisterMessagePlatform(n)
+		go n.ProcessMessages()
+		bothandler.StartPlugins()
+
+		<-sc
+		bothandler.Shutdown()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(webchatCmd)
+
+	webchatCmd.Flags().StringVar(&webchatListen, "listen", "localhost:8080", "Address to serve the chat page on")
+}
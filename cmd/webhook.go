@@ -0,0 +1,61 @@
+/*
+Copyright © 2021 Ang Chin Han <ang.chin.han@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"strings"
+
+	"github.com/angch/multibot/pkg/bothandler"
+)
+
+// newSlackWebhook makes the Slack webhook platform from
+// platforms.slack.webhook, or returns nil if it isn't set.
+func newSlackWebhook() (*bothandler.SlackWebhookMessagePlatform, error) {
+	slack := botConfig.Platforms.Slack
+	webhooks := slack.Webhook
+	if webhooks == "" {
+		return nil, nil
+	}
+	s, err := bothandler.NewMessagePlatformFromSlackWebhook(webhooks)
+	if err != nil {
+		return nil, err
+	}
+	s.Username = slack.WebhookUsername
+	icon := slack.WebhookIcon
+	if strings.HasPrefix(icon, ":") {
+		s.IconEmoji = icon
+	} else {
+		s.IconURL = icon
+	}
+	return s, nil
+}
+
+// newDiscordWebhook makes the Discord webhook platform from
+// platforms.discord.webhook, or returns nil if it isn't set.
+func newDiscordWebhook() (*bothandler.DiscordWebhookMessagePlatform, error) {
+	discord := botConfig.Platforms.Discord
+	webhooks := discord.Webhook
+	if webhooks == "" {
+		return nil, nil
+	}
+	s, err := bothandler.NewMessagePlatformFromDiscordWebhook(webhooks)
+	if err != nil {
+		return nil, err
+	}
+	s.Username = discord.WebhookUsername
+	s.AvatarURL = discord.WebhookAvatar
+	return s, nil
+}
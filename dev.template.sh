@@ -1,2 +1,2 @@
-export TOKEN=yourdiscordtokenhere
-export SLACKWEBHOOK=yourslackwebhookhere
+export DISCORD_BOT_TOKEN=yourdiscordtokenhere
+export SLACK_WEBHOOK_URL=yourslackwebhookhere
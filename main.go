@@ -19,7 +19,11 @@ import (
 	"github.com/angch/multibot/cmd"
 	_ "github.com/angch/multibot/pkg/apod"
 	_ "github.com/angch/multibot/pkg/askfaz"
+	_ "github.com/angch/multibot/pkg/channel"
+	_ "github.com/angch/multibot/pkg/compreface"
 	_ "github.com/angch/multibot/pkg/echo"
+	_ "github.com/angch/multibot/pkg/feed"
+	_ "github.com/angch/multibot/pkg/githook"
 	_ "github.com/angch/multibot/pkg/kulll"
 	_ "github.com/angch/multibot/pkg/meme"
 
@@ -29,7 +33,6 @@ import (
 	_ "github.com/angch/multibot/pkg/spacetraders"
 	_ "github.com/angch/multibot/pkg/stablediffusion"
 
-	_ "github.com/angch/multibot/pkg/ocr"
 	_ "github.com/angch/multibot/pkg/unicodefont"
 	_ "github.com/angch/multibot/pkg/xkcd"
 	_ "github.com/angch/multibot/pkg/ymca"
@@ -16,25 +16,48 @@ import (
 
 var posts map[string]ApodPost
 
+// Where the pictures come from, and where the ones already posted are kept.
+var apodURL = "https://apod.nasa.gov/apod/"
+var postsFile = "posts.js"
+
+// plugin posts the picture of the day to every platform.
+type plugin struct {
+	done chan struct{}
+}
+
 func init() {
-	// go Tick()
+	bothandler.RegisterPlugin(&plugin{})
+}
+
+func (p *plugin) Name() string { return "apod" }
 
+func (p *plugin) Init(bothandler.PluginConfig) error {
 	posts = make(map[string]ApodPost)
-	f, err := os.Open("posts.js")
+	f, err := os.Open(postsFile)
 	if err == nil {
+		defer f.Close()
 		b, err := io.ReadAll(f)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		err = json.Unmarshal(b, &posts)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("%s: %w", postsFile, err)
 		}
-		f.Close()
 	}
 	// log.Printf("%+v\n", posts)
+	return nil
+}
+
+func (p *plugin) Start() error {
+	// go Tick()
+	p.done = make(chan struct{})
+	go Apod(p.done)
+	return nil
+}
 
-	go Apod()
+func (p *plugin) Stop() {
+	close(p.done)
 }
 
 func GetMessagePlatforms() []bothandler.MessagePlatform {
@@ -120,7 +143,7 @@ func doYMD(y, m, d int) *ApodPost {
 		y -= 2000
 	}
 
-	url := fmt.Sprintf("https://apod.nasa.gov/apod/ap%02d%02d%02d.html", y, m, d)
+	url := fmt.Sprintf("%sap%02d%02d%02d.html", apodURL, y, m, d)
 	resp, err := http.Get(url)
 	if err != nil || resp == nil || resp.Body == nil {
 		log.Println(err)
@@ -148,49 +171,59 @@ func doYMD(y, m, d int) *ApodPost {
 	return myApod
 }
 
-func Apod() {
+// Apod posts the picture of the day until done is closed.
+func Apod(done <-chan struct{}) {
 	// Let all the platforms get initialized first
-	time.Sleep(5 * time.Second)
+	if !bothandler.SleepOrDone(5*time.Second, done) {
+		return
+	}
 
 	for {
-		today := time.Now() // Yes, I know. timezone.
-		d := today.Day()
-		m := int(today.Month())
-		y := today.Year()
-		key := fmt.Sprintf("%04d%02d%02d", y, m, d)
-		_, exists := posts[key]
-		if exists {
-			log.Println("Done for today")
-			time.Sleep(1 * time.Hour)
-			continue
+		if !bothandler.SleepOrDone(checkToday(), done) {
+			return
 		}
+	}
+}
 
-		log.Printf("Doing %d %d %d\n", y, m, d)
-
-		p := doYMD(y, m, d)
-		if p != nil {
-			posts[key] = *p
-			f, err := os.OpenFile("posts.js", os.O_RDWR|os.O_CREATE, 0755)
-			if err != nil {
-				log.Fatal(err)
-			}
-			b, _ := json.Marshal(posts)
-			_, err = f.Write(b)
-			if err != nil {
-				log.Fatal(err)
-			}
-			f.Close()
+// checkToday posts today's picture if it hasn't been posted yet, and
+// returns how long to wait before checking again.
+func checkToday() time.Duration {
+	today := bothandler.Now() // Yes, I know. timezone.
+	d := today.Day()
+	m := int(today.Month())
+	y := today.Year()
+	key := fmt.Sprintf("%04d%02d%02d", y, m, d)
+	_, exists := posts[key]
+	if exists {
+		log.Println("Done for today")
+		return 1 * time.Hour
+	}
 
-			log.Printf("%+v\n", p)
+	log.Printf("Doing %d %d %d\n", y, m, d)
 
-			text := fmt.Sprintf("%s %s", p.Text, p.ImageURL)
-			MessagePlatforms := GetMessagePlatforms()
-			for _, v := range MessagePlatforms {
-				v.SendWithOptions(text, bothandler.SendOptions{Silent: true})
-			}
+	p := doYMD(y, m, d)
+	if p != nil {
+		posts[key] = *p
+		f, err := os.OpenFile(postsFile, os.O_RDWR|os.O_CREATE, 0755)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, _ := json.Marshal(posts)
+		_, err = f.Write(b)
+		if err != nil {
+			log.Fatal(err)
 		}
+		f.Close()
+
+		log.Printf("%+v\n", p)
 
-		log.Println("Sleeping 5 minutes")
-		time.Sleep(5 * time.Minute)
+		text := fmt.Sprintf("%s %s", p.Text, p.ImageURL)
+		MessagePlatforms := GetMessagePlatforms()
+		for _, v := range MessagePlatforms {
+			v.SendWithOptions(text, bothandler.SendOptions{Silent: true})
+		}
 	}
+
+	log.Println("Sleeping 5 minutes")
+	return 5 * time.Minute
 }
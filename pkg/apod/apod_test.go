@@ -0,0 +1,43 @@
+package apod
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/angch/multibot/pkg/bothandler/bothandlertest"
+)
+
+func TestCheckToday(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ap240102.html":
+			fmt.Fprint(w, `<html><title> APOD: 2024 January 2 - Moon </title><a href="image/2401/moon.jpg">`)
+		case "/ap240103.html":
+			fmt.Fprint(w, `<html><title> APOD: 2024 January 3 - Sun </title><a href="image/2401/sun.png">`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	apodURL = server.URL + "/"
+	postsFile = filepath.Join(t.TempDir(), "posts.js")
+	posts = map[string]ApodPost{}
+
+	// checkToday is called directly, instead of starting the plugin.
+	conv := bothandlertest.NewConversation(t)
+	bothandlertest.NewClock(t, time.Date(2024, 1, 2, 9, 0, 0, 0, time.Local))
+	if d := checkToday(); d != 5*time.Minute {
+		t.Errorf("first check waits %v", d)
+	}
+	if d := checkToday(); d != time.Hour {
+		t.Errorf("second check waits %v", d)
+	}
+	bothandlertest.NewClock(t, time.Date(2024, 1, 3, 9, 0, 0, 0, time.Local))
+	checkToday()
+
+	conv.Golden("testdata/apod.golden")
+}
@@ -26,7 +26,9 @@ var handles = []string{
 }
 
 func init() {
-	bothandler.RegisterCatchallHandler(AskFazHandler)
+	bothandler.RegisterHandlerPlugin("askfaz", func() {
+		bothandler.RegisterCatchallHandler(AskFazHandler)
+	})
 }
 
 func AskFazHandler(r bothandler.Request) string {
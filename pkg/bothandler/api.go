@@ -0,0 +1,272 @@
+package bothandler
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIServer is a REST API for CI and monitoring to post through the bot's
+// connections, e.g.
+//
+//	curl -H "Authorization: Bearer $TOKEN" -H "Idempotency-Key: build-1234" \
+//	    -d '{"channel": "builds", "text": "Build 1234 **passed**"}' \
+//	    http://localhost:8091/v1/messages
+//
+// POST /v1/messages takes an APIMessage and returns an APIResponse, with a
+// result for each platform it was sent to. The status is 200 if any
+// platform took the message, and 502 if none did.
+type APIServer struct {
+	Listen string
+	Tokens []APIToken
+	// IdempotencyTTL is how long a response is kept to be returned again
+	// for a request with the same Idempotency-Key.
+	IdempotencyTTL time.Duration
+
+	server *http.Server
+
+	idempotentLock sync.Mutex
+	idempotent     map[string]*apiIdempotent
+}
+
+// APIToken is a bearer token and the channels it may post to, "*" for any.
+type APIToken struct {
+	Token    string
+	Channels []string
+}
+
+func (t APIToken) Allows(channel string) bool {
+	return slices.Contains(t.Channels, "*") || slices.Contains(t.Channels, channel)
+}
+
+// ParseAPITokens parses tokens and their channels, as
+// "token:channel,channel;token:*".
+func ParseAPITokens(s string) ([]APIToken, error) {
+	tokens := []APIToken{}
+	for _, v := range strings.Split(s, ";") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		token, channels, ok := strings.Cut(v, ":")
+		if !ok || token == "" || channels == "" {
+			return nil, fmt.Errorf("API tokens must be token:channel,channel")
+		}
+		t := APIToken{Token: token}
+		for _, c := range strings.Split(channels, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				t.Channels = append(t.Channels, c)
+			}
+		}
+		tokens = append(tokens, t)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no API tokens")
+	}
+	return tokens, nil
+}
+
+// APIMessage is a message to post. Channel is a logical channel, which
+// each platform resolves as for ChannelMessageSend, e.g. with the channel
+// registry. Platforms limits which platforms it is sent to, all by default.
+// Thread needs a token that may post anywhere, as Discord threads are
+// channels of their own, whatever Channel is.
+type APIMessage struct {
+	Channel   string    `json:"channel"`
+	Text      string    `json:"text"`
+	Platforms []string  `json:"platforms,omitempty"`
+	Thread    string    `json:"thread,omitempty"`
+	Silent    bool      `json:"silent,omitempty"`
+	Files     []APIFile `json:"files,omitempty"`
+}
+
+// APIFile is an attachment, with Data base64 encoded in JSON.
+type APIFile struct {
+	Filename string `json:"filename"`
+	Data     []byte `json:"data"`
+}
+
+type APIResult struct {
+	Platform string `json:"platform"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+type APIResponse struct {
+	Results []APIResult `json:"results"`
+}
+
+// apiIdempotent is the response to a request with an Idempotency-Key, done
+// when it has been sent.
+type apiIdempotent struct {
+	body     [sha256.Size]byte
+	done     chan struct{}
+	expires  time.Time
+	status   int
+	response APIResponse
+}
+
+const apiMaxBodySize = 20 << 20
+const apiDefaultIdempotencyTTL = 24 * time.Hour
+
+func NewAPIServer(listen string, tokens []APIToken) *APIServer {
+	s := &APIServer{
+		Listen:         listen,
+		Tokens:         tokens,
+		IdempotencyTTL: apiDefaultIdempotencyTTL,
+		idempotent:     map[string]*apiIdempotent{},
+	}
+	s.server = &http.Server{
+		Addr:    listen,
+		Handler: s.Handler(),
+	}
+	return s
+}
+
+func (s *APIServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/messages", s.handleMessages)
+	mountHTTPHandlers(mux)
+	return mux
+}
+
+// token finds the request's bearer token, checking all of them in constant
+// time.
+func (s *APIServer) token(r *http.Request) (APIToken, bool) {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	found := APIToken{}
+	for _, t := range s.Tokens {
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(t.Token)) == 1 {
+			found = t
+		}
+	}
+	return found, ok && found.Token != ""
+}
+
+func (s *APIServer) handleMessages(w http.ResponseWriter, r *http.Request) {
+	token, ok := s.token(r)
+	if !ok {
+		apiError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, apiMaxBodySize))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "bad request: "+err.Error())
+		return
+	}
+	m := APIMessage{}
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "bad request: "+err.Error())
+		return
+	}
+	if m.Channel == "" || (strings.TrimSpace(m.Text) == "" && len(m.Files) == 0) {
+		apiError(w, http.StatusBadRequest, "channel and text or files are required")
+		return
+	}
+	if !token.Allows(m.Channel) {
+		apiError(w, http.StatusForbidden, "not allowed to post to "+m.Channel)
+		return
+	}
+	if m.Thread != "" && !token.Allows("*") {
+		apiError(w, http.StatusForbidden, "not allowed to post to threads")
+		return
+	}
+
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		status, response := s.send(m)
+		apiJSON(w, status, response)
+		return
+	}
+	// Keys are per token, so one client can't see another's responses.
+	key = token.Token + "\x00" + key
+	sum := sha256.Sum256(b)
+	entry, first := s.idempotentEntry(key, sum)
+	if entry.body != sum {
+		apiError(w, http.StatusUnprocessableEntity, "Idempotency-Key was used for a different request")
+		return
+	}
+	if first {
+		entry.status, entry.response = s.send(m)
+		close(entry.done)
+	} else {
+		<-entry.done
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+	apiJSON(w, entry.status, entry.response)
+}
+
+// idempotentEntry returns the entry for key, and whether it is new, in
+// which case the caller sends the message and closes done.
+func (s *APIServer) idempotentEntry(key string, body [sha256.Size]byte) (*apiIdempotent, bool) {
+	s.idempotentLock.Lock()
+	defer s.idempotentLock.Unlock()
+	now := Now()
+	for k, v := range s.idempotent {
+		if now.After(v.expires) {
+			delete(s.idempotent, k)
+		}
+	}
+	if entry, ok := s.idempotent[key]; ok {
+		return entry, false
+	}
+	entry := &apiIdempotent{body: body, done: make(chan struct{}), expires: now.Add(s.IdempotencyTTL)}
+	s.idempotent[key] = entry
+	return entry, true
+}
+
+func (s *APIServer) send(m APIMessage) (int, APIResponse) {
+	outbound := OutboundMessage{Channel: m.Channel, Thread: m.Thread, Text: m.Text, Silent: m.Silent}
+	for _, f := range m.Files {
+		outbound.Files = append(outbound.Files, NewAttachment(f.Filename, f.Data, ""))
+	}
+	response := APIResponse{Results: []APIResult{}}
+	status := http.StatusBadGateway
+	for _, v := range SendAll(outbound, m.Platforms) {
+		result := APIResult{Platform: v.Platform, OK: v.Err == nil}
+		if v.Err != nil {
+			result.Error = v.Err.Error()
+			log.Printf("API: %s %s: %v", v.Platform, m.Channel, v.Err)
+		} else {
+			status = http.StatusOK
+		}
+		response.Results = append(response.Results, result)
+	}
+	return status, response
+}
+
+func apiJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	apiJSON(w, status, struct {
+		Error string `json:"error"`
+	}{message})
+}
+
+func (s *APIServer) ListenAndServe() {
+	log.Println("API listening on", s.Listen)
+	err := s.server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Println(err)
+	}
+}
+
+func (s *APIServer) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+}
@@ -0,0 +1,123 @@
+package bothandler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseAPITokens(t *testing.T) {
+	tokens, err := ParseAPITokens("ci:builds, deploys; monitor:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || !tokens[0].Allows("deploys") || tokens[0].Allows("general") || !tokens[1].Allows("general") {
+		t.Errorf("%+v", tokens)
+	}
+	for _, v := range []string{"", "ci", "ci:", ":builds"} {
+		if _, err := ParseAPITokens(v); err == nil {
+			t.Errorf("%q: expected an error", v)
+		}
+	}
+}
+
+func TestAPIServer(t *testing.T) {
+	oldPlatforms := ActiveMessagePlatforms
+	defer func() { ActiveMessagePlatforms = oldPlatforms }()
+
+	slackPosts := atomic.Int32{}
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slackPosts.Add(1)
+		io.WriteString(w, "ok")
+	}))
+	defer slack.Close()
+	discord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Unknown Webhook"}`, http.StatusNotFound)
+	}))
+	defer discord.Close()
+
+	slackWebhook, _ := NewMessagePlatformFromSlackWebhook("builds=" + slack.URL)
+	discordWebhook, _ := NewMessagePlatformFromDiscordWebhook("builds=" + discord.URL)
+	out := &strings.Builder{}
+	ActiveMessagePlatforms = []MessagePlatform{slackWebhook, discordWebhook, NewMessagePlatformFromScript(nil, out)}
+
+	server := httptest.NewServer(NewAPIServer(":0", []APIToken{
+		{"ci", []string{"builds"}},
+		{"monitor", []string{"*"}},
+	}).Handler())
+	defer server.Close()
+
+	post := func(token, key, body string) (int, http.Header, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/v1/messages", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header, strings.TrimSpace(string(b))
+	}
+
+	status, _, body := post("ci", "build-1", `{"channel": "builds", "text": "build **passed**"}`)
+	if status != http.StatusOK {
+		t.Error("expected 200, got", status, body)
+	}
+	response := APIResponse{}
+	json.Unmarshal([]byte(body), &response)
+	if len(response.Results) != 3 {
+		t.Fatalf("expected a result per platform, got %s", body)
+	}
+	r := response.Results
+	if r[0] != (APIResult{"slack", true, ""}) || r[1].Platform != "discord" || r[1].OK || !strings.Contains(r[1].Error, "Unknown Webhook") || r[2] != (APIResult{"readline", true, ""}) {
+		t.Errorf("expected slack and readline to succeed and discord to fail, got %s", body)
+	}
+	if out.String() != "> (builds) build passed\n" {
+		t.Errorf("got %q", out.String())
+	}
+
+	// Retried with the same key, it isn't sent again
+	status, header, again := post("ci", "build-1", `{"channel": "builds", "text": "build **passed**"}`)
+	if status != http.StatusOK || again != body || header.Get("Idempotent-Replayed") != "true" || slackPosts.Load() != 1 {
+		t.Errorf("expected the same response without sending, got %d %s, %d posts", status, again, slackPosts.Load())
+	}
+	status, _, _ = post("ci", "build-1", `{"channel": "builds", "text": "build failed"}`)
+	if status != http.StatusUnprocessableEntity {
+		t.Error("expected a reused key to be refused, got", status)
+	}
+	// Keys belong to a token
+	post("monitor", "build-1", `{"channel": "builds", "text": "build **passed**"}`)
+	if slackPosts.Load() != 2 {
+		t.Error("expected another token's key to be separate")
+	}
+
+	status, _, body = post("monitor", "", `{"channel": "builds", "text": "hi", "platforms": ["discord"]}`)
+	if status != http.StatusBadGateway || strings.Count(body, "platform") != 1 {
+		t.Error("expected only discord, which fails, got", status, body)
+	}
+
+	for _, c := range []struct {
+		token, body string
+		status      int
+	}{
+		{"wrong", `{"channel": "builds", "text": "hi"}`, http.StatusUnauthorized},
+		{"", `{"channel": "builds", "text": "hi"}`, http.StatusUnauthorized},
+		{"ci", `{"channel": "general", "text": "hi"}`, http.StatusForbidden},
+		{"ci", `{"channel": "builds", "thread": "123456789", "text": "hi"}`, http.StatusForbidden},
+		{"ci", `{"channel": "builds"}`, http.StatusBadRequest},
+		{"ci", `{"channel": `, http.StatusBadRequest},
+	} {
+		status, _, body := post(c.token, "", c.body)
+		if status != c.status {
+			t.Errorf("%s %s: expected %d, got %d %s", c.token, c.body, c.status, status, body)
+		}
+	}
+}
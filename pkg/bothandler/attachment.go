@@ -0,0 +1,212 @@
+package bothandler
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Files sent with messages are downloaded into memory by the platforms, up
+// to a size limit, and their type sniffed from the content, not trusted from
+// the platform. Handlers that need a file on disk call Attachment.Path, and
+// the file is removed once all handlers have seen the attachment.
+
+// DefaultMaxAttachmentSize is the most platforms download per attachment.
+const DefaultMaxAttachmentSize = 20 * 1024 * 1024
+
+// Attachment is a file sent with a message.
+type Attachment struct {
+	// Filename is the name it was sent with, and may be empty.
+	Filename string
+	// MimeType is sniffed from Data, e.g. "image/png".
+	MimeType string
+	Data     []byte
+	// URL is where the attachment is online, if it is. Platforms that can't
+	// upload link to it.
+	URL string
+
+	path string
+}
+
+// AttachmentHandler is a handler with the MIME types it accepts.
+type AttachmentHandler struct {
+	// Accept lists MIME types, or wildcards like "image/*". Empty accepts
+	// anything.
+	Accept []string
+	Handle ImageResponseHandler
+}
+
+// NewAttachment sniffs the type of data. declaredType, from the platform or
+// a Content-Type header, is used if the data doesn't say.
+func NewAttachment(filename string, data []byte, declaredType string) *Attachment {
+	mimeType := baseMimeType(http.DetectContentType(data))
+	if mimeType == "application/octet-stream" || mimeType == "text/plain" {
+		if t := baseMimeType(declaredType); t != "" {
+			mimeType = t
+		} else if t := baseMimeType(mime.TypeByExtension(filepath.Ext(filename))); t != "" {
+			mimeType = t
+		}
+	}
+	return &Attachment{Filename: filename, MimeType: mimeType, Data: data}
+}
+
+// FetchAttachment downloads the response to req, failing if it is bigger
+// than maxSize bytes. declaredType is the type the platform says the file
+// is, if any, otherwise the Content-Type is used when sniffing fails.
+func FetchAttachment(client *http.Client, req *http.Request, filename string, declaredType string, maxSize int) (*Attachment, error) {
+	get, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer get.Body.Close()
+	if get.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: %s", get.Status)
+	}
+	if get.ContentLength > int64(maxSize) {
+		return nil, fmt.Errorf("download bigger than %d bytes", maxSize)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(get.Body, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("download bigger than %d bytes", maxSize)
+	}
+	if declaredType == "" {
+		declaredType = get.Header.Get("Content-Type")
+	}
+	return NewAttachment(filename, data, declaredType), nil
+}
+
+// DownloadAttachment is FetchAttachment for a plain GET of url.
+func DownloadAttachment(client *http.Client, url string, filename string, declaredType string, maxSize int) (*Attachment, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return FetchAttachment(client, req, filename, declaredType, maxSize)
+}
+
+// Name is Filename, or base with an extension for the type if there is
+// none.
+func (a *Attachment) Name(base string) string {
+	if a.Filename != "" {
+		return a.Filename
+	}
+	if base == "" {
+		base = "image"
+	}
+	ext := "bin"
+	if exts, _ := mime.ExtensionsByType(a.MimeType); len(exts) > 0 {
+		ext = strings.TrimPrefix(exts[0], ".")
+	}
+	if a.MimeType == "image/jpeg" {
+		ext = "jpg"
+	}
+	return sanitizeFilename(base, ext)
+}
+
+// Fallback describes the attachment for platforms that can't upload it:
+// its URL, or its name, type and size.
+func (a *Attachment) Fallback(base string) string {
+	if a.URL != "" {
+		return a.URL
+	}
+	return fmt.Sprintf("[%s, %s, %d KB]", a.Name(base), a.MimeType, (len(a.Data)+1023)/1024)
+}
+
+// IsImage is whether the attachment is an image.
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.MimeType, "image/")
+}
+
+// Image decodes a PNG, JPEG or GIF attachment.
+func (a *Attachment) Image() (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(a.Data))
+	return img, err
+}
+
+// Path saves the attachment to a temp file, for handlers that need one. The
+// file is removed after all handlers have run.
+func (a *Attachment) Path() (string, error) {
+	if a.path != "" {
+		return a.path, nil
+	}
+	ext := filepath.Ext(a.Filename)
+	if ext == "" {
+		if exts, _ := mime.ExtensionsByType(a.MimeType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	f, err := os.CreateTemp("", "attachment-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(a.Data)
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	a.path = f.Name()
+	return a.path, nil
+}
+
+// Cleanup removes the temp file made by Path, if any.
+func (a *Attachment) Cleanup() {
+	if a.path == "" {
+		return
+	}
+	err := os.Remove(a.path)
+	if err != nil {
+		log.Println(err)
+	}
+	a.path = ""
+}
+
+// WantsAttachment is whether any handler accepts mimeType, so platforms can
+// skip downloading what nobody wants. An empty mimeType, when the platform
+// doesn't say, is wanted if there are any handlers.
+func WantsAttachment(mimeType string) bool {
+	for _, v := range AttachmentHandlers {
+		if mimeType == "" || acceptsMimeType(v.Accept, baseMimeType(mimeType)) {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptsMimeType(accept []string, mimeType string) bool {
+	if len(accept) == 0 {
+		return true
+	}
+	for _, v := range accept {
+		if v == mimeType || v == "*/*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(v, "/*"); ok && strings.HasPrefix(mimeType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// baseMimeType drops the parameters, "text/plain; charset=utf-8" is
+// "text/plain".
+func baseMimeType(t string) string {
+	t, _, _ = strings.Cut(t, ";")
+	return strings.ToLower(strings.TrimSpace(t))
+}
@@ -0,0 +1,132 @@
+package bothandler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// testWebP is a 1x1 lossless WebP, as Telegram stickers are.
+var testWebP = []byte("RIFF\x1a\x00\x00\x00WEBPVP8L\r\x00\x00\x00/\x00\x00\x00\x10\a\x10\x11\x11\x88\x88\xfe\a\x00")
+
+func TestAttachment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cat":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(testPNG)
+		case "/notes":
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			w.Write([]byte("# notes"))
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", 100)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		path         string
+		declaredType string
+		want         string
+		wantErr      bool
+	}{
+		{"sniffed", "/cat", "", "image/png", false},
+		{"sniffed-over-declared", "/cat", "application/pdf", "image/png", false},
+		{"header", "/notes", "", "text/markdown", false},
+		{"declared", "/notes", "text/x-go", "text/x-go", false},
+		{"too-big", "/big", "", "", true},
+		{"not-found", "/missing", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := DownloadAttachment(server.Client(), server.URL+tt.path, "file", tt.declaredType, 50)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v", err)
+			}
+			if err == nil && a.MimeType != tt.want {
+				t.Errorf("got %s want %s", a.MimeType, tt.want)
+			}
+		})
+	}
+}
+
+func TestDispatchAttachment(t *testing.T) {
+	oldHandlers := AttachmentHandlers
+	defer func() { AttachmentHandlers = oldHandlers }()
+	AttachmentHandlers = nil
+
+	var path string
+	RegisterImageHandler(func(a *Attachment, r Request) string {
+		var err error
+		path, err = a.Path()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(path, ".png") {
+			t.Errorf("temp file %s should be a .png", path)
+		}
+		b, _ := os.ReadFile(path)
+		return "image " + r.Content + " " + string(b[1:4])
+	})
+	RegisterAttachmentHandler([]string{"application/pdf"}, func(a *Attachment, r Request) string {
+		return "pdf " + a.Filename
+	})
+
+	if !WantsAttachment("image/jpeg") || !WantsAttachment("application/pdf; q=1") || WantsAttachment("audio/ogg") {
+		t.Error("WantsAttachment is wrong")
+	}
+
+	got := []string{}
+	reply := func(m *ExtendedMessage) { got = append(got, m.Text) }
+	DispatchAttachment(NewAttachment("", testPNG, ""), Request{Content: "caption"}, reply)
+	DispatchAttachment(NewAttachment("report.pdf", []byte("%PDF-1.4"), ""), Request{}, reply)
+	DispatchAttachment(NewAttachment("song.ogg", []byte("OggS\x00"), ""), Request{}, reply)
+	if strings.Join(got, "|") != "image caption PNG|pdf report.pdf" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("temp file %s wasn't cleaned up", path)
+	}
+}
+
+func TestImageResponse(t *testing.T) {
+	oldHandlers := AttachmentHandlers
+	defer func() { AttachmentHandlers = oldHandlers }()
+	AttachmentHandlers = nil
+
+	RegisterImageResponseHandler([]string{"image/*"}, func(a *Attachment, r Request) *ExtendedMessage {
+		return &ExtendedMessage{
+			Text:  "two cats",
+			Image: a.Data,
+			Images: []*Attachment{
+				{MimeType: "image/jpeg", URL: "https://example.com/cat.jpg"},
+			},
+		}
+	})
+
+	s, err := NewMessagePlatformFromHTTP("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []HTTPBotReply{}
+	s.dispatch(HTTPBotMessage{Text: "cat me"}, testPNG, func(r HTTPBotReply) { got = append(got, r) }, false)
+	if len(got) != 2 || got[0].Text != "two cats" || got[0].Image == "" ||
+		got[1].Text != "" || got[1].ImageURL != "https://example.com/cat.jpg" {
+		t.Errorf("got %+v", got)
+	}
+
+	a := NewAttachment("", testPNG, "")
+	if a.Name("a cat") != "a_cat.png" {
+		t.Errorf("name %q", a.Name("a cat"))
+	}
+	if a.Fallback("cat") != "[cat.png, image/png, 1 KB]" {
+		t.Errorf("fallback %q", a.Fallback("cat"))
+	}
+}
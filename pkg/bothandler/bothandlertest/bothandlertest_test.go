@@ -0,0 +1,79 @@
+package bothandlertest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angch/multibot/pkg/bothandler"
+)
+
+func TestConversation(t *testing.T) {
+	oldInput, oldAttachmentHandlers, oldCallbacks := bothandler.MsgInputHandlers, bothandler.AttachmentHandlers, bothandler.CallbackHandlers
+	defer func() {
+		bothandler.MsgInputHandlers, bothandler.AttachmentHandlers, bothandler.CallbackHandlers = oldInput, oldAttachmentHandlers, oldCallbacks
+	}()
+	bothandler.MsgInputHandlers = map[string]bothandler.MessageWithInputHandler{"!roll": func(r bothandler.Request) string {
+		if r.Channel != "games" {
+			return ""
+		}
+		bothandler.ChannelMessageSend("log", r.From+" rolled")
+		return "rolled " + r.Content
+	}}
+	bothandler.AttachmentHandlers = nil
+	bothandler.RegisterImageResponseHandler([]string{"image/*"}, func(a *bothandler.Attachment, r bothandler.Request) *bothandler.ExtendedMessage {
+		return &bothandler.ExtendedMessage{Text: "flipped", Image: a.Data, Buttons: []bothandler.Button{{Text: "Again", Plugin: "flip", Data: "again"}}}
+	})
+	bothandler.CallbackHandlers = map[string]bothandler.CallbackHandler{"flip": func(r bothandler.Request) *bothandler.ExtendedMessage {
+		return &bothandler.ExtendedMessage{Text: "flipped " + r.Content}
+	}}
+
+	conv := NewConversation(t)
+	alice := conv.User("alice", "discord", "games")
+	bob := conv.User("bob", "discord", "general")
+
+	alice.Says("!roll d6").Expect(`^rolled d6$`)
+	bob.Says("!roll d6").ExpectNothing()
+	alice.Attach("cat.png", []byte("\x89PNG\r\n\x1a\n"), "flip it").Expect("flipped").ExpectImages(1)
+	alice.Press("Again").Expect("flipped again")
+
+	want := strings.Join([]string{
+		"alice@discord/games: !roll d6",
+		"> rolled d6",
+		"* (log) alice rolled",
+		"bob@discord/general: !roll d6",
+		"alice@discord/games: [cat.png, image/png] flip it",
+		"> flipped",
+		"> [image.png, image/png, 1 KB]",
+		"> [Again]",
+		"alice@discord/games: [press Again]",
+		"> flipped again",
+		"",
+	}, "\n")
+	if got := conv.Transcript(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(t, start)
+
+	woke := make(chan time.Time)
+	go func() {
+		bothandler.Sleep(time.Hour)
+		woke <- bothandler.Now()
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Minute)
+	select {
+	case <-woke:
+		t.Fatal("woke too early")
+	default:
+	}
+	clock.Advance(30 * time.Minute)
+	if got := <-woke; !got.Equal(start.Add(time.Hour)) {
+		t.Errorf("woke at %v", got)
+	}
+}
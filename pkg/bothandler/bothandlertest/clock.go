@@ -0,0 +1,100 @@
+package bothandlertest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/angch/multibot/pkg/bothandler"
+)
+
+// Clock is a fake bothandler.Clock. Time only moves when the test calls
+// Advance or Set, and Sleep returns when it has moved far enough.
+type Clock struct {
+	lock     sync.Mutex
+	now      time.Time
+	sleepers []sleeper
+	changed  chan struct{}
+}
+
+type sleeper struct {
+	until time.Time
+	wake  chan struct{}
+}
+
+// NewClock makes a Clock starting at now the bot's clock for the duration of
+// the test.
+func NewClock(t testing.TB, now time.Time) *Clock {
+	c := &Clock{now: now, changed: make(chan struct{})}
+	old := bothandler.SetClock(c)
+	t.Cleanup(func() { bothandler.SetClock(old) })
+	return c
+}
+
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *Clock) Sleep(d time.Duration) {
+	c.lock.Lock()
+	if d <= 0 {
+		c.lock.Unlock()
+		return
+	}
+	s := sleeper{c.now.Add(d), make(chan struct{})}
+	c.sleepers = append(c.sleepers, s)
+	c.notify()
+	c.lock.Unlock()
+	<-s.wake
+}
+
+// Advance moves the clock forward by d, waking sleepers that are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Set moves the clock to now, waking sleepers that are due.
+func (c *Clock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.set(now)
+}
+
+func (c *Clock) set(now time.Time) {
+	c.now = now
+	waiting := c.sleepers[:0]
+	for _, s := range c.sleepers {
+		if now.Before(s.until) {
+			waiting = append(waiting, s)
+		} else {
+			close(s.wake)
+		}
+	}
+	c.sleepers = waiting
+	c.notify()
+}
+
+// notify must be called with the lock held.
+func (c *Clock) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// BlockUntil waits until n goroutines are sleeping, so the test knows a
+// scheduled plugin has done its work before moving the clock again.
+func (c *Clock) BlockUntil(n int) {
+	for {
+		c.lock.Lock()
+		if len(c.sleepers) >= n {
+			c.lock.Unlock()
+			return
+		}
+		changed := c.changed
+		c.lock.Unlock()
+		<-changed
+	}
+}
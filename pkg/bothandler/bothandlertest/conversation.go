@@ -0,0 +1,208 @@
+package bothandlertest
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/angch/multibot/pkg/bothandler"
+)
+
+var update = flag.Bool("update", false, "update golden transcripts")
+
+// Conversation sends messages through the registered handlers and keeps a
+// transcript of them, their replies, and whatever plugins sent by
+// themselves in between.
+type Conversation struct {
+	t          testing.TB
+	Platform   *Platform
+	transcript strings.Builder
+	buttons    []bothandler.Button
+}
+
+// NewConversation starts a conversation with a fresh Platform.
+func NewConversation(t testing.TB) *Conversation {
+	return &Conversation{t: t, Platform: NewPlatform(t)}
+}
+
+// User is someone talking in a channel of a platform.
+type User struct {
+	c        *Conversation
+	Name     string
+	Platform string
+	Channel  string
+}
+
+// User returns name talking in channel on platform, e.g. "discord" and a
+// channel ID, to test plugins that only answer in some channels.
+func (c *Conversation) User(name, platform, channel string) *User {
+	return &User{c, name, platform, channel}
+}
+
+// Result is the replies to one message.
+type Result struct {
+	t       testing.TB
+	said    string
+	Replies []*bothandler.ExtendedMessage
+}
+
+// Says sends text and returns the replies.
+func (u *User) Says(text string) *Result {
+	u.c.t.Helper()
+	content, entities := bothandler.NormalizeText(text)
+	request := bothandler.Request{Content: content, Platform: u.Platform, Channel: u.Channel, From: u.Name, Entities: entities}
+	return u.c.run(u.prefix()+text, func(reply bothandler.Replier) {
+		bothandler.Dispatch(request, reply)
+	})
+}
+
+// Attach sends a file with a caption and returns the replies.
+func (u *User) Attach(filename string, data []byte, caption string) *Result {
+	u.c.t.Helper()
+	a := bothandler.NewAttachment(filename, data, "")
+	request := bothandler.Request{Content: caption, Platform: u.Platform, Channel: u.Channel, From: u.Name}
+	said := fmt.Sprintf("%s[%s, %s] %s", u.prefix(), filename, a.MimeType, caption)
+	return u.c.run(strings.TrimSpace(said), func(reply bothandler.Replier) {
+		bothandler.DispatchAttachment(a, request, reply)
+	})
+}
+
+// Press clicks the button labelled text on the last reply that had buttons.
+func (u *User) Press(text string) *Result {
+	u.c.t.Helper()
+	for _, b := range u.c.buttons {
+		if b.Text != text {
+			continue
+		}
+		h, ok := bothandler.CallbackHandlers[b.Plugin]
+		if !ok {
+			u.c.t.Fatalf("no callback handler for %s", b.Plugin)
+		}
+		request := bothandler.Request{Content: b.Data, Platform: u.Platform, Channel: u.Channel, From: u.Name}
+		return u.c.run(u.prefix()+"[press "+text+"]", func(reply bothandler.Replier) {
+			if r := h(request); r != nil {
+				reply(r)
+			}
+		})
+	}
+	u.c.t.Fatalf("no button %q", text)
+	return nil
+}
+
+func (u *User) prefix() string {
+	return fmt.Sprintf("%s@%s/%s: ", u.Name, u.Platform, u.Channel)
+}
+
+func (c *Conversation) run(said string, dispatch func(bothandler.Replier)) *Result {
+	c.flush()
+	c.transcript.WriteString(said + "\n")
+	r := &Result{t: c.t, said: said}
+	dispatch(func(m *bothandler.ExtendedMessage) {
+		r.Replies = append(r.Replies, m)
+		c.record(m)
+	})
+	return r
+}
+
+func (c *Conversation) record(m *bothandler.ExtendedMessage) {
+	if m.Text != "" {
+		for _, line := range strings.Split(bothandler.RenderPlain(m.Text), "\n") {
+			c.transcript.WriteString("> " + line + "\n")
+		}
+	}
+	for _, a := range m.AllImages() {
+		c.transcript.WriteString("> " + a.Fallback("image") + "\n")
+	}
+	if len(m.Buttons) > 0 {
+		c.buttons = m.Buttons
+		for _, b := range m.Buttons {
+			c.transcript.WriteString("> [" + b.Text + "]\n")
+		}
+	}
+}
+
+// flush adds what plugins sent by themselves to the transcript.
+func (c *Conversation) flush() {
+	for _, m := range c.Platform.Messages() {
+		c.transcript.WriteString(m.String() + "\n")
+	}
+}
+
+// Transcript is the conversation so far.
+func (c *Conversation) Transcript() string {
+	c.flush()
+	return c.transcript.String()
+}
+
+// Golden compares the transcript to the file, or writes it with -update.
+func (c *Conversation) Golden(filename string) {
+	c.t.Helper()
+	got := c.Transcript()
+	if *update {
+		err := os.MkdirAll(filepath.Dir(filename), 0755)
+		if err == nil {
+			err = os.WriteFile(filename, []byte(got), 0644)
+		}
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(filename)
+	if err != nil {
+		c.t.Fatalf("%v, run with -update to create it", err)
+	}
+	if got != string(want) {
+		c.t.Errorf("transcript differs from %s, run with -update if expected\ngot:\n%s\nwant:\n%s", filename, got, want)
+	}
+}
+
+// Text is the text of all replies, one per line.
+func (r *Result) Text() string {
+	out := []string{}
+	for _, m := range r.Replies {
+		if m.Text != "" {
+			out = append(out, m.Text)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// Expect fails the test unless a reply matches the regular expression.
+func (r *Result) Expect(pattern string) *Result {
+	r.t.Helper()
+	re := regexp.MustCompile(pattern)
+	for _, m := range r.Replies {
+		if re.MatchString(m.Text) {
+			return r
+		}
+	}
+	r.t.Errorf("%s: no reply matches %q, got %q", r.said, pattern, r.Text())
+	return r
+}
+
+// ExpectNothing fails the test if there were any replies.
+func (r *Result) ExpectNothing() *Result {
+	r.t.Helper()
+	if len(r.Replies) > 0 {
+		r.t.Errorf("%s: expected no reply, got %q", r.said, r.Text())
+	}
+	return r
+}
+
+// ExpectImages fails the test unless the replies have n images in all.
+func (r *Result) ExpectImages(n int) *Result {
+	r.t.Helper()
+	got := 0
+	for _, m := range r.Replies {
+		got += len(m.AllImages())
+	}
+	if got != n {
+		r.t.Errorf("%s: expected %d images, got %d", r.said, n, got)
+	}
+	return r
+}
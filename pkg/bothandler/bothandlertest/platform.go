@@ -0,0 +1,114 @@
+// Package bothandlertest helps test plugins the way users see them: through
+// dispatch, with the platform, channel and user they would talk from, and
+// with the time under the test's control.
+//
+//	conv := bothandlertest.NewConversation(t)
+//	alice := conv.User("alice", "discord", "1127471366501834763")
+//	alice.Says("init FOO").Expect(`Registered`)
+//	alice.Says("hello").ExpectNothing()
+//	conv.Golden("testdata/init.golden")
+package bothandlertest
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/angch/multibot/pkg/bothandler"
+)
+
+// Message is something a plugin sent by itself, not as a reply.
+type Message struct {
+	// Channel is set for ChannelMessageSend and SendMessage, and empty for
+	// Send.
+	Channel string
+	Text    string
+	Silent  bool
+}
+
+func (m Message) String() string {
+	switch {
+	case m.Channel != "" && m.Silent:
+		return fmt.Sprintf("* (%s, silent) %s", m.Channel, m.Text)
+	case m.Channel != "":
+		return fmt.Sprintf("* (%s) %s", m.Channel, m.Text)
+	case m.Silent:
+		return "* (silent) " + m.Text
+	}
+	return "* " + m.Text
+}
+
+// Platform is a fake MessagePlatform that records what plugins send.
+type Platform struct {
+	// Name is the platform's name for bothandler.SendAll, "test" by
+	// default.
+	Name string
+
+	lock     sync.Mutex
+	messages []Message
+}
+
+// NewPlatform registers a Platform for the duration of the test.
+func NewPlatform(t testing.TB) *Platform {
+	p := &Platform{Name: "test"}
+	bothandler.RegisterMessagePlatform(p)
+	t.Cleanup(func() {
+		bothandler.ActiveMessagePlatforms = slices.DeleteFunc(bothandler.ActiveMessagePlatforms, func(m bothandler.MessagePlatform) bool {
+			return m == p
+		})
+	})
+	return p
+}
+
+func (p *Platform) Send(text string) {
+	p.SendWithOptions(text, bothandler.SendOptions{})
+}
+
+func (p *Platform) SendWithOptions(text string, options bothandler.SendOptions) {
+	p.record(Message{Text: text, Silent: options.Silent})
+}
+
+func (p *Platform) ProcessMessages() {}
+
+func (p *Platform) Close() {}
+
+func (p *Platform) ChannelMessageSend(channel, message string) error {
+	p.record(Message{Channel: channel, Text: message})
+	return nil
+}
+
+func (p *Platform) PlatformName() string {
+	return p.Name
+}
+
+// SendMessage records the text, with the user for direct messages as the
+// channel. Files are described after the text.
+func (p *Platform) SendMessage(m bothandler.OutboundMessage) error {
+	channel := m.Channel
+	if m.User != "" {
+		channel = "@" + m.User
+	}
+	text := m.Text
+	for _, a := range m.Files {
+		text = strings.TrimSpace(text + " " + a.Fallback("file"))
+	}
+	p.record(Message{Channel: channel, Text: text, Silent: m.Silent})
+	return nil
+}
+
+func (p *Platform) record(m Message) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.messages = append(p.messages, m)
+}
+
+// Messages returns what was sent since the last call.
+func (p *Platform) Messages() []Message {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	m := p.messages
+	p.messages = nil
+	return m
+}
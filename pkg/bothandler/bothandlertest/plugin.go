@@ -0,0 +1,22 @@
+package bothandlertest
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/angch/multibot/pkg/bothandler"
+)
+
+// InitPlugin initializes a plugin, registering its handlers, as the bot does
+// when it runs. Plugins are only initialized once, however many tests call
+// this.
+func InitPlugin(t testing.TB, name string) {
+	t.Helper()
+	err := bothandler.InitPlugins([]string{name}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(bothandler.EnabledPlugins(), name) {
+		t.Fatalf("plugin %s is disabled", name)
+	}
+}
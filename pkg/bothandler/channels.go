@@ -0,0 +1,179 @@
+package bothandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KnownChat is a chat the bot has seen or been added to.
+type KnownChat struct {
+	Platform string
+	ID       string
+	Title    string
+	Type     string
+	Username string
+}
+
+// ChannelRegistry remembers the chats seen on each platform, and logical
+// names (aliases) for them, so sendmsg and scheduled posts can target chats
+// that aren't hardcoded in engineersmy.
+type ChannelRegistry struct {
+	lock     sync.RWMutex
+	filename string
+
+	Chats   map[string]KnownChat         // platform/id -> chat
+	Aliases map[string]map[string]string // platform -> alias -> id
+}
+
+// Channels is the registry used by the platforms. It is only persisted once
+// Load is called.
+var Channels = NewChannelRegistry()
+
+func NewChannelRegistry() *ChannelRegistry {
+	return &ChannelRegistry{
+		Chats:   map[string]KnownChat{},
+		Aliases: map[string]map[string]string{},
+	}
+}
+
+// Load reads the registry from filename, and saves to it on every change.
+// A missing file is not an error.
+func (r *ChannelRegistry) Load(filename string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.filename = filename
+
+	b, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(b, r)
+	if err != nil {
+		return err
+	}
+	if r.Chats == nil {
+		r.Chats = map[string]KnownChat{}
+	}
+	if r.Aliases == nil {
+		r.Aliases = map[string]map[string]string{}
+	}
+	return nil
+}
+
+// save must be called with the lock held.
+func (r *ChannelRegistry) save() {
+	if r.filename == "" {
+		return
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = os.WriteFile(r.filename, b, 0644)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// See records a chat, saving only if it is new or changed.
+func (r *ChannelRegistry) See(chat KnownChat) {
+	key := chat.Platform + "/" + chat.ID
+	r.lock.RLock()
+	old, ok := r.Chats[key]
+	r.lock.RUnlock()
+	if ok && old == chat {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Chats[key] = chat
+	r.save()
+}
+
+// Alias names a chat, so it can be used as a channel in ChannelMessageSend.
+func (r *ChannelRegistry) Alias(platform, alias, id string) error {
+	if alias == "" || id == "" {
+		return fmt.Errorf("need both an alias and a chat")
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.Aliases[platform] == nil {
+		r.Aliases[platform] = map[string]string{}
+	}
+	r.Aliases[platform][alias] = id
+	r.save()
+	return nil
+}
+
+func (r *ChannelRegistry) Unalias(platform, alias string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, ok := r.Aliases[platform][alias]
+	if !ok {
+		return false
+	}
+	delete(r.Aliases[platform], alias)
+	r.save()
+	return true
+}
+
+// Resolve returns the chat id for an alias, or for the id or @username of a
+// known chat.
+func (r *ChannelRegistry) Resolve(platform, name string) (string, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	id, ok := r.Aliases[platform][name]
+	if ok {
+		return id, true
+	}
+	_, ok = r.Chats[platform+"/"+name]
+	if ok {
+		return name, true
+	}
+	username := strings.TrimPrefix(name, "@")
+	for _, v := range r.Chats {
+		if v.Platform == platform && v.Username != "" && strings.EqualFold(v.Username, username) {
+			return v.ID, true
+		}
+	}
+	return "", false
+}
+
+// Chat returns a known chat by id.
+func (r *ChannelRegistry) Chat(platform, id string) (KnownChat, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	chat, ok := r.Chats[platform+"/"+id]
+	return chat, ok
+}
+
+// List returns the known chats on a platform, with their aliases.
+func (r *ChannelRegistry) List(platform string) ([]KnownChat, map[string][]string) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	chats := []KnownChat{}
+	for _, v := range r.Chats {
+		if v.Platform == platform {
+			chats = append(chats, v)
+		}
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i].ID < chats[j].ID })
+	aliases := map[string][]string{}
+	for alias, id := range r.Aliases[platform] {
+		aliases[id] = append(aliases[id], alias)
+	}
+	for _, v := range aliases {
+		sort.Strings(v)
+	}
+	return chats, aliases
+}
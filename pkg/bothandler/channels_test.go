@@ -0,0 +1,50 @@
+package bothandler
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestChannelRegistry(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "channels.js")
+	r := NewChannelRegistry()
+	if err := r.Load(filename); err != nil {
+		t.Fatal(err)
+	}
+	r.See(KnownChat{Platform: "telegram", ID: "-100123", Title: "EngineersMY", Type: "supergroup", Username: "EngineersMY"})
+	r.See(KnownChat{Platform: "telegram", ID: "42", Title: "Alice", Type: "private"})
+	if err := r.Alias("telegram", "offtopic", "-100123"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reload from disk
+	r = NewChannelRegistry()
+	if err := r.Load(filename); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		platform string
+		name     string
+		want     string
+		ok       bool
+	}{
+		{"telegram", "offtopic", "-100123", true},
+		{"telegram", "42", "42", true},
+		{"telegram", "@engineersmy", "-100123", true},
+		{"telegram", "general", "", false},
+		{"discord", "offtopic", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := r.Resolve(tt.platform, tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Resolve(%q, %q) = %q, %v, want %q, %v", tt.platform, tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if !r.Unalias("telegram", "offtopic") {
+		t.Error("Unalias failed")
+	}
+	if _, ok := r.Resolve("telegram", "offtopic"); ok {
+		t.Error("alias still resolves after Unalias")
+	}
+}
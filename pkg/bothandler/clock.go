@@ -0,0 +1,75 @@
+package bothandler
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time as plugins see it. Plugins that do things daily or on
+// a schedule use Now and Sleep instead of the time package, so tests can
+// swap in a fake clock (see bothandlertest).
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+var clock Clock = systemClock{}
+var clockLock sync.RWMutex
+
+// SetClock replaces the clock, returning the old one.
+func SetClock(c Clock) Clock {
+	clockLock.Lock()
+	defer clockLock.Unlock()
+	old := clock
+	clock = c
+	return old
+}
+
+func currentClock() Clock {
+	clockLock.RLock()
+	defer clockLock.RUnlock()
+	return clock
+}
+
+// Now is the current time on the bot's clock.
+func Now() time.Time {
+	return currentClock().Now()
+}
+
+// Sleep waits for d on the bot's clock.
+func Sleep(d time.Duration) {
+	currentClock().Sleep(d)
+}
+
+// SleepOrDone waits for d on the bot's clock, or until done is closed, and
+// returns false if done was closed.
+func SleepOrDone(d time.Duration, done <-chan struct{}) bool {
+	c := currentClock()
+	if _, ok := c.(systemClock); ok {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	slept := make(chan struct{})
+	go func() {
+		c.Sleep(d)
+		close(slept)
+	}()
+	select {
+	case <-slept:
+		return true
+	case <-done:
+		return false
+	}
+}
@@ -0,0 +1,28 @@
+package bothandler
+
+import (
+	"testing"
+	"time"
+)
+
+// stoppedClock never wakes its sleepers.
+type stoppedClock struct{}
+
+func (stoppedClock) Now() time.Time      { return time.Time{} }
+func (stoppedClock) Sleep(time.Duration) { select {} }
+
+func TestSleepOrDone(t *testing.T) {
+	done := make(chan struct{})
+	if !SleepOrDone(time.Millisecond, done) {
+		t.Error("expected to sleep until the timer")
+	}
+	close(done)
+	if SleepOrDone(time.Hour, done) {
+		t.Error("expected to stop sleeping once done")
+	}
+
+	defer SetClock(SetClock(stoppedClock{}))
+	if SleepOrDone(time.Hour, done) {
+		t.Error("expected to stop sleeping on the bot's clock once done")
+	}
+}
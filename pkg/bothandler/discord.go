@@ -3,11 +3,9 @@ package bothandler
 import (
 	"bytes"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"strings"
+	"strconv"
 
 	"github.com/angch/multibot/pkg/engineersmy"
 	"github.com/bwmarrin/discordgo"
@@ -59,162 +57,105 @@ func (dg *DiscordMessagePlatform) SendWithOptions(text string, options SendOptio
 	if dg == nil {
 		return
 	}
-	if options.Silent {
-		// FIXME: Figuure out how to use ChannelMessageSendComplex to send silent messages
-		_, err := dg.Session.ChannelMessageSend(dg.Channels[""], text)
-		if err != nil {
-			log.Println(err)
-		}
-	} else {
-		_, err := dg.Session.ChannelMessageSend(dg.Channels[""], text)
+	// FIXME: Figuure out how to use ChannelMessageSendComplex to send silent messages
+	for _, page := range SplitMessage(text, discordMaxMessage, runeSize) {
+		_, err := dg.Session.ChannelMessageSend(dg.Channels[""], page)
 		if err != nil {
 			log.Println(err)
 		}
 	}
 }
 
+// Discord takes up to 10 files per message.
+const discordMaxFiles = 10
+
 func discordMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 	if m.Author.ID == s.State.User.ID {
 		return
 	}
 
-	// FIXME: This can be better
-	// Part of first stage refac
-	h, ok := Handlers[m.Content]
-	if ok {
-		response := h()
+	names := map[string]string{}
+	for _, v := range m.Mentions {
+		names[v.ID] = v.Username
+	}
+	for _, v := range m.MentionChannels {
+		names[v.ID] = v.Name
+	}
+	for _, id := range m.MentionRoles {
+		if role, err := s.State.Role(m.GuildID, id); err == nil {
+			names[id] = role.Name
+		}
+	}
+	content, entities := NormalizeDiscord(m.Content, names)
+
+	username, userId := "", ""
+	if m.Author != nil {
+		username, userId = m.Author.Username, m.Author.ID
+	}
+	request := Request{Content: content, Platform: "discord", Channel: m.ChannelID, From: username, UserId: userId, Entities: entities}
+	reply := func(text string) {
 		_, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
-			Content:   response,
+			Content:   Paginate(request, text, discordMaxMessage, runeSize),
 			Reference: m.Reference(),
 		})
 		if err != nil {
 			log.Println(err)
 		}
 	}
-
-	// Can be better to decouple 1 to 1 of message : response
-	for _, v := range CatchallHandlers {
-		username := ""
-		if m.Author != nil {
-			username = m.Author.Username
+	replyImages := func(images []*Attachment) {
+		files := []*discordgo.File{}
+		for _, a := range images {
+			if len(a.Data) == 0 {
+				reply(a.Fallback(content))
+				continue
+			}
+			files = append(files, &discordgo.File{
+				Name:        a.Name(content),
+				ContentType: a.MimeType,
+				Reader:      bytes.NewReader(a.Data),
+			})
 		}
-		r := v(Request{m.Content, "discord", m.ChannelID, username})
-		if r != "" {
-			_, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
-				Content:   r,
+		for len(files) > 0 {
+			n := min(len(files), discordMaxFiles)
+			msg := &discordgo.MessageSend{
+				Content:   content,
 				Reference: m.Reference(),
-			})
+				Files:     files[:n],
+			}
+			_, err := s.ChannelMessageSendComplex(m.ChannelID, msg)
 			if err != nil {
 				log.Println(err)
 			}
+			files = files[n:]
 		}
 	}
 
-	// Can be better to decouple 1 to 1 of message : response
-	for _, v := range CatchallExtendedHandlers {
-		r := v(ExtendedMessage{Text: m.Content})
-		if r != nil {
-			if r.Text != "" {
-				_, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
-					Content:   r.Text,
-					Reference: m.Reference(),
-				})
-				if err != nil {
-					log.Println(err)
-				}
-			}
-			if r.Image != nil {
-				fileImage := discordgo.File{
-					Name: sanitizeFilename(m.Content, "png"),
-					// ContentType: "image/jpeg",
-					Reader: bytes.NewReader(r.Image),
-				}
-				msg := &discordgo.MessageSend{
-					Content:   m.Content,
-					Reference: m.Reference(),
-					Files:     []*discordgo.File{&fileImage},
-				}
-				_, err := s.ChannelMessageSendComplex(m.ChannelID, msg)
-				if err != nil {
-					log.Println(err)
-				}
-			}
+	Dispatch(request, func(r *ExtendedMessage) {
+		if r.Text != "" {
+			reply(r.Text)
 		}
-	}
-
-	sliced_content := strings.SplitN(m.Content, " ", 2)
-	if len(sliced_content) > 1 {
-		command := sliced_content[0]
-		actual_content := sliced_content[1]
+		replyImages(r.AllImages())
+	})
 
-		username := ""
-		if m.Author != nil {
-			username = m.Author.Username
+	for _, v := range m.Attachments {
+		if v == nil || !WantsAttachment(v.ContentType) {
+			continue
 		}
-
-		ih, ok := MsgInputHandlers[command]
-		if ok {
-			response := ih(Request{actual_content, "discord", m.ChannelID, username})
-			if response != "" {
-				_, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
-					Content:   response,
-					Reference: m.Reference(),
-				})
-				if err != nil {
-					log.Println(err)
-				}
-			}
+		if v.Size > DefaultMaxAttachmentSize {
+			log.Println("Attachment too big, skipping", v.Filename, v.Size)
+			continue
 		}
-	}
-
-	if m.Attachments != nil {
-		targetPixels := 512 * 512
-		best := &discordgo.MessageAttachment{}
-		bestSize := 100000000
-
-		for _, v := range m.Attachments {
-			if v == nil {
-				continue
-			}
-			pixels := v.Height * v.Width
-			diff := targetPixels - pixels
-			if diff < 0 {
-				diff = -diff
-			}
-			if diff < bestSize {
-				bestSize = diff
-				best = v
-			}
-			log.Printf("photosize %+v\n", best)
-			// FIXME:
-			filename := "tmp/" + best.ID
-			err := botDownload(best, filename)
-			if err != nil {
-				log.Println(err)
-			}
-			for _, v := range ImageHandlers {
-				username := ""
-				if m.Author != nil {
-					username = m.Author.Username
-				}
-
-				req := Request{m.Content, "discord", m.ChannelID, username}
-				r := v(filename, req)
-				if r != "" {
-					_, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
-						Content:   r,
-						Reference: m.Reference(),
-					})
-					if err != nil {
-						log.Println(err)
-					}
-				}
-			}
-
-			if false {
-				os.Remove(filename)
-			}
+		a, err := DownloadAttachment(http.DefaultClient, v.URL, v.Filename, v.ContentType, DefaultMaxAttachmentSize)
+		if err != nil {
+			log.Println(err)
+			continue
 		}
+		DispatchAttachment(a, request, func(r *ExtendedMessage) {
+			if r.Text != "" {
+				reply(r.Text)
+			}
+			replyImages(r.AllImages())
+		})
 	}
 }
 
@@ -234,36 +175,91 @@ func (s *DiscordMessagePlatform) ChannelMessageSend(channel, message string) err
 		log.Println("Unknown channel", channel)
 		return fmt.Errorf("unknown channel %s", channel)
 	}
-	_, err := s.Session.ChannelMessageSend(channelId, message)
-
-	return err
+	// Nobody to say !more, send it all
+	for _, page := range SplitMessage(message, discordMaxMessage, runeSize) {
+		_, err := s.Session.ChannelMessageSend(channelId, page)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func botDownload(attachment *discordgo.MessageAttachment, localFilename string) error {
-	if attachment == nil {
-		return fmt.Errorf("no attachment")
+// NewSenderFromDiscord is for sending only: it uses the REST API without
+// connecting to the gateway.
+func NewSenderFromDiscord(discordtoken string) (*DiscordMessagePlatform, error) {
+	dg, err := discordgo.New("Bot " + discordtoken)
+	if err != nil {
+		return nil, err
 	}
+	me, err := dg.User("@me")
+	if err != nil {
+		return nil, err
+	}
+	return &DiscordMessagePlatform{
+		Session:  dg,
+		Channels: engineersmy.KnownDiscordChannels,
+		Me:       me,
+	}, nil
+}
 
-	downloadUrl := attachment.URL
-	log.Println("Downloading", downloadUrl)
-
-	get, err := http.Get(downloadUrl)
-	if err != nil || get == nil || get.Body == nil {
-		log.Println(err)
-		return err
+// resolveChannel finds the channel ID for a channel registry alias, one of
+// engineersmy's channels, or an ID.
+func (s *DiscordMessagePlatform) resolveChannel(channel string) (string, error) {
+	if id, ok := Channels.Resolve("discord", channel); ok {
+		return id, nil
 	}
-	reader := get.Body
-	defer reader.Close()
+	if id, ok := s.Channels[channel]; ok {
+		return id, nil
+	}
+	if _, err := strconv.ParseUint(channel, 10, 64); err == nil {
+		return channel, nil
+	}
+	return "", fmt.Errorf("unknown channel %s", channel)
+}
 
-	out, err := os.Create(localFilename)
+// SendMessage sends to a channel, a thread (which Discord treats as a
+// channel), or a user ID for a direct message.
+func (s *DiscordMessagePlatform) SendMessage(m OutboundMessage) error {
+	var channelId string
+	var err error
+	switch {
+	case m.User != "":
+		var dm *discordgo.Channel
+		dm, err = s.Session.UserChannelCreate(m.User)
+		if dm != nil {
+			channelId = dm.ID
+		}
+	case m.Thread != "":
+		channelId = m.Thread
+	default:
+		channelId, err = s.resolveChannel(m.Channel)
+	}
 	if err != nil {
 		return err
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, reader)
-	if err != nil {
-		return err
+	var flags discordgo.MessageFlags
+	if m.Silent {
+		flags = discordgo.MessageFlagsSuppressNotifications
+	}
+	for _, page := range outboundPages(m.Text, discordMaxMessage, runeSize) {
+		_, err := s.Session.ChannelMessageSendComplex(channelId, &discordgo.MessageSend{Content: page, Flags: flags})
+		if err != nil {
+			return err
+		}
+	}
+	files := []*discordgo.File{}
+	for _, a := range m.Files {
+		files = append(files, &discordgo.File{Name: a.Name("file"), ContentType: a.MimeType, Reader: bytes.NewReader(a.Data)})
+	}
+	for len(files) > 0 {
+		n := min(len(files), discordMaxFiles)
+		_, err := s.Session.ChannelMessageSendComplex(channelId, &discordgo.MessageSend{Files: files[:n], Flags: flags})
+		if err != nil {
+			return err
+		}
+		files = files[n:]
 	}
 	return nil
 }
@@ -0,0 +1,87 @@
+package bothandler
+
+import (
+	"strings"
+)
+
+// Replier sends one response back to where a message came from.
+type Replier func(*ExtendedMessage)
+
+// Dispatch runs a text message through the registered handlers, calling
+// reply for each non-empty response. The order is the one the platforms
+// have always used: Handlers, CatchallHandlers, CatchallExtendedHandlers,
+// then MsgInputHandlers. Every platform dispatches through here, so the
+// recorder sees everything.
+func Dispatch(r Request, reply Replier) {
+	if rec := currentRecorder(); rec != nil {
+		var done func()
+		reply, done = rec.record(r, nil, reply)
+		defer done()
+	}
+	dispatch(r, reply)
+}
+
+func dispatch(r Request, reply Replier) {
+	content := r.Content
+	h, ok := Handlers[content]
+	if ok {
+		response := h()
+		if response != "" {
+			reply(&ExtendedMessage{Text: response})
+		}
+	}
+
+	for _, v := range CatchallHandlers {
+		response := v(r)
+		if response != "" {
+			reply(&ExtendedMessage{Text: response})
+		}
+	}
+
+	for _, v := range CatchallExtendedHandlers {
+		response := v(ExtendedMessage{Text: content, Platform: r.Platform, Entities: r.Entities})
+		if response != nil && (response.Text != "" || response.HasImages()) {
+			reply(response)
+		}
+	}
+
+	sliced_content := strings.SplitN(content, " ", 2)
+	if len(sliced_content) > 1 {
+		command := sliced_content[0]
+		actual_content := sliced_content[1]
+
+		ih, ok := MsgInputHandlers[command]
+		if ok {
+			input := r
+			input.Content = actual_content
+			response := ih(input)
+			if response != "" {
+				reply(&ExtendedMessage{Text: response})
+			}
+		}
+	}
+}
+
+// DispatchAttachment runs an attachment through the handlers that accept
+// its type, then cleans it up. r.Content is the caption.
+func DispatchAttachment(a *Attachment, r Request, reply Replier) {
+	if rec := currentRecorder(); rec != nil {
+		var done func()
+		reply, done = rec.record(r, a, reply)
+		defer done()
+	}
+	dispatchAttachment(a, r, reply)
+}
+
+func dispatchAttachment(a *Attachment, r Request, reply Replier) {
+	defer a.Cleanup()
+	for _, v := range AttachmentHandlers {
+		if !acceptsMimeType(v.Accept, a.MimeType) {
+			continue
+		}
+		response := v.Handle(a, r)
+		if response != nil && (response.Text != "" || response.HasImages()) {
+			reply(response)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package bothandler
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Handlers write responses in one neutral markup, the markdown subset that
+// Discord, Mattermost and Zulip render natively:
+//
+//	**bold**, *italic* or _italic_, ~~strike~~, `code`,
+//	```lang
+//	code block
+//	```
+//	[text](url)
+//
+// Other platforms parse it with ParseMarkup and render it with one of the
+// Render functions. Anything that isn't well-formed markup, like "2*3*4" or
+// snake_case, is left as text, and \ escapes a markup character.
+
+type MarkupKind int
+
+const (
+	MarkupText MarkupKind = iota
+	MarkupBold
+	MarkupItalic
+	MarkupStrike
+	MarkupCode
+	MarkupCodeBlock
+	MarkupLink
+)
+
+// MarkupNode is a node of parsed markup. Text, Code, CodeBlock and Link
+// have Text, the others have Children.
+type MarkupNode struct {
+	Kind     MarkupKind
+	Text     string
+	Lang     string // CodeBlock
+	URL      string // Link
+	Children []MarkupNode
+}
+
+var markupFenceLang = regexp.MustCompile("^[A-Za-z0-9_+-]*\n")
+var markupLink = regexp.MustCompile(`^\[([^\]\n]+)\]\((https?://[^)\s]+)\)`)
+
+// ParseMarkup parses neutral markup.
+func ParseMarkup(s string) []MarkupNode {
+	nodes := []MarkupNode{}
+	for {
+		start := strings.Index(s, "```")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(s[start+3:], "```")
+		if end < 0 {
+			break
+		}
+		nodes = append(nodes, parseInline(s[:start])...)
+		block := s[start+3 : start+3+end]
+		lang := ""
+		if m := markupFenceLang.FindString(block); m != "" {
+			lang = strings.TrimSuffix(m, "\n")
+			block = block[len(m):]
+		}
+		block = strings.TrimSuffix(block, "\n")
+		nodes = append(nodes, MarkupNode{Kind: MarkupCodeBlock, Text: block, Lang: lang})
+		s = strings.TrimPrefix(s[start+3+end+3:], "\n")
+	}
+	return append(nodes, parseInline(s)...)
+}
+
+func parseInline(s string) []MarkupNode {
+	nodes := []MarkupNode{}
+	text := strings.Builder{}
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, MarkupNode{Kind: MarkupText, Text: text.String()})
+			text.Reset()
+		}
+	}
+	add := func(n MarkupNode) {
+		flush()
+		nodes = append(nodes, n)
+	}
+
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_~[]", s[i+1]) >= 0:
+			text.WriteByte(s[i+1])
+			i += 2
+			continue
+		case s[i] == '`':
+			if end := strings.IndexByte(s[i+1:], '`'); end > 0 {
+				add(MarkupNode{Kind: MarkupCode, Text: s[i+1 : i+1+end]})
+				i += end + 2
+				continue
+			}
+		case s[i] == '[':
+			if m := markupLink.FindStringSubmatch(s[i:]); m != nil {
+				add(MarkupNode{Kind: MarkupLink, Text: m[1], URL: m[2]})
+				i += len(m[0])
+				continue
+			}
+		case strings.HasPrefix(s[i:], "**"):
+			if end := closingDelimiter(s, i, "**"); end > 0 {
+				add(MarkupNode{Kind: MarkupBold, Children: parseInline(s[i+2 : end])})
+				i = end + 2
+				continue
+			}
+		case strings.HasPrefix(s[i:], "~~"):
+			if end := closingDelimiter(s, i, "~~"); end > 0 {
+				add(MarkupNode{Kind: MarkupStrike, Children: parseInline(s[i+2 : end])})
+				i = end + 2
+				continue
+			}
+		case s[i] == '*' || s[i] == '_':
+			if end := closingDelimiter(s, i, s[i:i+1]); end > 0 {
+				add(MarkupNode{Kind: MarkupItalic, Children: parseInline(s[i+1 : end])})
+				i = end + 1
+				continue
+			}
+		}
+		text.WriteByte(s[i])
+		i++
+	}
+	flush()
+	return nodes
+}
+
+// closingDelimiter finds where the delimiter opened at s[start:] closes on
+// the same line, or returns -1. Like markdown, the delimiters must hug the
+// text, and * and _ only count at word boundaries, so snake_case and 2*3*4
+// are left alone.
+func closingDelimiter(s string, start int, delim string) int {
+	open := start + len(delim)
+	if open >= len(s) {
+		return -1
+	}
+	next, _ := utf8.DecodeRuneInString(s[open:])
+	if unicode.IsSpace(next) || strings.HasPrefix(s[open:], delim[:1]) {
+		return -1
+	}
+	if len(delim) == 1 && start > 0 {
+		prev, _ := utf8.DecodeLastRuneInString(s[:start])
+		if unicode.IsLetter(prev) || unicode.IsDigit(prev) {
+			return -1
+		}
+	}
+	for i := open + 1; i+len(delim) <= len(s); i++ {
+		if s[i] == '\n' {
+			return -1
+		}
+		if s[i] == '`' {
+			// Don't close inside code
+			end := strings.IndexByte(s[i+1:], '`')
+			if end < 0 {
+				return -1
+			}
+			i += end + 1
+			continue
+		}
+		if !strings.HasPrefix(s[i:], delim) {
+			continue
+		}
+		prev, _ := utf8.DecodeLastRuneInString(s[:i])
+		if unicode.IsSpace(prev) {
+			continue
+		}
+		after := i + len(delim)
+		if len(delim) == 1 && after < len(s) && s[after] == delim[0] {
+			// Part of a ** or __, skip both
+			i++
+			continue
+		}
+		if len(delim) == 1 && after < len(s) {
+			r, _ := utf8.DecodeRuneInString(s[after:])
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				continue
+			}
+		}
+		return i
+	}
+	return -1
+}
+
+// markupRenderer has the syntax of one output format.
+type markupRenderer struct {
+	escape    func(string) string
+	bold      [2]string
+	italic    [2]string
+	strike    [2]string
+	code      [2]string
+	codeBlock func(text, lang string) string
+	link      func(text, url string) string
+}
+
+func (m markupRenderer) render(nodes []MarkupNode) string {
+	out := strings.Builder{}
+	wrap := func(tags [2]string, children []MarkupNode) {
+		out.WriteString(tags[0])
+		out.WriteString(m.render(children))
+		out.WriteString(tags[1])
+	}
+	for i, n := range nodes {
+		switch n.Kind {
+		case MarkupText:
+			out.WriteString(m.escape(n.Text))
+		case MarkupBold:
+			wrap(m.bold, n.Children)
+		case MarkupItalic:
+			wrap(m.italic, n.Children)
+		case MarkupStrike:
+			wrap(m.strike, n.Children)
+		case MarkupCode:
+			out.WriteString(m.code[0] + m.escape(n.Text) + m.code[1])
+		case MarkupCodeBlock:
+			// Blocks are on their own lines.
+			if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+				out.WriteString("\n")
+			}
+			out.WriteString(m.codeBlock(n.Text, n.Lang))
+			if i < len(nodes)-1 {
+				out.WriteString("\n")
+			}
+		case MarkupLink:
+			out.WriteString(m.link(n.Text, n.URL))
+		}
+	}
+	return out.String()
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+var slackRenderer = markupRenderer{
+	escape: slackEscaper.Replace,
+	bold:   [2]string{"*", "*"},
+	italic: [2]string{"_", "_"},
+	strike: [2]string{"~", "~"},
+	code:   [2]string{"`", "`"},
+	codeBlock: func(text, lang string) string {
+		return "```" + slackEscaper.Replace(text) + "```"
+	},
+	link: func(text, url string) string {
+		return "<" + url + "|" + slackEscaper.Replace(text) + ">"
+	},
+}
+
+var htmlRenderer = markupRenderer{
+	escape: html.EscapeString,
+	bold:   [2]string{"<b>", "</b>"},
+	italic: [2]string{"<i>", "</i>"},
+	strike: [2]string{"<s>", "</s>"},
+	code:   [2]string{"<code>", "</code>"},
+	codeBlock: func(text, lang string) string {
+		if lang != "" {
+			return `<pre><code class="language-` + html.EscapeString(lang) + `">` + html.EscapeString(text) + "</code></pre>"
+		}
+		return "<pre>" + html.EscapeString(text) + "</pre>"
+	},
+	link: func(text, url string) string {
+		return `<a href="` + html.EscapeString(url) + `">` + html.EscapeString(text) + "</a>"
+	},
+}
+
+var ircRenderer = markupRenderer{
+	escape: func(s string) string { return s },
+	bold:   [2]string{"\x02", "\x02"},
+	italic: [2]string{"\x1d", "\x1d"},
+	strike: [2]string{"\x1e", "\x1e"},
+	code:   [2]string{"\x11", "\x11"},
+	codeBlock: func(text, lang string) string {
+		return text
+	},
+	link: func(text, url string) string {
+		if text == url {
+			return url
+		}
+		return text + " (" + url + ")"
+	},
+}
+
+// matrixRenderer is htmlRenderer for Matrix, where formatted_body is
+// real HTML and newlines need to be <br>.
+var matrixRenderer = markupRenderer{
+	escape: func(s string) string {
+		return strings.ReplaceAll(html.EscapeString(s), "\n", "<br>")
+	},
+	bold:      htmlRenderer.bold,
+	italic:    htmlRenderer.italic,
+	strike:    htmlRenderer.strike,
+	code:      htmlRenderer.code,
+	codeBlock: htmlRenderer.codeBlock,
+	link:      htmlRenderer.link,
+}
+
+var plainRenderer = markupRenderer{
+	escape:    ircRenderer.escape,
+	codeBlock: ircRenderer.codeBlock,
+	link:      ircRenderer.link,
+}
+
+// RenderSlack renders neutral markup as Slack mrkdwn.
+func RenderSlack(s string) string {
+	return slackRenderer.render(ParseMarkup(s))
+}
+
+// RenderHTML renders neutral markup as the HTML subset Telegram's
+// parse_mode=HTML accepts.
+func RenderHTML(s string) string {
+	return htmlRenderer.render(ParseMarkup(s))
+}
+
+// RenderMatrixHTML renders neutral markup for Matrix's formatted_body.
+func RenderMatrixHTML(s string) string {
+	return matrixRenderer.render(ParseMarkup(s))
+}
+
+// RenderIRC renders neutral markup with IRC formatting control codes.
+func RenderIRC(s string) string {
+	return ircRenderer.render(ParseMarkup(s))
+}
+
+// RenderPlain strips neutral markup.
+func RenderPlain(s string) string {
+	return plainRenderer.render(ParseMarkup(s))
+}
+
+// HasMarkup is whether s has any markup, so platforms can send plain text
+// as is.
+func HasMarkup(s string) bool {
+	for _, n := range ParseMarkup(s) {
+		if n.Kind != MarkupText {
+			return true
+		}
+	}
+	return false
+}
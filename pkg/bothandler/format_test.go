@@ -0,0 +1,41 @@
+package bothandler
+
+import "testing"
+
+func TestRenderMarkup(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		slack string
+		html  string
+		irc   string
+		plain string
+	}{
+		{"text", "a < b & c", "a &lt; b &amp; c", "a &lt; b &amp; c", "a < b & c", "a < b & c"},
+		{"emphasis", "*Feel* the **AGI**!", "_Feel_ the *AGI*!", "<i>Feel</i> the <b>AGI</b>!", "\x1dFeel\x1d the \x02AGI\x02!", "Feel the AGI!"},
+		{"not-emphasis", "2*3*4 and snake_case_name and * bullet", "2*3*4 and snake_case_name and * bullet", "2*3*4 and snake_case_name and * bullet", "2*3*4 and snake_case_name and * bullet", "2*3*4 and snake_case_name and * bullet"},
+		{"nested", "**bold _and italic_**", "*bold _and italic_*", "<b>bold <i>and italic</i></b>", "\x02bold \x1dand italic\x1d\x02", "bold and italic"},
+		{"strike-code", "~~old~~ `x<y`", "~old~ `x&lt;y`", "<s>old</s> <code>x&lt;y</code>", "\x1eold\x1e \x11x<y\x11", "old x<y"},
+		{"escaped", `\*not\* italic`, "*not* italic", "*not* italic", "*not* italic", "*not* italic"},
+		{"link", "see [xkcd](https://xkcd.com/353/)", "see <https://xkcd.com/353/|xkcd>", `see <a href="https://xkcd.com/353/">xkcd</a>`, "see xkcd (https://xkcd.com/353/)", "see xkcd (https://xkcd.com/353/)"},
+		{"codeblock", "art:\n```\n *Y* <M>\n```\ndone", "art:\n``` *Y* &lt;M&gt;```\ndone", "art:\n<pre> *Y* &lt;M&gt;</pre>\ndone", "art:\n *Y* <M>\ndone", "art:\n *Y* <M>\ndone"},
+		{"codeblock-lang", "```go\nfmt.Println()\n```", "```fmt.Println()```", `<pre><code class="language-go">fmt.Println()</code></pre>`, "fmt.Println()", "fmt.Println()"},
+		{"unclosed", "``` and *", "``` and *", "``` and *", "``` and *", "``` and *"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderSlack(tt.in); got != tt.slack {
+				t.Errorf("slack got %q want %q", got, tt.slack)
+			}
+			if got := RenderHTML(tt.in); got != tt.html {
+				t.Errorf("html got %q want %q", got, tt.html)
+			}
+			if got := RenderIRC(tt.in); got != tt.irc {
+				t.Errorf("irc got %q want %q", got, tt.irc)
+			}
+			if got := RenderPlain(tt.in); got != tt.plain {
+				t.Errorf("plain got %q want %q", got, tt.plain)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package bothandler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Implements MessagePlatform, exposing the handlers over HTTP/JSON for
+// integrations and tests that don't go through a chat service.
+//
+//   - POST /message takes an HTTPBotMessage and returns an HTTPBotResponse.
+//     With ?stream=1, responses are sent as newline delimited JSON as soon as
+//     each handler answers, instead of all at the end.
+//   - GET /events streams messages the bot sends by itself (sendmsg,
+//     scheduled posts) as server-sent events, optionally only for ?channel=.
+//   - GET /images/<id> serves images returned with ?images=url.
+//   - Plugins' routes, see RegisterHTTPHandler.
+type HTTPMessagePlatform struct {
+	Listen string
+	// Token, if set, must be sent as "Authorization: Bearer <token>". It is
+	// required unless Listen is only on loopback.
+	Token          string
+	DefaultChannel string
+	// MaxImages is how many images are kept for ?images=url.
+	MaxImages int
+
+	server *http.Server
+
+	imagesLock  sync.Mutex
+	images      map[string][]byte
+	imagesOrder []string
+
+	subscribersLock sync.Mutex
+	subscribers     map[chan HTTPBotEvent]string // -> channel filter
+}
+
+// HTTPBotMessage is a message sent to the bot.
+type HTTPBotMessage struct {
+	// Platform defaults to "http". Set it to test a handler's behaviour for
+	// another platform. Admin commands are refused either way, as anyone
+	// can claim to be anyone.
+	Platform string `json:"platform"`
+	Channel  string `json:"channel"`
+	User     string `json:"user"`
+	Text     string `json:"text"`
+	// Image is a base64 encoded attachment, of any type despite the name,
+	// and goes to the attachment handlers with Text as the caption.
+	Image string `json:"image,omitempty"`
+}
+
+// HTTPBotReply is one response from the bot. Image is base64 encoded, or
+// ImageURL is set with ?images=url.
+type HTTPBotReply struct {
+	Text     string `json:"text,omitempty"`
+	Image    string `json:"image,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+type HTTPBotResponse struct {
+	Responses []HTTPBotReply `json:"responses"`
+}
+
+// HTTPBotEvent is a message sent by the bot outside of a response.
+type HTTPBotEvent struct {
+	Channel string `json:"channel"`
+	Text    string `json:"text"`
+	Silent  bool   `json:"silent,omitempty"`
+}
+
+const httpBotMaxBodySize = 20 << 20
+const httpBotDefaultMaxImages = 100
+
+// LoopbackOnly is whether listen, a host:port address, can only be reached
+// from this machine.
+func LoopbackOnly(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func NewMessagePlatformFromHTTP(listen, token string) (*HTTPMessagePlatform, error) {
+	if token == "" && !LoopbackOnly(listen) {
+		return nil, fmt.Errorf("http bot needs a token to listen on %q, or listen on localhost", listen)
+	}
+	s := &HTTPMessagePlatform{
+		Listen:      listen,
+		Token:       token,
+		MaxImages:   httpBotDefaultMaxImages,
+		images:      map[string][]byte{},
+		subscribers: map[chan HTTPBotEvent]string{},
+	}
+	s.server = &http.Server{
+		Addr:    listen,
+		Handler: s.Handler(),
+	}
+	return s, nil
+}
+
+func (s *HTTPMessagePlatform) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /message", s.authorized(s.handleMessage))
+	mux.HandleFunc("GET /events", s.authorized(s.handleEvents))
+	mux.HandleFunc("GET /images/{id}", s.authorized(s.handleImage))
+	mountHTTPHandlers(mux)
+	return mux
+}
+
+func (s *HTTPMessagePlatform) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Token != "" {
+			token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+func (s *HTTPMessagePlatform) handleMessage(w http.ResponseWriter, r *http.Request) {
+	m := HTTPBotMessage{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, httpBotMaxBodySize)).Decode(&m)
+	if err != nil {
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var image []byte
+	if m.Image != "" {
+		image, err = base64.StdEncoding.DecodeString(m.Image)
+		if err != nil {
+			http.Error(w, "bad image: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if m.Platform == "" {
+		m.Platform = "http"
+	}
+	asURL := r.URL.Query().Get("images") == "url"
+
+	if r.URL.Query().Get("stream") == "1" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher, _ := w.(http.Flusher)
+		enc := json.NewEncoder(w)
+		s.dispatch(m, image, func(reply HTTPBotReply) {
+			enc.Encode(reply)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}, asURL)
+		return
+	}
+
+	out := HTTPBotResponse{Responses: []HTTPBotReply{}}
+	s.dispatch(m, image, func(reply HTTPBotReply) {
+		out.Responses = append(out.Responses, reply)
+	}, asURL)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (s *HTTPMessagePlatform) dispatch(m HTTPBotMessage, image []byte, send func(HTTPBotReply), asURL bool) {
+	reply := func(r *ExtendedMessage) {
+		out := HTTPBotReply{Text: r.Text}
+		if !r.HasImages() {
+			send(out)
+			return
+		}
+		// One reply per image, the text goes with the first
+		for _, a := range r.AllImages() {
+			switch {
+			case len(a.Data) == 0:
+				out.ImageURL = a.URL
+			case asURL:
+				out.ImageURL = "/images/" + s.storeImage(a.Data)
+			default:
+				out.Image = base64.StdEncoding.EncodeToString(a.Data)
+			}
+			send(out)
+			out = HTTPBotReply{}
+		}
+	}
+
+	text, entities := NormalizeText(m.Text)
+	request := Request{Content: text, Platform: m.Platform, Channel: m.Channel, From: m.User, Unverified: true, Entities: entities}
+	if image == nil {
+		Dispatch(request, reply)
+		return
+	}
+	DispatchAttachment(NewAttachment("", image, ""), request, reply)
+}
+
+func (s *HTTPMessagePlatform) storeImage(image []byte) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	s.imagesLock.Lock()
+	defer s.imagesLock.Unlock()
+	s.images[id] = image
+	s.imagesOrder = append(s.imagesOrder, id)
+	for len(s.imagesOrder) > s.MaxImages {
+		delete(s.images, s.imagesOrder[0])
+		s.imagesOrder = s.imagesOrder[1:]
+	}
+	return id
+}
+
+func (s *HTTPMessagePlatform) handleImage(w http.ResponseWriter, r *http.Request) {
+	s.imagesLock.Lock()
+	image, ok := s.images[r.PathValue("id")]
+	s.imagesLock.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(image))
+	w.Write(image)
+}
+
+func (s *HTTPMessagePlatform) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	events := make(chan HTTPBotEvent, 10)
+	s.subscribersLock.Lock()
+	s.subscribers[events] = r.URL.Query().Get("channel")
+	s.subscribersLock.Unlock()
+	defer func() {
+		s.subscribersLock.Lock()
+		delete(s.subscribers, events)
+		s.subscribersLock.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			b, _ := json.Marshal(e)
+			fmt.Fprintf(w, "data: %s\n\n", b)
+			flusher.Flush()
+		}
+	}
+}
+
+// publish sends an event to the /events subscribers for its channel. Slow
+// subscribers miss events rather than block the bot.
+func (s *HTTPMessagePlatform) publish(e HTTPBotEvent) {
+	s.subscribersLock.Lock()
+	defer s.subscribersLock.Unlock()
+	for events, channel := range s.subscribers {
+		if channel != "" && channel != e.Channel {
+			continue
+		}
+		select {
+		case events <- e:
+		default:
+		}
+	}
+}
+
+func (s *HTTPMessagePlatform) ProcessMessages() {
+	log.Println("HTTP bot listening on", s.Listen)
+	err := s.server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Println(err)
+	}
+}
+
+func (s *HTTPMessagePlatform) Send(text string) {
+	if s == nil {
+		return
+	}
+	s.SendWithOptions(text, SendOptions{})
+}
+
+func (s *HTTPMessagePlatform) SendWithOptions(text string, options SendOptions) {
+	if s == nil {
+		return
+	}
+	s.publish(HTTPBotEvent{Channel: s.DefaultChannel, Text: text, Silent: options.Silent})
+}
+
+func (s *HTTPMessagePlatform) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+}
+
+func (s *HTTPMessagePlatform) ChannelMessageSend(channel, message string) error {
+	if channel == "" {
+		channel = s.DefaultChannel
+	}
+	s.publish(HTTPBotEvent{Channel: channel, Text: message})
+	return nil
+}
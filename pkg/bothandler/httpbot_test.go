@@ -0,0 +1,183 @@
+package bothandler
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPBot(t *testing.T) {
+	oldHandlers, oldInput, oldAttachmentHandlers, oldExtended := Handlers, MsgInputHandlers, AttachmentHandlers, CatchallExtendedHandlers
+	Handlers = map[string]MessageHandler{"ping": func() string { return "pong" }}
+	MsgInputHandlers = map[string]MessageWithInputHandler{"!whoami": func(r Request) string {
+		if IsAdmin(r) {
+			return "admin"
+		}
+		return r.Platform + "/" + r.Channel + "/" + r.From + ": " + r.Content
+	}}
+	oldAdmins := Admins
+	Admins = map[string]bool{"slack:alice": true}
+	AttachmentHandlers = nil
+	RegisterAttachmentHandler(nil, func(a *Attachment, r Request) string {
+		filename, _ := a.Path()
+		b, _ := os.ReadFile(filename)
+		return r.Content + " " + string(b)
+	})
+	CatchallExtendedHandlers = []CatchallExtendedHandler{func(m ExtendedMessage) *ExtendedMessage {
+		if m.Text != "draw" {
+			return nil
+		}
+		return &ExtendedMessage{Text: "drawn", Image: []byte("PNG")}
+	}}
+	defer func() {
+		Handlers, MsgInputHandlers, AttachmentHandlers, CatchallExtendedHandlers = oldHandlers, oldInput, oldAttachmentHandlers, oldExtended
+		Admins = oldAdmins
+	}()
+
+	s, err := NewMessagePlatformFromHTTP("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	post := func(query, body, token string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/message"+query, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	replies := func(resp *http.Response) []HTTPBotReply {
+		t.Helper()
+		defer resp.Body.Close()
+		out := HTTPBotResponse{}
+		err := json.NewDecoder(resp.Body).Decode(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.Responses
+	}
+
+	resp := post("", `{"text":"ping"}`, "wrong")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("bad token got %s", resp.Status)
+	}
+	resp = post("", `{"text":`, "secret")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad json got %s", resp.Status)
+	}
+
+	got := replies(post("", `{"text":"ping"}`, "secret"))
+	if len(got) != 1 || got[0].Text != "pong" {
+		t.Errorf("ping got %+v", got)
+	}
+	got = replies(post("", `{"platform":"slack","channel":"general","user":"alice","text":"!whoami hi"}`, "secret"))
+	if len(got) != 1 || got[0].Text != "slack/general/alice: hi" {
+		t.Errorf("whoami got %+v", got)
+	}
+	got = replies(post("", `{"text":"hello"}`, "secret"))
+	if len(got) != 0 {
+		t.Errorf("expected no responses, got %+v", got)
+	}
+
+	image := base64.StdEncoding.EncodeToString([]byte("cat"))
+	got = replies(post("", `{"text":"caption","image":"`+image+`"}`, "secret"))
+	if len(got) != 1 || got[0].Text != "caption cat" {
+		t.Errorf("image got %+v", got)
+	}
+
+	got = replies(post("", `{"text":"draw"}`, "secret"))
+	if len(got) != 1 || got[0].Text != "drawn" || got[0].Image != base64.StdEncoding.EncodeToString([]byte("PNG")) {
+		t.Errorf("draw got %+v", got)
+	}
+	got = replies(post("?images=url", `{"text":"draw"}`, "secret"))
+	if len(got) != 1 || !strings.HasPrefix(got[0].ImageURL, "/images/") {
+		t.Fatalf("draw url got %+v", got)
+	}
+	req, _ := http.NewRequest(http.MethodGet, server.URL+got[0].ImageURL, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "PNG" {
+		t.Errorf("image url served %q", b)
+	}
+
+	resp = post("?stream=1", `{"text":"ping"}`, "secret")
+	b, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" || string(b) != `{"text":"pong"}`+"\n" {
+		t.Errorf("stream got %s %q", resp.Header.Get("Content-Type"), b)
+	}
+}
+
+func TestHTTPBotNeedsToken(t *testing.T) {
+	tests := []struct {
+		listen string
+		ok     bool
+	}{
+		{":8090", false},
+		{"0.0.0.0:8090", false},
+		{"192.168.1.2:8090", false},
+		{"localhost:8090", true},
+		{"127.0.0.1:8090", true},
+		{"[::1]:8090", true},
+	}
+	for _, tt := range tests {
+		_, err := NewMessagePlatformFromHTTP(tt.listen, "")
+		if (err == nil) != tt.ok {
+			t.Errorf("%s without a token: got %v", tt.listen, err)
+		}
+	}
+}
+
+func TestHTTPBotEvents(t *testing.T) {
+	s, _ := NewMessagePlatformFromHTTP("localhost:0", "")
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?channel=general")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// Wait for the subscription before sending.
+	for i := 0; ; i++ {
+		s.subscribersLock.Lock()
+		n := len(s.subscribers)
+		s.subscribersLock.Unlock()
+		if n > 0 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("never subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.ChannelMessageSend("random", "not for us")
+	s.ChannelMessageSend("general", "hello")
+
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != `data: {"channel":"general","text":"hello"}`+"\n" {
+		t.Errorf("got %q", line)
+	}
+}
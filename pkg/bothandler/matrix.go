@@ -0,0 +1,538 @@
+package bothandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Implements MessagePlatform, using the Matrix client-server API. Only
+// unencrypted rooms are supported.
+type MatrixMessagePlatform struct {
+	Client      *http.Client
+	Homeserver  string
+	AccessToken string
+	UserID      string
+	// DefaultChannel is a room id, #alias:server or a channel registry alias.
+	DefaultChannel string
+	// ThreadReplies starts a thread on the message being replied to. Replies
+	// to messages already in a thread always go to that thread.
+	ThreadReplies bool
+	MaxImageSize  int
+
+	since    string
+	txnId    atomic.Uint64
+	txnStart int64
+	stopChan chan bool
+	stopOnce sync.Once
+}
+
+// matrixMaxDownloadSize is the default size limit for attachments.
+const matrixMaxDownloadSize = DefaultMaxAttachmentSize
+
+type matrixEvent struct {
+	Type    string          `json:"type"`
+	EventId string          `json:"event_id"`
+	Sender  string          `json:"sender"`
+	Content json.RawMessage `json:"content"`
+}
+
+type matrixMessageContent struct {
+	MsgType string `json:"msgtype"`
+	Body    string `json:"body"`
+	// Format and FormattedBody are the HTML version of Body.
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+	URL           string `json:"url,omitempty"`
+	// Filename is set on files with a caption in Body.
+	Filename  string           `json:"filename,omitempty"`
+	Info      *matrixImageInfo `json:"info,omitempty"`
+	RelatesTo *matrixRelatesTo `json:"m.relates_to,omitempty"`
+}
+
+type matrixImageInfo struct {
+	MimeType string `json:"mimetype,omitempty"`
+	Size     int    `json:"size,omitempty"`
+}
+
+type matrixRelatesTo struct {
+	RelType       string `json:"rel_type,omitempty"`
+	EventId       string `json:"event_id,omitempty"`
+	IsFallingBack bool   `json:"is_falling_back,omitempty"`
+	InReplyTo     *struct {
+		EventId string `json:"event_id"`
+	} `json:"m.in_reply_to,omitempty"`
+}
+
+type matrixSyncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]json.RawMessage `json:"invite"`
+	} `json:"rooms"`
+}
+
+// matrixThread is where a reply goes: the room, and the thread root and the
+// event replied to if the reply is threaded.
+type matrixThread struct {
+	RoomId  string
+	Root    string
+	ReplyTo string
+}
+
+func NewMessagePlatformFromMatrix(homeserver, accessToken string) (*MatrixMessagePlatform, error) {
+	s := &MatrixMessagePlatform{
+		Client:       &http.Client{Timeout: 60 * time.Second},
+		Homeserver:   strings.TrimSuffix(homeserver, "/"),
+		AccessToken:  accessToken,
+		MaxImageSize: matrixMaxDownloadSize,
+		txnStart:     time.Now().UnixNano(),
+		stopChan:     make(chan bool),
+	}
+	whoami := struct {
+		UserId string `json:"user_id"`
+	}{}
+	err := s.call(http.MethodGet, "/_matrix/client/v3/account/whoami", nil, &whoami)
+	if err != nil {
+		return nil, err
+	}
+	s.UserID = whoami.UserId
+	log.Printf("Connected to Matrix on account %s", s.UserID)
+	return s, nil
+}
+
+// call does a client-server API request, body and out are JSON.
+func (s *MatrixMessagePlatform) call(method, path string, body any, out any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, s.Homeserver+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return s.do(req, out)
+}
+
+func (s *MatrixMessagePlatform) do(req *http.Request, out any) error {
+	req.Header.Set("Authorization", "Bearer "+s.AccessToken)
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		matrixErr := struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}{}
+		json.Unmarshal(b, &matrixErr)
+		return fmt.Errorf("matrix %s %s: %s %s %s", req.Method, req.URL.Path, resp.Status, matrixErr.ErrCode, matrixErr.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+func (s *MatrixMessagePlatform) ProcessMessages() {
+	// The first sync only catches up, so we don't answer old messages
+	// after a restart.
+	first := true
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		default:
+		}
+
+		resp, err := s.sync()
+		if err != nil {
+			log.Println(err)
+			select {
+			case <-s.stopChan:
+				return
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		s.since = resp.NextBatch
+
+		for roomId := range resp.Rooms.Invite {
+			s.join(roomId)
+		}
+		for roomId, room := range resp.Rooms.Join {
+			Channels.See(KnownChat{Platform: "matrix", ID: roomId})
+			if first {
+				continue
+			}
+			for _, e := range room.Timeline.Events {
+				s.handleEvent(roomId, e)
+			}
+		}
+		first = false
+	}
+}
+
+func (s *MatrixMessagePlatform) sync() (*matrixSyncResponse, error) {
+	q := url.Values{}
+	q.Set("timeout", "30000")
+	q.Set("filter", `{"presence":{"not_types":["*"]},"account_data":{"not_types":["*"]},"room":{"timeline":{"types":["m.room.message"]},"ephemeral":{"not_types":["*"]}}}`)
+	if s.since != "" {
+		q.Set("since", s.since)
+	}
+	resp := &matrixSyncResponse{}
+	err := s.call(http.MethodGet, "/_matrix/client/v3/sync?"+q.Encode(), nil, resp)
+	return resp, err
+}
+
+func (s *MatrixMessagePlatform) join(roomId string) {
+	err := s.call(http.MethodPost, "/_matrix/client/v3/rooms/"+url.PathEscape(roomId)+"/join", struct{}{}, nil)
+	if err != nil {
+		log.Println("Failed to join", roomId, err)
+		return
+	}
+	log.Println("Joined Matrix room", roomId)
+	Channels.See(KnownChat{Platform: "matrix", ID: roomId})
+}
+
+func (s *MatrixMessagePlatform) handleEvent(roomId string, e matrixEvent) {
+	if e.Type != "m.room.message" || e.Sender == s.UserID {
+		return
+	}
+	m := matrixMessageContent{}
+	err := json.Unmarshal(e.Content, &m)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	thread := matrixThread{RoomId: roomId}
+	if m.RelatesTo != nil && m.RelatesTo.RelType == "m.thread" {
+		thread.Root = m.RelatesTo.EventId
+		thread.ReplyTo = e.EventId
+	} else if s.ThreadReplies {
+		thread.Root = e.EventId
+		thread.ReplyTo = e.EventId
+	}
+
+	switch m.MsgType {
+	case "m.text":
+		s.handleText(thread, e.Sender, m.Body)
+	case "m.image", "m.file":
+		s.handleAttachment(thread, e.Sender, m)
+	}
+	// m.notice is what bots send, don't answer them.
+}
+
+func (s *MatrixMessagePlatform) handleText(thread matrixThread, sender, content string) {
+	content, entities := NormalizeMarkdown(content)
+	request := Request{Content: content, Platform: "matrix", Channel: thread.RoomId, From: sender, UserId: sender, Entities: entities}
+	Dispatch(request, s.replier(request, thread, content))
+}
+
+// replier sends responses to req to thread, content is used to name images.
+func (s *MatrixMessagePlatform) replier(req Request, thread matrixThread, content string) Replier {
+	return func(r *ExtendedMessage) {
+		if !r.HasImages() {
+			s.reply(thread, Paginate(req, r.Text, matrixMaxMessage, matrixSize))
+			return
+		}
+		// The text goes with the first image only
+		text := r.Text
+		for _, a := range r.AllImages() {
+			if len(a.Data) == 0 {
+				s.reply(thread, strings.TrimSpace(text+"\n"+a.Fallback(content)))
+				text = ""
+				continue
+			}
+			err := s.sendImage(thread, text, a, a.Name(content))
+			if err != nil {
+				log.Println(err)
+			}
+			text = ""
+		}
+	}
+}
+
+func (s *MatrixMessagePlatform) handleAttachment(thread matrixThread, sender string, m matrixMessageContent) {
+	declaredType := ""
+	if m.Info != nil {
+		declaredType = m.Info.MimeType
+		if m.Info.Size > s.MaxImageSize {
+			log.Println("Skipping attachment bigger than", s.MaxImageSize)
+			return
+		}
+	}
+	if !WantsAttachment(declaredType) {
+		return
+	}
+
+	// The body is the filename, or a caption if there is a separate
+	// filename.
+	filename, caption := m.Body, ""
+	if m.Filename != "" {
+		filename, caption = m.Filename, m.Body
+	}
+	a, err := s.download(m.URL, filename, declaredType)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	caption, entities := NormalizeMarkdown(caption)
+	request := Request{Content: caption, Platform: "matrix", Channel: thread.RoomId, From: sender, UserId: sender, Entities: entities}
+	DispatchAttachment(a, request, s.replier(request, thread, filename))
+}
+
+// download fetches an mxc:// URL from the (authenticated) media repository.
+func (s *MatrixMessagePlatform) download(mxc, filename, declaredType string) (*Attachment, error) {
+	serverAndId, ok := strings.CutPrefix(mxc, "mxc://")
+	if !ok {
+		return nil, fmt.Errorf("not a matrix content uri: %s", mxc)
+	}
+	req, err := http.NewRequest(http.MethodGet, s.Homeserver+"/_matrix/client/v1/media/download/"+serverAndId, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.AccessToken)
+	return FetchAttachment(s.Client, req, filename, declaredType, s.MaxImageSize)
+}
+
+func (s *MatrixMessagePlatform) upload(data []byte, filename string) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, s.Homeserver+"/_matrix/media/v3/upload?filename="+url.QueryEscape(filename), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", http.DetectContentType(data))
+	out := struct {
+		ContentUri string `json:"content_uri"`
+	}{}
+	err = s.do(req, &out)
+	return out.ContentUri, err
+}
+
+// reply sends a bot response. Responses are m.notice, so other bots don't
+// answer them.
+func (s *MatrixMessagePlatform) reply(thread matrixThread, text string) {
+	err := s.send(thread, matrixMessageContent{MsgType: "m.notice", Body: text})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *MatrixMessagePlatform) sendImage(thread matrixThread, text string, a *Attachment, filename string) error {
+	uri, err := s.upload(a.Data, filename)
+	if err != nil {
+		// Better than nothing
+		if text != "" {
+			s.reply(thread, text)
+		}
+		return err
+	}
+	if text != "" {
+		s.reply(thread, text)
+	}
+	return s.send(thread, matrixMessageContent{
+		MsgType: "m.image",
+		Body:    filename,
+		URL:     uri,
+		Info:    &matrixImageInfo{MimeType: a.MimeType, Size: len(a.Data)},
+	})
+}
+
+func (s *MatrixMessagePlatform) send(thread matrixThread, m matrixMessageContent) error {
+	if (m.MsgType == "m.text" || m.MsgType == "m.notice") && HasMarkup(m.Body) {
+		m.Format = "org.matrix.custom.html"
+		m.FormattedBody = RenderMatrixHTML(m.Body)
+		m.Body = RenderPlain(m.Body)
+	}
+	if thread.Root != "" {
+		m.RelatesTo = &matrixRelatesTo{
+			RelType:       "m.thread",
+			EventId:       thread.Root,
+			IsFallingBack: true,
+		}
+		m.RelatesTo.InReplyTo = &struct {
+			EventId string `json:"event_id"`
+		}{thread.ReplyTo}
+	}
+	txnId := fmt.Sprintf("multibot.%d.%d", s.txnStart, s.txnId.Add(1))
+	path := "/_matrix/client/v3/rooms/" + url.PathEscape(thread.RoomId) + "/send/m.room.message/" + txnId
+	return s.call(http.MethodPut, path, m, nil)
+}
+
+// resolveRoom turns a room id, #alias:server or channel registry alias into
+// a room id.
+func (s *MatrixMessagePlatform) resolveRoom(channel string) (string, error) {
+	if channel == "" {
+		channel = s.DefaultChannel
+	}
+	if channel == "" {
+		return "", fmt.Errorf("no channel specified")
+	}
+	if id, ok := Channels.Resolve("matrix", channel); ok {
+		channel = id
+	}
+	if strings.HasPrefix(channel, "!") {
+		return channel, nil
+	}
+	if !strings.HasPrefix(channel, "#") {
+		return "", fmt.Errorf("unknown matrix room %s", channel)
+	}
+	out := struct {
+		RoomId string `json:"room_id"`
+	}{}
+	err := s.call(http.MethodGet, "/_matrix/client/v3/directory/room/"+url.PathEscape(channel), nil, &out)
+	return out.RoomId, err
+}
+
+func (s *MatrixMessagePlatform) Send(text string) {
+	if s == nil {
+		return
+	}
+	s.SendWithOptions(text, SendOptions{})
+}
+
+// SendWithOptions sends to DefaultChannel. Silent messages are sent as
+// m.notice, which clients usually don't notify for.
+func (s *MatrixMessagePlatform) SendWithOptions(text string, options SendOptions) {
+	if s == nil {
+		return
+	}
+	msgType := "m.text"
+	if options.Silent {
+		msgType = "m.notice"
+	}
+	roomId, err := s.resolveRoom("")
+	if err == nil {
+		err = s.send(matrixThread{RoomId: roomId}, matrixMessageContent{MsgType: msgType, Body: text})
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *MatrixMessagePlatform) Close() {
+	if s.stopChan != nil {
+		s.stopOnce.Do(func() { close(s.stopChan) })
+	}
+}
+
+func (s *MatrixMessagePlatform) ChannelMessageSend(channel, message string) error {
+	roomId, err := s.resolveRoom(channel)
+	if err != nil {
+		return err
+	}
+	// Nobody to say !more, send it all
+	for _, page := range SplitMessage(message, matrixMaxMessage, matrixSize) {
+		err = s.send(matrixThread{RoomId: roomId}, matrixMessageContent{MsgType: "m.text", Body: page})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// directRoom finds our direct message room with user, or creates one.
+func (s *MatrixMessagePlatform) directRoom(user string) (string, error) {
+	path := "/_matrix/client/v3/user/" + url.PathEscape(s.UserID) + "/account_data/m.direct"
+	direct := map[string][]string{}
+	directErr := s.call(http.MethodGet, path, nil, &direct)
+	if directErr == nil && len(direct[user]) > 0 {
+		return direct[user][0], nil
+	}
+	out := struct {
+		RoomId string `json:"room_id"`
+	}{}
+	err := s.call(http.MethodPost, "/_matrix/client/v3/createRoom", map[string]any{
+		"is_direct": true,
+		"invite":    []string{user},
+		"preset":    "trusted_private_chat",
+	}, &out)
+	if err != nil {
+		return "", err
+	}
+
+	// Remember the room, so the next message goes to it too. Without our
+	// m.direct, e.g. on a network error, we'd overwrite the other rooms.
+	if directErr != nil && !strings.Contains(directErr.Error(), "M_NOT_FOUND") {
+		log.Println("Not saving Matrix direct room", out.RoomId, directErr)
+		return out.RoomId, nil
+	}
+	direct[user] = append([]string{out.RoomId}, direct[user]...)
+	err = s.call(http.MethodPut, path, direct, nil)
+	if err != nil {
+		log.Println("Failed to save Matrix direct room", out.RoomId, err)
+	}
+	return out.RoomId, nil
+}
+
+// SendMessage sends to a room, a thread by its root event ID, or a user ID
+// for a direct message. Silent messages are m.notice.
+func (s *MatrixMessagePlatform) SendMessage(m OutboundMessage) error {
+	var roomId string
+	var err error
+	if m.User != "" {
+		roomId, err = s.directRoom(m.User)
+	} else {
+		roomId, err = s.resolveRoom(m.Channel)
+	}
+	if err != nil {
+		return err
+	}
+	thread := matrixThread{RoomId: roomId, Root: m.Thread, ReplyTo: m.Thread}
+
+	msgType := "m.text"
+	if m.Silent {
+		msgType = "m.notice"
+	}
+	for _, page := range outboundPages(m.Text, matrixMaxMessage, matrixSize) {
+		err = s.send(thread, matrixMessageContent{MsgType: msgType, Body: page})
+		if err != nil {
+			return err
+		}
+	}
+	for _, a := range m.Files {
+		filename := a.Name("file")
+		uri, err := s.upload(a.Data, filename)
+		if err != nil {
+			return err
+		}
+		content := matrixMessageContent{
+			MsgType: "m.file",
+			Body:    filename,
+			URL:     uri,
+			Info:    &matrixImageInfo{MimeType: a.MimeType, Size: len(a.Data)},
+		}
+		if a.IsImage() {
+			content.MsgType = "m.image"
+		}
+		err = s.send(thread, content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
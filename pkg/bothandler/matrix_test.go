@@ -0,0 +1,226 @@
+package bothandler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMatrixCall struct {
+	Method string
+	Path   string
+	Body   string
+}
+
+// newFakeMatrix runs a fake homeserver that returns syncs in order, then
+// nothing. All calls other than whoami and sync are sent to the channel.
+func newFakeMatrix(t *testing.T, syncs []string) (*MatrixMessagePlatform, chan fakeMatrixCall) {
+	calls := make(chan fakeMatrixCall, 20)
+	direct := ""
+	directLock := sync.Mutex{}
+	syncCh := make(chan string, len(syncs))
+	for _, v := range syncs {
+		syncCh <- v
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer TOKEN" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errcode":"M_UNKNOWN_TOKEN","error":"bad token"}`))
+			return
+		}
+		switch {
+		case r.URL.Path == "/_matrix/client/v3/account/whoami":
+			w.Write([]byte(`{"user_id":"@bot:test"}`))
+			return
+		case r.URL.Path == "/_matrix/client/v3/sync":
+			select {
+			case v := <-syncCh:
+				w.Write([]byte(v))
+			case <-time.After(100 * time.Millisecond):
+				w.Write([]byte(`{"next_batch":"idle"}`))
+			}
+			return
+		case strings.HasPrefix(r.URL.Path, "/_matrix/client/v1/media/download/"):
+			w.Write([]byte("picture"))
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		calls <- fakeMatrixCall{r.Method, r.URL.Path, string(b)}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/account_data/m.direct"):
+			directLock.Lock()
+			defer directLock.Unlock()
+			if r.Method == http.MethodPut {
+				direct = string(b)
+			} else if direct == "" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errcode":"M_NOT_FOUND","error":"no m.direct"}`))
+				return
+			}
+			w.Write([]byte(direct))
+		case r.URL.Path == "/_matrix/client/v3/createRoom":
+			w.Write([]byte(`{"room_id":"!dm:test"}`))
+		case strings.HasPrefix(r.URL.Path, "/_matrix/media/v3/upload"):
+			w.Write([]byte(`{"content_uri":"mxc://test/uploaded"}`))
+		case strings.HasPrefix(r.URL.Path, "/_matrix/client/v3/directory/room/"):
+			w.Write([]byte(`{"room_id":"!aliased:test"}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	s, err := NewMessagePlatformFromMatrix(server.URL, "TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.UserID != "@bot:test" {
+		t.Errorf("user id %s", s.UserID)
+	}
+	return s, calls
+}
+
+func TestMatrix(t *testing.T) {
+	oldHandlers, oldAttachmentHandlers, oldExtended := Handlers, AttachmentHandlers, CatchallExtendedHandlers
+	Handlers = map[string]MessageHandler{"ping": func() string { return "pong" }}
+	AttachmentHandlers = nil
+	RegisterAttachmentHandler(nil, func(a *Attachment, r Request) string {
+		filename, _ := a.Path()
+		b, _ := os.ReadFile(filename)
+		return r.From + " sent " + string(b)
+	})
+	CatchallExtendedHandlers = []CatchallExtendedHandler{func(m ExtendedMessage) *ExtendedMessage {
+		if m.Text != "draw" {
+			return nil
+		}
+		return &ExtendedMessage{Image: []byte("\x89PNG\r\n\x1a\n")}
+	}}
+	defer func() {
+		Handlers, AttachmentHandlers, CatchallExtendedHandlers = oldHandlers, oldAttachmentHandlers, oldExtended
+	}()
+
+	s, calls := newFakeMatrix(t, []string{
+		// Catching up, the old ping shouldn't be answered.
+		`{"next_batch":"s1","rooms":{"invite":{"!new:test":{}},"join":{"!room:test":{"timeline":{"events":[
+			{"type":"m.room.message","event_id":"$old","sender":"@alice:test","content":{"msgtype":"m.text","body":"ping"}}]}}}}}`,
+		`{"next_batch":"s2","rooms":{"join":{"!room:test":{"timeline":{"events":[
+			{"type":"m.room.message","event_id":"$own","sender":"@bot:test","content":{"msgtype":"m.text","body":"ping"}},
+			{"type":"m.room.message","event_id":"$notice","sender":"@otherbot:test","content":{"msgtype":"m.notice","body":"ping"}},
+			{"type":"m.room.message","event_id":"$1","sender":"@alice:test","content":{"msgtype":"m.text","body":"ping"}},
+			{"type":"m.room.message","event_id":"$2","sender":"@alice:test","content":{"msgtype":"m.text","body":"ping",
+				"m.relates_to":{"rel_type":"m.thread","event_id":"$root"}}},
+			{"type":"m.room.message","event_id":"$3","sender":"@alice:test","content":{"msgtype":"m.image","body":"cat.jpg","url":"mxc://test/cat","info":{"size":7}}},
+			{"type":"m.room.message","event_id":"$4","sender":"@alice:test","content":{"msgtype":"m.text","body":"draw"}}]}}}}}`,
+	})
+	go s.ProcessMessages()
+	defer s.Close()
+	// sendmsg closes it, then Shutdown again
+	defer s.Close()
+
+	next := func() (fakeMatrixCall, matrixMessageContent) {
+		t.Helper()
+		select {
+		case call := <-calls:
+			m := matrixMessageContent{}
+			json.Unmarshal([]byte(call.Body), &m)
+			return call, m
+		case <-time.After(2 * time.Second):
+			t.Fatal("no call")
+		}
+		return fakeMatrixCall{}, matrixMessageContent{}
+	}
+
+	call, _ := next()
+	if call.Path != "/_matrix/client/v3/rooms/%21new:test/join" && call.Path != "/_matrix/client/v3/rooms/!new:test/join" {
+		t.Errorf("expected join, got %+v", call)
+	}
+
+	call, m := next()
+	if !strings.HasPrefix(call.Path, "/_matrix/client/v3/rooms/!room:test/send/m.room.message/") || call.Method != http.MethodPut {
+		t.Errorf("unexpected call %+v", call)
+	}
+	if m.MsgType != "m.notice" || m.Body != "pong" || m.RelatesTo != nil {
+		t.Errorf("unexpected reply %+v", m)
+	}
+
+	_, m = next()
+	if m.Body != "pong" || m.RelatesTo == nil || m.RelatesTo.RelType != "m.thread" || m.RelatesTo.EventId != "$root" || m.RelatesTo.InReplyTo.EventId != "$2" {
+		t.Errorf("expected a thread reply, got %+v", m)
+	}
+
+	_, m = next()
+	if m.Body != "@alice:test sent picture" {
+		t.Errorf("unexpected image reply %+v", m)
+	}
+
+	call, _ = next()
+	if !strings.HasPrefix(call.Path, "/_matrix/media/v3/upload") {
+		t.Errorf("expected upload, got %+v", call)
+	}
+	_, m = next()
+	if m.MsgType != "m.image" || m.URL != "mxc://test/uploaded" || m.Info.MimeType != "image/png" {
+		t.Errorf("unexpected image %+v", m)
+	}
+
+	err := s.ChannelMessageSend("#general:test", "**hello**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	call, _ = next()
+	if !strings.HasPrefix(call.Path, "/_matrix/client/v3/directory/room/") {
+		t.Errorf("expected alias lookup, got %+v", call)
+	}
+	call, m = next()
+	if !strings.HasPrefix(call.Path, "/_matrix/client/v3/rooms/!aliased:test/send/") || m.MsgType != "m.text" || m.Body != "hello" || m.FormattedBody != "<b>hello</b>" {
+		t.Errorf("unexpected send %+v %+v", call, m)
+	}
+}
+
+func TestMatrixDirectRoom(t *testing.T) {
+	s, calls := newFakeMatrix(t, nil)
+	next := func() fakeMatrixCall {
+		t.Helper()
+		select {
+		case call := <-calls:
+			return call
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out")
+		}
+		return fakeMatrixCall{}
+	}
+
+	// The first message creates the room and remembers it
+	err := s.SendMessage(OutboundMessage{User: "@alice:test", Text: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"GET /account_data/m.direct", "POST /createRoom", "PUT /account_data/m.direct", "PUT /rooms/!dm:test/send/"} {
+		method, path, _ := strings.Cut(want, " ")
+		call := next()
+		if call.Method != method || !strings.Contains(call.Path, path) {
+			t.Errorf("expected %s, got %s %s", want, call.Method, call.Path)
+		}
+		if strings.HasSuffix(want, "PUT /account_data/m.direct") && call.Body != `{"@alice:test":["!dm:test"]}` {
+			t.Errorf("unexpected m.direct %s", call.Body)
+		}
+	}
+
+	// The second is sent to the same room
+	err = s.SendMessage(OutboundMessage{User: "@alice:test", Text: "again"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"GET /account_data/m.direct", "PUT /rooms/!dm:test/send/"} {
+		method, path, _ := strings.Cut(want, " ")
+		call := next()
+		if call.Method != method || !strings.Contains(call.Path, path) {
+			t.Errorf("expected %s, got %s %s", want, call.Method, call.Path)
+		}
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
 	"time"
@@ -154,17 +153,15 @@ func (s *MattermostMessagePlatform) handleWebSocketEvent(event *MattermostWebSoc
 		return
 	}
 
-	content := post.Message
+	content, entities := NormalizeMarkdown(post.Message)
+	request := Request{Content: content, Platform: "mattermost", Channel: post.ChannelId, From: post.UserId, UserId: post.UserId, Entities: entities}
+	// The first page of a long response, the rest waits for "!more"
+	page := func(text string) string {
+		return Paginate(request, text, mattermostMaxMessage, runeSize)
+	}
 
 	// Update known users - simplified, we'll skip this for now to avoid extra API calls
 
-	// Handle exact match handlers
-	h, ok := Handlers[content]
-	if ok {
-		response := h()
-		s.sendReply(post.ChannelId, response, post.Id)
-	}
-
 	// log.Printf("Event is : %s, Data: %+v\n", event.Event, event.Data)
 
 	replyTo := post.Id // Create a thread based on the post ID
@@ -172,65 +169,32 @@ func (s *MattermostMessagePlatform) handleWebSocketEvent(event *MattermostWebSoc
 		replyTo = post.RootId // If replying to a thread, use the root ID
 	}
 
-	// Handle catchall handlers
-	for _, v := range CatchallHandlers {
-		r := v(Request{content, "mattermost", post.ChannelId, post.UserId})
-		if r != "" {
-			s.sendReply(post.ChannelId, r, replyTo)
+	Dispatch(request, func(r *ExtendedMessage) {
+		if r.HasImages() {
+			// Handle image uploads
+			s.sendImageReply(post.ChannelId, r.Text, r.AllImages(), replyTo, content)
+			return
 		}
-	}
+		s.sendReply(post.ChannelId, page(r.Text), replyTo)
+	})
 
-	// Handle extended catchall handlers
-	for _, v := range CatchallExtendedHandlers {
-		r := v(ExtendedMessage{Text: content})
-		if r != nil {
-			if r.Text != "" && r.Image == nil {
-				s.sendReply(post.ChannelId, r.Text, replyTo)
-			}
-			if r.Image != nil {
-				// Handle image uploads
-				s.sendImageReply(post.ChannelId, r.Text, r.Image, replyTo, content)
-			}
+	// Handle file attachments
+	for _, fileId := range post.FileIds {
+		a, err := s.downloadAttachment(fileId)
+		if err != nil {
+			log.Printf("Failed to download file: %v", err)
+			continue
 		}
-	}
-
-	// Handle input commands (messages starting with a command)
-	sliced_content := strings.SplitN(content, " ", 2)
-	if len(sliced_content) > 1 {
-		command := sliced_content[0]
-		actual_content := sliced_content[1]
-
-		ih, ok := MsgInputHandlers[command]
-		if ok {
-			response := ih(Request{actual_content, "mattermost", post.ChannelId, post.UserId})
-			if response != "" {
-				s.sendReply(post.ChannelId, response, replyTo)
-			}
+		if a == nil {
+			continue
 		}
-	}
-
-	// Handle file attachments
-	if len(post.FileIds) > 0 {
-		for _, fileId := range post.FileIds {
-			filename := fmt.Sprintf("tmp/%s", fileId)
-			err := s.downloadFile(fileId, filename)
-			if err != nil {
-				log.Printf("Failed to download file: %v", err)
-				continue
+		DispatchAttachment(a, request, func(r *ExtendedMessage) {
+			if r.HasImages() {
+				s.sendImageReply(post.ChannelId, r.Text, r.AllImages(), post.RootId, a.Name("image"))
+				return
 			}
-
-			for _, v := range ImageHandlers {
-				r := v(filename, Request{content, "mattermost", post.ChannelId, post.UserId})
-				if r != "" {
-					s.sendReply(post.ChannelId, r, post.RootId)
-				}
-			}
-
-			// Optionally clean up the file
-			if false {
-				os.Remove(filename)
-			}
-		}
+			s.sendReply(post.ChannelId, page(r.Text), post.RootId)
+		})
 	}
 }
 
@@ -250,53 +214,75 @@ func (s *MattermostMessagePlatform) sendReply(channelId, message, rootId string)
 	}
 }
 
-func (s *MattermostMessagePlatform) sendImageReply(channelId, message string, imageData []byte, rootId, originalContent string) {
+// Mattermost takes up to 10 files per post.
+const mattermostMaxFiles = 10
+
+func (s *MattermostMessagePlatform) sendImageReply(channelId, message string, images []*Attachment, rootId, originalContent string) {
 	ctx := context.Background()
 
-	// First, upload the image file
-	fileUploadResponse, _, err := s.Client.UploadFile(ctx, imageData, channelId, "image.png")
-	if err != nil {
-		log.Printf("Failed to upload image: %v", err)
+	// First, upload the image files. Images that are only online are linked.
+	fileIds := []string{}
+	for _, a := range images {
+		if len(a.Data) == 0 {
+			message = strings.TrimSpace(message + "\n" + a.Fallback(originalContent))
+			continue
+		}
+		fileUploadResponse, _, err := s.Client.UploadFile(ctx, a.Data, channelId, a.Name(originalContent))
+		if err != nil {
+			log.Printf("Failed to upload image: %v", err)
+			continue
+		}
+		fileIds = append(fileIds, fileUploadResponse.FileInfos[0].Id)
+	}
+	if len(fileIds) == 0 {
 		// Fallback to text message
 		s.sendReply(channelId, message, rootId)
 		return
 	}
 
-	// Create post with the uploaded file
-	post := &model.Post{
-		ChannelId: channelId,
-		Message:   message,
-		FileIds:   []string{fileUploadResponse.FileInfos[0].Id},
-	}
-
-	if rootId != "" {
-		post.RootId = rootId
-	}
-	log.Println("Sending image reply to channel:", channelId, "with root ID:", rootId)
+	// Create posts with the uploaded files
+	for len(fileIds) > 0 {
+		n := min(len(fileIds), mattermostMaxFiles)
+		post := &model.Post{
+			ChannelId: channelId,
+			Message:   message,
+			FileIds:   fileIds[:n],
+		}
+		if rootId != "" {
+			post.RootId = rootId
+		}
+		log.Println("Sending image reply to channel:", channelId, "with root ID:", rootId)
 
-	_, _, err = s.Client.CreatePost(ctx, post)
-	if err != nil {
-		log.Printf("Failed to send image reply: %v", err)
-		// Fallback to text message
-		s.sendReply(channelId, message, rootId)
+		_, _, err := s.Client.CreatePost(ctx, post)
+		if err != nil {
+			log.Printf("Failed to send image reply: %v", err)
+			// Fallback to text message
+			s.sendReply(channelId, message, rootId)
+			return
+		}
+		message = ""
+		fileIds = fileIds[n:]
 	}
 }
 
-func (s *MattermostMessagePlatform) downloadFile(fileId, localFilename string) error {
+// downloadAttachment returns nil if nobody wants the file.
+func (s *MattermostMessagePlatform) downloadAttachment(fileId string) (*Attachment, error) {
 	ctx := context.Background()
-	data, _, err := s.Client.GetFile(ctx, fileId)
+	info, _, err := s.Client.GetFileInfo(ctx, fileId)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	out, err := os.Create(localFilename)
+	if !WantsAttachment(info.MimeType) {
+		return nil, nil
+	}
+	if info.Size > DefaultMaxAttachmentSize {
+		return nil, fmt.Errorf("%s is bigger than %d bytes", info.Name, DefaultMaxAttachmentSize)
+	}
+	data, _, err := s.Client.GetFile(ctx, fileId)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer out.Close()
-
-	_, err = out.Write(data)
-	return err
+	return NewAttachment(info.Name, data, info.MimeType), nil
 }
 
 func (s *MattermostMessagePlatform) Send(text string) {
@@ -339,16 +325,88 @@ func (s *MattermostMessagePlatform) ChannelMessageSend(channel, message string)
 	// For now, assume channel is already an ID or we can use it directly
 	// In a full implementation, you might want to cache channel name->ID mappings
 
-	post := &model.Post{
-		ChannelId: channelId,
-		Message:   message,
+	// Nobody to say !more, send it all
+	ctx := context.Background()
+	for _, page := range SplitMessage(message, mattermostMaxMessage, runeSize) {
+		post := &model.Post{
+			ChannelId: channelId,
+			Message:   page,
+		}
+		_, _, err := s.Client.CreatePost(ctx, post)
+		if err != nil {
+			return fmt.Errorf("failed to send message to channel %s: %v", channel, err)
+		}
 	}
 
-	ctx := context.Background()
-	_, _, err := s.Client.CreatePost(ctx, post)
+	return nil
+}
+
+// resolveChannel finds the channel ID for a channel registry alias, a
+// channel name in our team, or an ID.
+func (s *MattermostMessagePlatform) resolveChannel(channel string) (string, error) {
+	if channel == "" {
+		channel = s.DefaultChannel
+	}
+	if channel == "" {
+		return "", fmt.Errorf("no channel specified")
+	}
+	if id, ok := Channels.Resolve("mattermost", channel); ok {
+		return id, nil
+	}
+	if model.IsValidId(channel) {
+		return channel, nil
+	}
+	c, _, err := s.Client.GetChannelByName(context.Background(), strings.TrimPrefix(channel, "~"), s.TeamId, "")
 	if err != nil {
-		return fmt.Errorf("failed to send message to channel %s: %v", channel, err)
+		return "", fmt.Errorf("unknown channel %s: %v", channel, err)
+	}
+	return c.Id, nil
+}
+
+// SendMessage sends to a channel, a thread by its root post ID, or a
+// username for a direct message. Mattermost has no silent messages.
+func (s *MattermostMessagePlatform) SendMessage(m OutboundMessage) error {
+	ctx := context.Background()
+	var channelId string
+	var err error
+	if m.User != "" {
+		user, _, err := s.Client.GetUserByUsername(ctx, strings.TrimPrefix(m.User, "@"), "")
+		if err != nil {
+			return err
+		}
+		dm, _, err := s.Client.CreateDirectChannel(ctx, s.User.Id, user.Id)
+		if err != nil {
+			return err
+		}
+		channelId = dm.Id
+	} else {
+		channelId, err = s.resolveChannel(m.Channel)
+		if err != nil {
+			return err
+		}
 	}
 
+	for _, page := range outboundPages(m.Text, mattermostMaxMessage, runeSize) {
+		_, _, err := s.Client.CreatePost(ctx, &model.Post{ChannelId: channelId, Message: page, RootId: m.Thread})
+		if err != nil {
+			return err
+		}
+	}
+	fileIds := []string{}
+	for _, a := range m.Files {
+		upload, _, err := s.Client.UploadFile(ctx, a.Data, channelId, a.Name("file"))
+		if err != nil {
+			return err
+		}
+		fileIds = append(fileIds, upload.FileInfos[0].Id)
+	}
+	for len(fileIds) > 0 {
+		n := min(len(fileIds), mattermostMaxFiles)
+		_, _, err := s.Client.CreatePost(ctx, &model.Post{ChannelId: channelId, FileIds: fileIds[:n], RootId: m.Thread})
+		if err != nil {
+			return err
+		}
+		fileIds = fileIds[n:]
+	}
 	return nil
 }
@@ -0,0 +1,256 @@
+package bothandler
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// The adapters normalize inbound text before the handlers see it, so the
+// same message reads the same on every platform: mentions become "@name",
+// links their text, formatting is stripped, and what was there is listed in
+// Request.Entities.
+
+const (
+	EntityMention   = "mention"
+	EntityChannel   = "channel"
+	EntityLink      = "link"
+	EntityCode      = "code"
+	EntityCodeBlock = "codeblock"
+	EntityEmoji     = "emoji"
+)
+
+// Entity is something structured in a message.
+type Entity struct {
+	Kind string
+	// Text is how it reads in the normalized text, e.g. "@alice", the text
+	// of a link, the code, or ":smile:".
+	Text string
+	// ID is the platform's id of a mentioned user or channel, or of a
+	// custom emoji.
+	ID  string
+	URL string
+}
+
+var (
+	slackToken      = regexp.MustCompile(`<([^<>]+)>`)
+	discordToken    = regexp.MustCompile(`<(@!?|@&|#|a?:[A-Za-z0-9_~]+:)(\d+)>`)
+	zulipMention    = regexp.MustCompile(`@_?\*\*([^*]+?)(\|\d+)?\*\*`)
+	ircFormatting   = regexp.MustCompile("\x03(\\d{1,2}(,\\d{1,2})?)?|[\x02\x0f\x11\x16\x1d\x1e\x1f]")
+	bareLink        = regexp.MustCompile(`https?://[^\s<>"]+[^\s<>".,;:!?)\]']`)
+	bareMention     = regexp.MustCompile(`(^|[^\w@.])(@[A-Za-z0-9_][A-Za-z0-9_.-]*[A-Za-z0-9_]|@[A-Za-z0-9_])`)
+	emojiShortcode  = regexp.MustCompile(`(^|[^\w:]):([a-z0-9_+-]+):`)
+	slackUnescaper  = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">")
+	markdownEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "~", `\~`, "[", `\[`, "]", `\]`)
+)
+
+// NormalizeText finds the links, @mentions and emoji in plain text, which
+// needs no other changes. Telegram, readline and the HTTP platforms use it.
+func NormalizeText(text string) (string, []Entity) {
+	return text, findEntities(text, nil)
+}
+
+// NormalizeMarkdown strips the markdown Discord, Mattermost, Zulip and
+// Matrix clients send, keeping code and links as entities.
+func NormalizeMarkdown(text string) (string, []Entity) {
+	return normalizeMarkdown(text, nil)
+}
+
+func normalizeMarkdown(text string, entities []Entity) (string, []Entity) {
+	out := strings.Builder{}
+	var walk func(nodes []MarkupNode)
+	walk = func(nodes []MarkupNode) {
+		for i, n := range nodes {
+			switch n.Kind {
+			case MarkupText:
+				out.WriteString(n.Text)
+			case MarkupBold, MarkupItalic, MarkupStrike:
+				walk(n.Children)
+			case MarkupCode:
+				out.WriteString(n.Text)
+				entities = append(entities, Entity{Kind: EntityCode, Text: n.Text})
+			case MarkupCodeBlock:
+				if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+					out.WriteString("\n")
+				}
+				out.WriteString(n.Text)
+				if i < len(nodes)-1 {
+					out.WriteString("\n")
+				}
+				entities = append(entities, Entity{Kind: EntityCodeBlock, Text: n.Text})
+			case MarkupLink:
+				out.WriteString(n.Text)
+				entities = append(entities, Entity{Kind: EntityLink, Text: n.Text, URL: n.URL})
+			}
+		}
+	}
+	walk(ParseMarkup(text))
+	plain := out.String()
+	return plain, findEntities(plain, entities)
+}
+
+// NormalizeSlack turns Slack's <@U123>, <#C123|general>, <!here> and
+// <http://x|x> into plain text. name looks up a user's name by id, and may
+// be nil.
+func NormalizeSlack(text string, name func(id string) string) (string, []Entity) {
+	entities := []Entity{}
+	text = replaceTokens(slackToken, text, func(m []string) string {
+		token := m[1]
+		value, label, _ := strings.Cut(token, "|")
+		switch {
+		case strings.HasPrefix(value, "@"):
+			id := value[1:]
+			if label == "" && name != nil {
+				label = name(id)
+			}
+			if label == "" {
+				label = id
+			}
+			entities = append(entities, Entity{Kind: EntityMention, Text: "@" + label, ID: id})
+			return markdownEscaper.Replace("@" + label)
+		case strings.HasPrefix(value, "#"):
+			id := value[1:]
+			if label == "" {
+				label = id
+			}
+			entities = append(entities, Entity{Kind: EntityChannel, Text: "#" + label, ID: id})
+			return markdownEscaper.Replace("#" + label)
+		case strings.HasPrefix(value, "!"):
+			// <!here>, <!channel>, <!subteam^ID|@team>
+			if label == "" {
+				label = "@" + strings.TrimPrefix(value, "!")
+			}
+			entities = append(entities, Entity{Kind: EntityMention, Text: label, ID: value})
+			return markdownEscaper.Replace(label)
+		default:
+			url := slackUnescaper.Replace(value)
+			label = slackUnescaper.Replace(label)
+			if label == "" {
+				label = url
+			}
+			entities = append(entities, Entity{Kind: EntityLink, Text: label, URL: url})
+			return markdownEscaper.Replace(label)
+		}
+	})
+	// Slack's *bold* and _italic_ are close enough to markdown to strip.
+	return normalizeMarkdown(slackUnescaper.Replace(text), entities)
+}
+
+// NormalizeDiscord turns Discord's <@id>, <#id> and <:emoji:id> into plain
+// text. names maps user, role and channel ids to names.
+func NormalizeDiscord(text string, names map[string]string) (string, []Entity) {
+	entities := []Entity{}
+	text = replaceTokens(discordToken, text, func(m []string) string {
+		kind, id := m[1], m[2]
+		name, ok := names[id]
+		if !ok {
+			name = id
+		}
+		switch {
+		case kind == "#":
+			entities = append(entities, Entity{Kind: EntityChannel, Text: "#" + name, ID: id})
+			return markdownEscaper.Replace("#" + name)
+		case strings.HasPrefix(kind, "@"):
+			entities = append(entities, Entity{Kind: EntityMention, Text: "@" + name, ID: id})
+			return markdownEscaper.Replace("@" + name)
+		default:
+			// Custom emoji, possibly animated
+			emoji := ":" + strings.Split(kind, ":")[1] + ":"
+			entities = append(entities, Entity{Kind: EntityEmoji, Text: emoji, ID: id})
+			return markdownEscaper.Replace(emoji)
+		}
+	})
+	return normalizeMarkdown(text, entities)
+}
+
+// NormalizeZulip turns Zulip's @**Full Name** mentions into @Full Name, and
+// strips markdown.
+func NormalizeZulip(text string) (string, []Entity) {
+	entities := []Entity{}
+	text = replaceTokens(zulipMention, text, func(m []string) string {
+		mention := "@" + m[1]
+		entities = append(entities, Entity{Kind: EntityMention, Text: mention, ID: strings.TrimPrefix(m[2], "|")})
+		return markdownEscaper.Replace(mention)
+	})
+	return normalizeMarkdown(text, entities)
+}
+
+// NormalizeIRC strips IRC formatting and colour codes.
+func NormalizeIRC(text string) (string, []Entity) {
+	text = ircFormatting.ReplaceAllString(text, "")
+	return text, findEntities(text, nil)
+}
+
+func replaceTokens(re *regexp.Regexp, text string, f func([]string) string) string {
+	return re.ReplaceAllStringFunc(text, func(s string) string {
+		return f(re.FindStringSubmatch(s))
+	})
+}
+
+// findEntities adds the bare links, @mentions and emoji in text to
+// entities, and sorts them in the order they appear.
+func findEntities(text string, entities []Entity) []Entity {
+	if entities == nil {
+		entities = []Entity{}
+	}
+	known := map[string]bool{}
+	for _, e := range entities {
+		known[e.Kind+" "+e.Text] = true
+		if e.URL != "" {
+			known[EntityLink+" "+e.URL] = true
+		}
+	}
+	add := func(kind, text, url string) {
+		if known[kind+" "+text] {
+			return
+		}
+		known[kind+" "+text] = true
+		entities = append(entities, Entity{Kind: kind, Text: text, URL: url})
+	}
+
+	for _, v := range bareLink.FindAllString(text, -1) {
+		add(EntityLink, v, v)
+	}
+	mentions := []string{}
+	for _, e := range entities {
+		if e.Kind == EntityMention {
+			mentions = append(mentions, e.Text)
+		}
+	}
+bare:
+	for _, v := range bareMention.FindAllStringSubmatch(text, -1) {
+		// Part of a mention with spaces, like Zulip's @Full Name
+		for _, m := range mentions {
+			if strings.HasPrefix(m, v[2]) {
+				continue bare
+			}
+		}
+		add(EntityMention, v[2], "")
+	}
+	for _, v := range emojiShortcode.FindAllStringSubmatch(text, -1) {
+		add(EntityEmoji, ":"+v[2]+":", "")
+	}
+	for _, r := range text {
+		if isEmoji(r) {
+			add(EntityEmoji, string(r), "")
+		}
+	}
+
+	sort.SliceStable(entities, func(i, j int) bool {
+		return entityIndex(text, entities[i]) < entityIndex(text, entities[j])
+	})
+	return entities
+}
+
+func entityIndex(text string, e Entity) int {
+	i := strings.Index(text, e.Text)
+	if i < 0 {
+		return len(text)
+	}
+	return i
+}
+
+func isEmoji(r rune) bool {
+	return (r >= 0x1F300 && r <= 0x1FAFF) || (r >= 0x2600 && r <= 0x27BF && unicode.Is(unicode.So, r))
+}
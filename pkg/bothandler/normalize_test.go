@@ -0,0 +1,73 @@
+package bothandler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	names := map[string]string{"U123": "alice", "42": "bob", "7": "general"}
+	slackName := func(id string) string { return names[id] }
+
+	tests := []struct {
+		name     string
+		got      func() (string, []Entity)
+		text     string
+		entities []Entity
+	}{
+		{"slack", func() (string, []Entity) {
+			return NormalizeSlack("<@U123> faz, see <https://x.com/a_b|x.com> &amp; <#C1|random> <!here>", slackName)
+		}, "@alice faz, see x.com & #random @here", []Entity{
+			{Kind: EntityMention, Text: "@alice", ID: "U123"},
+			{Kind: EntityLink, Text: "x.com", URL: "https://x.com/a_b"},
+			{Kind: EntityChannel, Text: "#random", ID: "C1"},
+			{Kind: EntityMention, Text: "@here", ID: "!here"},
+		}},
+		{"slack-bare-link", func() (string, []Entity) {
+			return NormalizeSlack("!xkcd <https://xkcd.com/353/>", nil)
+		}, "!xkcd https://xkcd.com/353/", []Entity{
+			{Kind: EntityLink, Text: "https://xkcd.com/353/", URL: "https://xkcd.com/353/"},
+		}},
+		{"discord", func() (string, []Entity) {
+			return NormalizeDiscord("<@!42> **faz** in <#7> <:pepe_hands:99> `go test`", names)
+		}, "@bob faz in #general :pepe_hands: go test", []Entity{
+			{Kind: EntityMention, Text: "@bob", ID: "42"},
+			{Kind: EntityChannel, Text: "#general", ID: "7"},
+			{Kind: EntityEmoji, Text: ":pepe_hands:", ID: "99"},
+			{Kind: EntityCode, Text: "go test"},
+		}},
+		{"zulip", func() (string, []Entity) {
+			return NormalizeZulip("@**Alice Tan|12** look at [this](https://example.com) :smile:")
+		}, "@Alice Tan look at this :smile:", []Entity{
+			{Kind: EntityMention, Text: "@Alice Tan", ID: "12"},
+			{Kind: EntityLink, Text: "this", URL: "https://example.com"},
+			{Kind: EntityEmoji, Text: ":smile:"},
+		}},
+		{"markdown-codeblock", func() (string, []Entity) {
+			return NormalizeMarkdown("run\n```\nmake test\n```")
+		}, "run\nmake test", []Entity{
+			{Kind: EntityCodeBlock, Text: "make test"},
+		}},
+		{"irc", func() (string, []Entity) {
+			return NormalizeIRC("\x02faz\x02 \x0304,01red\x03 http://example.com/x. @carol 👍")
+		}, "faz red http://example.com/x. @carol 👍", []Entity{
+			{Kind: EntityLink, Text: "http://example.com/x", URL: "http://example.com/x"},
+			{Kind: EntityMention, Text: "@carol"},
+			{Kind: EntityEmoji, Text: "👍"},
+		}},
+		{"text-email-not-mention", func() (string, []Entity) {
+			return NormalizeText("mail bob@example.com")
+		}, "mail bob@example.com", []Entity{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, entities := tt.got()
+			if text != tt.text {
+				t.Errorf("text got %q want %q", text, tt.text)
+			}
+			if !reflect.DeepEqual(entities, tt.entities) {
+				t.Errorf("entities got %+v want %+v", entities, tt.entities)
+			}
+		})
+	}
+}
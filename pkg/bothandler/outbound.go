@@ -0,0 +1,123 @@
+package bothandler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// OutboundMessage is a message the bot sends on its own, e.g. from
+// sendmsg, rather than in reply to someone.
+type OutboundMessage struct {
+	// Channel is a channel name, a channel registry alias, or an ID, as for
+	// ChannelMessageSend. Empty is the platform's default channel.
+	Channel string
+	// User, if set, is sent a direct message instead of Channel.
+	User string
+	// Thread is where in the channel to send: a Discord thread ID, a Slack
+	// thread timestamp, a Telegram message ID to reply to, a Mattermost root
+	// post ID, a Matrix thread root event ID, or a Zulip topic.
+	Thread string
+	Text   string
+	Files  []*Attachment
+	Silent bool
+}
+
+// Sender is a platform that can send an OutboundMessage without
+// ProcessMessages running. SendMessage returns once the platform has
+// accepted the message, or with why it didn't.
+type Sender interface {
+	SendMessage(m OutboundMessage) error
+}
+
+// ErrUnsupported is returned for what a platform can't send, e.g. files on
+// IRC.
+type ErrUnsupported struct {
+	Platform string
+	What     string
+}
+
+func (e *ErrUnsupported) Error() string {
+	return fmt.Sprintf("%s can't send %s", e.Platform, e.What)
+}
+
+// outboundPages splits the text into what fits in a message. Nobody can
+// say !more to a bot that isn't listening, so it is all sent.
+func outboundPages(text string, limit int, size SizeFunc) []string {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	return SplitMessage(text, limit, size)
+}
+
+// PlatformName is a platform's name as in Request.Platform, e.g. "discord".
+// Other platforms, like fakes in tests, can have a PlatformName method.
+func PlatformName(m MessagePlatform) string {
+	if named, ok := m.(interface{ PlatformName() string }); ok {
+		return named.PlatformName()
+	}
+	switch p := m.(type) {
+	case *DiscordMessagePlatform, *DiscordWebhookMessagePlatform:
+		return "discord"
+	case *SlackMessagePlatform, *SlackWebhookMessagePlatform:
+		return "slack"
+	case *TelegramMessagePlatform:
+		return "telegram"
+	case *MattermostMessagePlatform:
+		return "mattermost"
+	case *MatrixMessagePlatform:
+		return "matrix"
+	case *ZulipMessagePlatform:
+		return "zulip"
+	case *IrcMessagePlatform:
+		return "irc"
+	case *HTTPMessagePlatform:
+		return "http"
+	case *WebchatMessagePlatform:
+		return "webchat"
+	case *ReadlineMessagePlatform:
+		return p.Platform
+	}
+	return fmt.Sprintf("%T", m)
+}
+
+// SendResult is how sending to one platform went.
+type SendResult struct {
+	Platform string
+	Err      error
+}
+
+// SendAll sends m on every active platform, or only those named in
+// platforms if any. Unlike ChannelMessageSend it carries on past errors,
+// and returns each platform's result. Platforms that aren't a Sender get
+// the text with ChannelMessageSend.
+func SendAll(m OutboundMessage, platforms []string) []SendResult {
+	targets := []MessagePlatform{}
+	for _, p := range ActiveMessagePlatforms {
+		if len(platforms) == 0 || slices.Contains(platforms, PlatformName(p)) {
+			targets = append(targets, p)
+		}
+	}
+	results := make([]SendResult, len(targets))
+	var wg sync.WaitGroup
+	for i, p := range targets {
+		name := PlatformName(p)
+		results[i].Platform = name
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s, ok := p.(Sender); ok {
+				results[i].Err = s.SendMessage(m)
+				return
+			}
+			if m.User != "" || m.Thread != "" || len(m.Files) > 0 {
+				results[i].Err = &ErrUnsupported{name, "direct messages, threads or files"}
+				return
+			}
+			results[i].Err = p.ChannelMessageSend(m.Channel, m.Text)
+		}()
+	}
+	wg.Wait()
+	return results
+}
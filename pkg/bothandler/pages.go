@@ -0,0 +1,196 @@
+package bothandler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Responses longer than a platform allows are split into pages. The first
+// page is sent, the rest is kept per platform, channel and user until they
+// say "!more", for up to morePagesExpiry.
+
+// Message size limits, as measured by the size functions below.
+const (
+	discordMaxMessage    = 2000
+	telegramMaxMessage   = 4096
+	slackMaxMessage      = 4000 // Slack truncates longer text in clients
+	mattermostMaxMessage = 16383
+	zulipMaxMessage      = 10000
+	matrixMaxMessage     = 32768 // Well inside the 64KiB event limit
+)
+
+// A SizeFunc measures a response in a platform's units after rendering.
+type SizeFunc func(string) int
+
+func runeSize(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+// telegramSize is in UTF-16 code units of the text without the HTML.
+func telegramSize(s string) int {
+	return len(utf16.Encode([]rune(RenderPlain(s))))
+}
+
+func slackSize(s string) int {
+	return utf8.RuneCountInString(RenderSlack(s))
+}
+
+func matrixSize(s string) int {
+	return len(RenderPlain(s)) + len(RenderMatrixHTML(s))
+}
+
+type pageKey struct {
+	Platform string
+	Channel  string
+	User     string
+}
+
+// The pages left are forgotten after morePagesExpiry without a "!more", and
+// the ones that would expire first are dropped when more than
+// maxMorePagesUsers have any.
+const morePagesExpiry = time.Hour
+const maxMorePagesUsers = 1000
+
+type morePagesLeft struct {
+	pages   []string
+	expires time.Time
+}
+
+var morePages = map[pageKey]morePagesLeft{}
+var morePagesLock sync.Mutex
+
+func init() {
+	RegisterCatchallHandler(MoreHandler)
+	RegisterCommand("!more", "Next page of a long response")
+}
+
+// Paginate returns the first page of text that fits in limit, keeping the
+// rest for "!more" from the same channel and user. Text that fits is
+// returned as is.
+func Paginate(r Request, text string, limit int, size SizeFunc) string {
+	if limit <= 0 || size(text) <= limit {
+		return text
+	}
+	pages := SplitMessage(text, limit-size(moreFooter(99)), size)
+	if len(pages) < 2 {
+		return text
+	}
+	key := pageKey{r.Platform, r.Channel, r.From}
+	now := Now()
+	morePagesLock.Lock()
+	defer morePagesLock.Unlock()
+	for k, v := range morePages {
+		if now.After(v.expires) {
+			delete(morePages, k)
+		}
+	}
+	if _, ok := morePages[key]; !ok && len(morePages) >= maxMorePagesUsers {
+		oldest := key
+		for k, v := range morePages {
+			if oldest == key || v.expires.Before(morePages[oldest].expires) {
+				oldest = k
+			}
+		}
+		delete(morePages, oldest)
+	}
+	morePages[key] = morePagesLeft{pages[1:], now.Add(morePagesExpiry)}
+	return pages[0] + moreFooter(len(pages)-1)
+}
+
+// MoreHandler answers "!more" with the next page of the last long response
+// to the same channel and user.
+func MoreHandler(r Request) string {
+	if r.Content != "!more" {
+		return ""
+	}
+	key := pageKey{r.Platform, r.Channel, r.From}
+	now := Now()
+	morePagesLock.Lock()
+	defer morePagesLock.Unlock()
+	left, ok := morePages[key]
+	if !ok || now.After(left.expires) {
+		delete(morePages, key)
+		return ""
+	}
+	if len(left.pages) == 1 {
+		delete(morePages, key)
+		return left.pages[0]
+	}
+	morePages[key] = morePagesLeft{left.pages[1:], now.Add(morePagesExpiry)}
+	return left.pages[0] + moreFooter(len(left.pages)-1)
+}
+
+func moreFooter(n int) string {
+	if n == 1 {
+		return "\n(1 more page, say !more)"
+	}
+	return fmt.Sprintf("\n(%d more pages, say !more)", n)
+}
+
+// SplitMessage splits text into pages that each fit in limit. It splits at
+// a blank line, a line break or a space if it can, and closes and reopens
+// a code block that is split. There is always at least one page.
+func SplitMessage(text string, limit int, size SizeFunc) []string {
+	pages := []string{}
+	fence := 0
+	if strings.Contains(text, "```") {
+		fence = size("\n```")
+	}
+	for size(text) > limit {
+		cut := splitPoint(text, limit-fence, size)
+		page := strings.TrimRight(text[:cut], " \n")
+		rest := strings.TrimLeft(text[cut:], " \n")
+		if strings.Count(page, "```")%2 == 1 {
+			page += "\n```"
+			rest = "```\n" + rest
+		}
+		pages = append(pages, page)
+		text = rest
+	}
+	if len(pages) == 0 || strings.TrimSpace(text) != "" {
+		pages = append(pages, text)
+	}
+	return pages
+}
+
+// splitPoint finds where to cut text so that text[:cut] fits in limit,
+// always making progress.
+func splitPoint(text string, limit int, size SizeFunc) int {
+	// The longest prefix that fits
+	lo, hi := 0, len(text)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		for mid < len(text) && !utf8.RuneStart(text[mid]) {
+			mid--
+		}
+		if mid <= lo {
+			_, n := utf8.DecodeRuneInString(text[lo:])
+			mid = lo + n
+		}
+		if mid > hi {
+			break
+		}
+		if size(text[:mid]) <= limit {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	if lo == 0 {
+		_, n := utf8.DecodeRuneInString(text)
+		return n
+	}
+	if lo == len(text) {
+		return lo
+	}
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		if i := strings.LastIndex(text[:lo], sep); i > lo/2 {
+			return i
+		}
+	}
+	return lo
+}
@@ -0,0 +1,118 @@
+package bothandler
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"fits", "hello world", 20, []string{"hello world"}},
+		{"empty", "", 20, []string{""}},
+		{"paragraphs", "one two\n\nthree four five", 12, []string{"one two", "three four", "five"}},
+		{"lines", "one\ntwo three\nfour", 14, []string{"one\ntwo three", "four"}},
+		{"spaces", "the quick brown fox jumps", 10, []string{"the quick", "brown fox", "jumps"}},
+		{"no-space", "abcdefghijkl", 5, []string{"abcde", "fghij", "kl"}},
+		{"runes", "ééééé", 2, []string{"éé", "éé", "é"}},
+		{"code-block", "```\naaa bbb\nccc ddd\n```", 16, []string{"```\naaa bbb\n```", "```\nccc ddd\n```"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitMessage(tt.text, tt.limit, runeSize)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+			for _, page := range got {
+				if runeSize(page) > tt.limit {
+					t.Errorf("page %q is over %d", page, tt.limit)
+				}
+			}
+		})
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	words := []string{}
+	for i := 0; i < 30; i++ {
+		words = append(words, "word")
+	}
+	text := strings.Join(words, " ")
+	alice := Request{Content: "!dict", Platform: "discord", Channel: "general", From: "alice"}
+	bob := Request{Content: "!more", Platform: "discord", Channel: "general", From: "bob"}
+
+	first := Paginate(alice, text, 60, runeSize)
+	if runeSize(first) > 60 || !strings.HasSuffix(first, "\n(4 more pages, say !more)") {
+		t.Errorf("first page %q", first)
+	}
+	if Paginate(alice, "short", 60, runeSize) != "short" {
+		t.Error("short text should be as is")
+	}
+	if r := MoreHandler(bob); r != "" {
+		t.Errorf("bob has no pages, got %q", r)
+	}
+
+	alice.Content = "!more"
+	pages := []string{strings.TrimSuffix(first, "\n(4 more pages, say !more)")}
+	for {
+		r := MoreHandler(alice)
+		if r == "" {
+			break
+		}
+		if runeSize(r) > 60 {
+			t.Errorf("page %q is over 60", r)
+		}
+		r, _, _ = strings.Cut(r, "\n(")
+		pages = append(pages, r)
+	}
+	if len(pages) != 5 || strings.Join(pages, " ") != text {
+		t.Errorf("pages %q", pages)
+	}
+}
+
+func TestMorePagesExpire(t *testing.T) {
+	clock := &sleepClock{now: time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)}
+	defer SetClock(SetClock(clock))
+	oldPages := morePages
+	morePages = map[pageKey]morePagesLeft{}
+	defer func() { morePages = oldPages }()
+
+	text := strings.Repeat("word ", 30)
+	more := func(user string) string {
+		return MoreHandler(Request{Content: "!more", Platform: "discord", Channel: "general", From: user})
+	}
+	paginate := func(user string) {
+		Paginate(Request{Platform: "discord", Channel: "general", From: user}, text, 60, runeSize)
+	}
+
+	paginate("alice")
+	clock.Sleep(morePagesExpiry / 2)
+	if more("alice") == "" {
+		t.Error("pages expired too early")
+	}
+	clock.Sleep(morePagesExpiry + time.Second)
+	if r := more("alice"); r != "" {
+		t.Errorf("expired pages still served: %q", r)
+	}
+	if len(morePages) != 0 {
+		t.Errorf("expired pages kept: %v", morePages)
+	}
+
+	for i := 0; i <= maxMorePagesUsers; i++ {
+		paginate(fmt.Sprint("user", i))
+		clock.Sleep(time.Millisecond)
+	}
+	if len(morePages) != maxMorePagesUsers {
+		t.Errorf("got pages for %d users, want %d", len(morePages), maxMorePagesUsers)
+	}
+	if more("user0") != "" || more(fmt.Sprint("user", maxMorePagesUsers)) == "" {
+		t.Error("the oldest pages should be dropped first")
+	}
+}
@@ -0,0 +1,189 @@
+package bothandler
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Plugin is a part of the bot that can be chosen with --plugins. Plugins
+// call RegisterPlugin from init(), and leave everything else, like
+// registering their handlers, reading files or starting goroutines, to Init
+// and Start, so a plugin that isn't chosen, or can't be configured, does
+// nothing.
+type Plugin interface {
+	Name() string
+	// Init configures the plugin and registers its handlers. A plugin that
+	// returns an error is disabled.
+	Init(config PluginConfig) error
+	// Start starts the plugin's background work, once the platforms are
+	// connected.
+	Start() error
+	// Stop stops the background work.
+	Stop()
+}
+
+// PluginRequirer is a plugin that needs other plugins. They are initialized
+// before it, even if they weren't chosen, and it is disabled if they are.
+type PluginRequirer interface {
+	Requires() []string
+}
+
+// PluginConfig is a plugin's settings, named like their environment
+// variables, e.g. SD_URL.
+type PluginConfig map[string]string
+
+// Get returns the setting from the environment, or else from the config.
+func (c PluginConfig) Get(key string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return c[key]
+}
+
+// pluginFunc is a plugin without background work.
+type pluginFunc struct {
+	name string
+	init func(PluginConfig) error
+}
+
+func (p *pluginFunc) Name() string                   { return p.name }
+func (p *pluginFunc) Init(config PluginConfig) error { return p.init(config) }
+func (p *pluginFunc) Start() error                   { return nil }
+func (p *pluginFunc) Stop()                          {}
+
+// Plugins are the registered plugins, in the order they registered.
+var Plugins = []Plugin{}
+
+var pluginsLock sync.Mutex
+var initializedPlugins = map[string]bool{}
+var enabledPlugins = []Plugin{}
+var startedPlugins = []Plugin{}
+
+func RegisterPlugin(p Plugin) {
+	Plugins = append(Plugins, p)
+}
+
+// RegisterPluginFunc registers a plugin without background work, whose
+// init function configures it and registers its handlers.
+func RegisterPluginFunc(name string, init func(config PluginConfig) error) {
+	RegisterPlugin(&pluginFunc{name, init})
+}
+
+// RegisterHandlerPlugin registers a plugin with nothing to configure or
+// run, whose register function registers its handlers.
+func RegisterHandlerPlugin(name string, register func()) {
+	RegisterPluginFunc(name, func(PluginConfig) error {
+		register()
+		return nil
+	})
+}
+
+// PluginNames are the names of the registered plugins.
+func PluginNames() []string {
+	names := []string{}
+	for _, p := range Plugins {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// InitPlugins initializes the plugins named, or every plugin if names is
+// empty, and the plugins they require. A plugin that fails to initialize,
+// or requires one that does, is disabled with a warning, and an unknown
+// name is an error. Plugins are only initialized once.
+func InitPlugins(names []string, configs map[string]PluginConfig) error {
+	for _, name := range names {
+		if !slices.Contains(PluginNames(), name) {
+			return fmt.Errorf("unknown plugin %q, choose from %s", name, strings.Join(PluginNames(), ", "))
+		}
+	}
+
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	for _, p := range Plugins {
+		if len(names) > 0 && !slices.Contains(names, p.Name()) {
+			continue
+		}
+		initPlugin(p, configs, nil)
+	}
+	return nil
+}
+
+// initPlugin initializes p after the plugins it requires, and returns
+// whether it is enabled. requiredBy are the plugins being initialized that
+// are waiting for p. It must be called with pluginsLock held.
+func initPlugin(p Plugin, configs map[string]PluginConfig, requiredBy []string) bool {
+	name := p.Name()
+	if initializedPlugins[name] {
+		return slices.Contains(enabledPlugins, p)
+	}
+	if slices.Contains(requiredBy, name) {
+		log.Printf("Plugin %s is disabled: it requires itself through %s", name, strings.Join(requiredBy, ", "))
+		return false
+	}
+	if r, ok := p.(PluginRequirer); ok {
+		for _, v := range r.Requires() {
+			i := slices.IndexFunc(Plugins, func(p Plugin) bool { return p.Name() == v })
+			if i < 0 || !initPlugin(Plugins[i], configs, append(requiredBy, name)) {
+				initializedPlugins[name] = true
+				log.Printf("Plugin %s is disabled: it requires %s", name, v)
+				return false
+			}
+		}
+	}
+
+	initializedPlugins[name] = true
+	config := configs[name]
+	if config == nil {
+		config = PluginConfig{}
+	}
+	err := p.Init(config)
+	if err != nil {
+		log.Printf("Plugin %s is disabled: %v", name, err)
+		return false
+	}
+	enabledPlugins = append(enabledPlugins, p)
+	return true
+}
+
+// EnabledPlugins are the names of the plugins initialized without errors.
+func EnabledPlugins() []string {
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	names := []string{}
+	for _, p := range enabledPlugins {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// StartPlugins starts the enabled plugins that haven't been started.
+func StartPlugins() {
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	for _, p := range enabledPlugins {
+		if slices.Contains(startedPlugins, p) {
+			continue
+		}
+		err := p.Start()
+		if err != nil {
+			log.Printf("Plugin %s didn't start: %v", p.Name(), err)
+			continue
+		}
+		startedPlugins = append(startedPlugins, p)
+	}
+}
+
+// StopPlugins stops the started plugins, the last started first.
+func StopPlugins() {
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	for i := len(startedPlugins) - 1; i >= 0; i-- {
+		startedPlugins[i].Stop()
+	}
+	startedPlugins = nil
+}
@@ -0,0 +1,109 @@
+package bothandler
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type testPlugin struct {
+	name   string
+	err    error
+	events *[]string
+}
+
+func (p *testPlugin) Name() string { return p.name }
+func (p *testPlugin) Init(config PluginConfig) error {
+	*p.events = append(*p.events, "init "+p.name+" "+config.Get("TEST_PLUGIN_GREETING"))
+	return p.err
+}
+func (p *testPlugin) Start() error {
+	*p.events = append(*p.events, "start "+p.name)
+	return nil
+}
+func (p *testPlugin) Stop() { *p.events = append(*p.events, "stop "+p.name) }
+
+type requiringPlugin struct {
+	testPlugin
+	requires []string
+}
+
+func (p *requiringPlugin) Requires() []string { return p.requires }
+
+func TestPlugins(t *testing.T) {
+	oldPlugins, oldInitialized, oldEnabled, oldStarted := Plugins, initializedPlugins, enabledPlugins, startedPlugins
+	defer func() {
+		Plugins, initializedPlugins, enabledPlugins, startedPlugins = oldPlugins, oldInitialized, oldEnabled, oldStarted
+	}()
+	Plugins, initializedPlugins, enabledPlugins, startedPlugins = nil, map[string]bool{}, nil, nil
+
+	events := []string{}
+	RegisterPlugin(&testPlugin{"apod", nil, &events})
+	RegisterPlugin(&testPlugin{"sd", errors.New("need SD_URL"), &events})
+	RegisterPlugin(&testPlugin{"feed", nil, &events})
+	RegisterHandlerPlugin("echo", func() { events = append(events, "register echo") })
+
+	err := InitPlugins([]string{"feed", "nope"}, nil)
+	if err == nil || !strings.Contains(err.Error(), `unknown plugin "nope", choose from apod, sd, feed, echo`) {
+		t.Errorf("expected an unknown plugin error, got %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("expected nothing initialized, got %v", events)
+	}
+
+	t.Setenv("TEST_PLUGIN_GREETING", "hi")
+	err = InitPlugins([]string{"sd", "feed", "echo"}, map[string]PluginConfig{
+		"feed": {"TEST_PLUGIN_GREETING": "hello"},
+		"sd":   {"OTHER": "x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Initialized once
+	InitPlugins([]string{"feed"}, nil)
+	if got := EnabledPlugins(); !slices.Equal(got, []string{"feed", "echo"}) {
+		t.Errorf("expected sd disabled, got %v", got)
+	}
+
+	StartPlugins()
+	StartPlugins()
+	StopPlugins()
+	StopPlugins()
+	want := []string{"init sd hi", "init feed hi", "register echo", "start feed", "stop feed"}
+	if !slices.Equal(events, want) {
+		t.Errorf("expected %v, got %v", want, events)
+	}
+
+	if got := (PluginConfig{"A": "config"}).Get("A"); got != "config" {
+		t.Errorf("expected the config's setting, got %q", got)
+	}
+}
+
+func TestPluginRequires(t *testing.T) {
+	oldPlugins, oldInitialized, oldEnabled, oldStarted := Plugins, initializedPlugins, enabledPlugins, startedPlugins
+	defer func() {
+		Plugins, initializedPlugins, enabledPlugins, startedPlugins = oldPlugins, oldInitialized, oldEnabled, oldStarted
+	}()
+	Plugins, initializedPlugins, enabledPlugins, startedPlugins = nil, map[string]bool{}, nil, nil
+
+	events := []string{}
+	RegisterPlugin(&requiringPlugin{testPlugin{"feed", nil, &events}, []string{"channel"}})
+	RegisterPlugin(&requiringPlugin{testPlugin{"draw", nil, &events}, []string{"sd"}})
+	RegisterPlugin(&requiringPlugin{testPlugin{"loop", nil, &events}, []string{"loop"}})
+	RegisterPlugin(&requiringPlugin{testPlugin{"lost", nil, &events}, []string{"nope"}})
+	RegisterPlugin(&testPlugin{"sd", errors.New("need SD_URL"), &events})
+	RegisterPlugin(&testPlugin{"channel", nil, &events})
+
+	err := InitPlugins([]string{"feed", "draw", "loop", "lost"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := EnabledPlugins(); !slices.Equal(got, []string{"channel", "feed"}) {
+		t.Errorf("expected channel, then feed, got %v", got)
+	}
+	want := []string{"init channel ", "init feed ", "init sd "}
+	if !slices.Equal(events, want) {
+		t.Errorf("expected %v, got %v", want, events)
+	}
+}
@@ -1,19 +1,45 @@
 package bothandler
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/chzyer/readline"
 )
 
+// The readline platform is for trying the bot on the command line. Besides
+// messages, it understands:
+//
+//	/attach <file> [caption]  send a file, as if uploaded
+//	/press <n>                click button n of the last response
+//
+// Messages appear to come from Platform, Channel and User, so plugins that
+// only answer in some channels can be tried.
+
 // Implements MessagePlatform
 type ReadlineMessagePlatform struct {
 	Instance *readline.Instance
 	Signal   chan os.Signal
+
+	Platform string
+	Channel  string
+	User     string
+	// ImageDir is where image responses are written. Without it, images
+	// are only described.
+	ImageDir string
+
+	// For scripts, lines are read from script and the transcript written
+	// to out.
+	script  io.Reader
+	out     io.Writer
+	buttons []Button
+	images  int
 }
 
 func NewMessagePlatformFromReadline(historyfile string, signal chan os.Signal) (*ReadlineMessagePlatform, error) {
@@ -32,9 +58,22 @@ func NewMessagePlatformFromReadline(historyfile string, signal chan os.Signal) (
 	return &ReadlineMessagePlatform{
 		Instance: l,
 		Signal:   signal,
+		Platform: "readline",
+		out:      os.Stdout,
 	}, nil
 }
 
+// NewMessagePlatformFromScript reads messages from script, one per line,
+// and writes each with its responses to out. Empty lines and lines starting
+// with "#" are skipped.
+func NewMessagePlatformFromScript(script io.Reader, out io.Writer) *ReadlineMessagePlatform {
+	return &ReadlineMessagePlatform{
+		Platform: "readline",
+		script:   script,
+		out:      out,
+	}
+}
+
 func (s *ReadlineMessagePlatform) Send(text string) {
 	log.Println(text)
 }
@@ -47,8 +86,12 @@ func (s *ReadlineMessagePlatform) SendWithOptions(text string, options SendOptio
 }
 
 func (s *ReadlineMessagePlatform) ProcessMessages() {
+	if s.Instance == nil {
+		s.processScript()
+		return
+	}
+
 	l := s.Instance
-outer:
 	for {
 		line, err := l.Readline()
 		if err == readline.ErrInterrupt {
@@ -61,41 +104,99 @@ outer:
 			break
 		}
 
-		content := line
-		h, ok := Handlers[content]
-		if ok {
-			response := h()
-			fmt.Println("Bot says", response)
+		s.handleLine(line)
+
+		if line == "bye" || line == "quit" {
+			s.Signal <- os.Interrupt
+			break
 		}
+	}
+}
 
-		sliced_content := strings.SplitN(content, " ", 2)
-		if len(sliced_content) > 1 {
-			command := sliced_content[0]
-			actual_content := sliced_content[1]
-
-			ih, ok := MsgInputHandlers[command]
-			if ok {
-				response := ih(Request{actual_content, "readline", "", ""})
-				if response != "" {
-					fmt.Println("Bot says", response)
-				}
-			}
+func (s *ReadlineMessagePlatform) processScript() {
+	scanner := bufio.NewScanner(s.script)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
+		fmt.Fprintln(s.out, "»", line)
+		s.handleLine(line)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println(err)
+	}
+}
 
-		// Can be better to decouple 1 to 1 of message : response
-		for _, v := range CatchallHandlers {
-			r := v(Request{content, "readline", "", ""})
-			if r != "" {
-				fmt.Println(">", r)
-			}
+func (s *ReadlineMessagePlatform) handleLine(line string) {
+	content, entities := NormalizeText(line)
+	request := Request{Content: content, Platform: s.Platform, Channel: s.Channel, From: s.User, Entities: entities}
+
+	command, rest, _ := strings.Cut(content, " ")
+	switch command {
+	case "/attach":
+		filename, caption, _ := strings.Cut(rest, " ")
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintln(s.out, "!", err)
+			return
+		}
+		request.Content = caption
+		DispatchAttachment(NewAttachment(filepath.Base(filename), data, ""), request, s.replier(caption))
+	case "/press":
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 1 || n > len(s.buttons) {
+			fmt.Fprintln(s.out, "! No button", rest)
+			return
+		}
+		b := s.buttons[n-1]
+		h, ok := CallbackHandlers[b.Plugin]
+		if !ok {
+			fmt.Fprintln(s.out, "! No callback handler for", b.Plugin)
+			return
 		}
+		request.Content = b.Data
+		request.Entities = nil
+		if r := h(request); r != nil {
+			s.replier(b.Text)(r)
+		}
+	default:
+		Dispatch(request, s.replier(content))
+	}
+}
 
-		switch {
-		case line == "bye", line == "quit":
-			s.Signal <- os.Interrupt
-			break outer
+// replier prints responses, content is used to name images.
+func (s *ReadlineMessagePlatform) replier(content string) Replier {
+	return func(r *ExtendedMessage) {
+		if r.Text != "" {
+			fmt.Fprintln(s.out, ">", RenderPlain(r.Text))
 		}
+		for _, a := range r.AllImages() {
+			fmt.Fprintln(s.out, ">", s.saveImage(a, content))
+		}
+		if len(r.Buttons) > 0 {
+			s.buttons = r.Buttons
+			for i, b := range r.Buttons {
+				fmt.Fprintf(s.out, "> [%d] %s\n", i+1, b.Text)
+			}
+		}
+	}
+}
+
+// saveImage writes a to ImageDir and returns the path, or describes it if
+// it can't.
+func (s *ReadlineMessagePlatform) saveImage(a *Attachment, content string) string {
+	if s.ImageDir == "" || len(a.Data) == 0 {
+		return a.Fallback(content)
+	}
+	s.images++
+	filename := filepath.Join(s.ImageDir, fmt.Sprintf("%03d-%s", s.images, a.Name(content)))
+	err := os.WriteFile(filename, a.Data, 0644)
+	if err != nil {
+		log.Println(err)
+		return a.Fallback(content)
 	}
+	return filename
 }
 
 func (s *ReadlineMessagePlatform) Close() {
@@ -105,5 +206,6 @@ func (s *ReadlineMessagePlatform) Close() {
 }
 
 func (s *ReadlineMessagePlatform) ChannelMessageSend(channelId, message string) error {
+	fmt.Fprintf(s.out, "> (%s) %s\n", channelId, RenderPlain(message))
 	return nil
 }
@@ -0,0 +1,77 @@
+package bothandler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadlineScript(t *testing.T) {
+	oldHandlers, oldInput, oldAttachmentHandlers, oldExtended, oldCallbacks := Handlers, MsgInputHandlers, AttachmentHandlers, CatchallExtendedHandlers, CallbackHandlers
+	Handlers = map[string]MessageHandler{"ping": func() string { return "pong" }}
+	MsgInputHandlers = map[string]MessageWithInputHandler{"!whoami": func(r Request) string {
+		return r.Platform + "/" + r.Channel + "/" + r.From + ": " + r.Content
+	}}
+	AttachmentHandlers = nil
+	RegisterAttachmentHandler(nil, func(a *Attachment, r Request) string {
+		return r.Content + " " + a.Filename + " " + string(a.Data)
+	})
+	CatchallExtendedHandlers = []CatchallExtendedHandler{func(m ExtendedMessage) *ExtendedMessage {
+		if m.Text != "draw" {
+			return nil
+		}
+		return &ExtendedMessage{Text: "**drawn**", Image: testPNG, Buttons: []Button{{"Again", "test", "again"}}}
+	}}
+	CallbackHandlers = map[string]CallbackHandler{"test": func(r Request) *ExtendedMessage {
+		return &ExtendedMessage{Text: "pressed " + r.Content + " by " + r.From}
+	}}
+	defer func() {
+		Handlers, MsgInputHandlers, AttachmentHandlers, CatchallExtendedHandlers, CallbackHandlers = oldHandlers, oldInput, oldAttachmentHandlers, oldExtended, oldCallbacks
+	}()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "note.txt"), []byte("hello"), 0644)
+	images := filepath.Join(dir, "images")
+	os.Mkdir(images, 0755)
+
+	script := strings.Join([]string{
+		"# comment",
+		"ping",
+		"",
+		"!whoami hi",
+		"draw",
+		"/press 1",
+		"/press 2",
+		"/attach " + filepath.Join(dir, "note.txt") + " look",
+	}, "\n")
+	out := &strings.Builder{}
+	s := NewMessagePlatformFromScript(strings.NewReader(script), out)
+	s.Platform, s.Channel, s.User, s.ImageDir = "discord", "general", "alice", images
+	s.ProcessMessages()
+
+	want := strings.Join([]string{
+		"» ping",
+		"> pong",
+		"» !whoami hi",
+		"> discord/general/alice: hi",
+		"» draw",
+		"> drawn",
+		"> " + filepath.Join(images, "001-draw.png"),
+		"> [1] Again",
+		"» /press 1",
+		"> pressed again by alice",
+		"» /press 2",
+		"! No button 2",
+		"» /attach " + filepath.Join(dir, "note.txt") + " look",
+		"> look note.txt hello",
+		"",
+	}, "\n")
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+	b, err := os.ReadFile(filepath.Join(images, "001-draw.png"))
+	if err != nil || string(b) != string(testPNG) {
+		t.Errorf("image not written: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package bothandler
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Inbound messages and the bot's responses can be recorded to a JSONL
+// file, one Recording per line, and replayed through the current handlers
+// to see how a change alters what the bot says (multibot replay).
+
+// Recording is a message, after normalization, and what the bot said.
+type Recording struct {
+	Time       time.Time  `json:"time"`
+	Request    Request    `json:"request"`
+	Attachment *Recorded  `json:"attachment,omitempty"`
+	Responses  []Response `json:"responses"`
+}
+
+// Recorded is an attachment sent to the bot, with its data.
+type Recorded struct {
+	Filename string `json:"filename,omitempty"`
+	MimeType string `json:"mime_type"`
+	Data     []byte `json:"data"`
+}
+
+// Response is one response, with images summarized by their hash.
+type Response struct {
+	Text    string   `json:"text,omitempty"`
+	Images  []string `json:"images,omitempty"`
+	Buttons []Button `json:"buttons,omitempty"`
+}
+
+// NewResponse summarizes m.
+func NewResponse(m *ExtendedMessage) Response {
+	r := Response{Text: m.Text, Buttons: m.Buttons}
+	for _, a := range m.AllImages() {
+		r.Images = append(r.Images, imageSummary(a))
+	}
+	return r
+}
+
+// imageSummary is the type and hash of an image, or its URL.
+func imageSummary(a *Attachment) string {
+	if len(a.Data) == 0 {
+		return a.URL
+	}
+	sum := sha256.Sum256(a.Data)
+	return a.MimeType + " sha256:" + hex.EncodeToString(sum[:8])
+}
+
+func (r Response) String() string {
+	out := []string{}
+	if r.Text != "" {
+		out = append(out, r.Text)
+	}
+	for _, v := range r.Images {
+		out = append(out, "[image "+v+"]")
+	}
+	for _, v := range r.Buttons {
+		out = append(out, "["+v.Text+"]")
+	}
+	return strings.Join(out, "\n")
+}
+
+type recorder struct {
+	lock sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+}
+
+var activeRecorder *recorder
+var activeRecorderLock sync.RWMutex
+
+// StartRecording appends inbound messages and responses on every platform
+// to filename until StopRecording.
+func StartRecording(filename string) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	activeRecorderLock.Lock()
+	defer activeRecorderLock.Unlock()
+	if activeRecorder != nil {
+		activeRecorder.f.Close()
+	}
+	activeRecorder = &recorder{f: f, enc: json.NewEncoder(f)}
+	return nil
+}
+
+// StopRecording closes the recording, if any.
+func StopRecording() {
+	activeRecorderLock.Lock()
+	defer activeRecorderLock.Unlock()
+	if activeRecorder != nil {
+		activeRecorder.f.Close()
+		activeRecorder = nil
+	}
+}
+
+func currentRecorder() *recorder {
+	activeRecorderLock.RLock()
+	defer activeRecorderLock.RUnlock()
+	return activeRecorder
+}
+
+// record wraps reply to collect the responses to r, and returns a func
+// that writes them out once dispatch is done.
+func (rec *recorder) record(r Request, a *Attachment, reply Replier) (Replier, func()) {
+	recording := Recording{Time: Now(), Request: r, Responses: []Response{}}
+	if a != nil {
+		recording.Attachment = &Recorded{a.Filename, a.MimeType, a.Data}
+	}
+	var lock sync.Mutex
+	wrapped := func(m *ExtendedMessage) {
+		lock.Lock()
+		recording.Responses = append(recording.Responses, NewResponse(m))
+		lock.Unlock()
+		reply(m)
+	}
+	return wrapped, func() {
+		rec.lock.Lock()
+		defer rec.lock.Unlock()
+		lock.Lock()
+		defer lock.Unlock()
+		rec.enc.Encode(recording)
+	}
+}
+
+// ReadRecordings reads a JSONL recording.
+func ReadRecordings(r io.Reader) ([]Recording, error) {
+	out := []Recording{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*DefaultMaxAttachmentSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		recording := Recording{}
+		err := json.Unmarshal(scanner.Bytes(), &recording)
+		if err != nil {
+			return out, fmt.Errorf("line %d: %w", line, err)
+		}
+		out = append(out, recording)
+	}
+	return out, scanner.Err()
+}
+
+// replayClock stops at the time a message was recorded, so handlers that
+// depend on the date answer as they would have then.
+type replayClock struct {
+	now time.Time
+}
+
+func (c replayClock) Now() time.Time      { return c.now }
+func (c replayClock) Sleep(time.Duration) {}
+
+// Replay runs the recorded message through the current handlers, with the
+// clock at the time it was recorded, and returns the responses.
+func (r Recording) Replay() []Response {
+	if !r.Time.IsZero() {
+		defer SetClock(SetClock(replayClock{r.Time}))
+	}
+	out := []Response{}
+	reply := func(m *ExtendedMessage) {
+		out = append(out, NewResponse(m))
+	}
+	if r.Attachment != nil {
+		a := NewAttachment(r.Attachment.Filename, r.Attachment.Data, r.Attachment.MimeType)
+		dispatchAttachment(a, r.Request, reply)
+	} else {
+		dispatch(r.Request, reply)
+	}
+	return out
+}
+
+// DiffResponses describes how got differs from want, with "-" for what
+// was said before and "+" for what is said now. It is empty if they are
+// the same.
+func DiffResponses(want, got []Response) string {
+	if responsesEqual(want, got) {
+		return ""
+	}
+	b := &strings.Builder{}
+	for _, v := range want {
+		for _, line := range strings.Split(v.String(), "\n") {
+			fmt.Fprintln(b, "-", line)
+		}
+	}
+	for _, v := range got {
+		for _, line := range strings.Split(v.String(), "\n") {
+			fmt.Fprintln(b, "+", line)
+		}
+	}
+	return b.String()
+}
+
+func responsesEqual(a, b []Response) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].String() != b[i].String() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package bothandler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	oldInput, oldAttachmentHandlers := MsgInputHandlers, AttachmentHandlers
+	defer func() { MsgInputHandlers, AttachmentHandlers = oldInput, oldAttachmentHandlers }()
+	MsgInputHandlers = map[string]MessageWithInputHandler{"!hi": func(r Request) string {
+		return "hi " + r.Content
+	}}
+	AttachmentHandlers = nil
+	RegisterImageResponseHandler(nil, func(a *Attachment, r Request) *ExtendedMessage {
+		return &ExtendedMessage{Image: a.Data}
+	})
+
+	filename := filepath.Join(t.TempDir(), "traffic.jsonl")
+	err := StartRecording(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies := 0
+	reply := func(*ExtendedMessage) { replies++ }
+	Dispatch(Request{Content: "!hi alice", Platform: "discord", Channel: "general", From: "alice"}, reply)
+	Dispatch(Request{Content: "nothing", Platform: "discord", Channel: "general", From: "bob"}, reply)
+	DispatchAttachment(NewAttachment("cat.png", testPNG, ""), Request{Content: "cat", Platform: "slack"}, reply)
+	StopRecording()
+	if replies != 2 {
+		t.Errorf("recording changed the replies, got %d", replies)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recordings, err := ReadRecordings(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recordings) != 3 || recordings[0].Request.From != "alice" || recordings[2].Attachment.Filename != "cat.png" {
+		t.Fatalf("got %+v", recordings)
+	}
+	for _, v := range recordings {
+		if diff := DiffResponses(v.Responses, v.Replay()); diff != "" {
+			t.Errorf("%s changed without a change in handlers:\n%s", v.Request.Content, diff)
+		}
+	}
+
+	MsgInputHandlers["!hi"] = func(r Request) string { return "hello " + r.Content }
+	want := "- hi alice\n+ hello alice\n"
+	if diff := DiffResponses(recordings[0].Responses, recordings[0].Replay()); diff != want {
+		t.Errorf("got diff %q want %q", diff, want)
+	}
+
+	MsgInputHandlers["!hi"] = func(r Request) string { return Now().Format(time.DateOnly) }
+	recordings[0].Time = time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	got := recordings[0].Replay()
+	if len(got) != 1 || got[0].Text != "2024-05-02" {
+		t.Errorf("replayed at %+v, want the recorded time", got)
+	}
+	if Now().Year() == 2024 {
+		t.Error("the clock wasn't put back after replaying")
+	}
+}
@@ -21,6 +21,7 @@ type SlackMessagePlatform struct {
 	Me               *slack.AuthTestResponse
 	DefaultChannel   string
 	verbose          bool
+	userNames        map[string]string
 }
 
 func NewMessagePlatformFromSlack(slackbottoken, slackapptoken string) (*SlackMessagePlatform, error) {
@@ -124,83 +125,28 @@ func (s *SlackMessagePlatform) ProcessMessages() {
 						}
 						// log.Println("xxx", ev.Text)
 
-						content := ev.Text
-
-						h, ok := Handlers[content]
-						if ok {
-							response := h()
-							_, _, err := s.Client.PostMessage(ev.Channel, slack.MsgOptionText(response, false))
+						content, entities := NormalizeSlack(ev.Text, s.userName)
+						request := Request{Content: content, Platform: "slack", Channel: ev.Channel, From: ev.User, UserId: ev.User, Entities: entities}
+						reply := func(text string) {
+							text = Paginate(request, text, slackMaxMessage, slackSize)
+							_, _, err := s.Client.PostMessage(ev.Channel, slack.MsgOptionText(RenderSlack(text), false))
 							if err != nil {
 								log.Println(err)
 							}
 						}
 
-						// Can be better to decouple 1 to 1 of message : response
-						for _, v := range CatchallHandlers {
-							// FIXME
-							r := v(Request{content, "slack", "", ""})
-							if r != "" {
-								_, _, err := s.Client.PostMessage(ev.Channel, slack.MsgOptionText(r, false))
-								if err != nil {
-									log.Println(err)
-								}
+						Dispatch(request, func(r *ExtendedMessage) {
+							if !r.HasImages() {
+								reply(r.Text)
+								return
 							}
-						}
-						// Can be better to decouple 1 to 1 of message : response
-						for _, v := range CatchallExtendedHandlers {
-							r := v(ExtendedMessage{Text: content})
-							if r != nil {
-								if r.Image == nil {
-									if r.Text != "" {
-										_, _, err := s.Client.PostMessage(ev.Channel, slack.MsgOptionText(r.Text, false))
-										if err != nil {
-											log.Println(err)
-										}
-									}
-								} else {
-									// r.Image is not nil
-									// Hack
-									words := strings.Split(ev.Text, " ")
-									if len(words) > 1 {
-										words = words[1:]
-									}
-
-									// FIXME: better sanitization
-									filename := strings.ReplaceAll(strings.ToLower(strings.Join(words, " ")), " ", "_") + ".png"
-
-									fileuploadparams := slack.FileUploadParameters{
-										Reader:          bytes.NewBuffer(r.Image),
-										Filename:        filename,
-										Title:           r.Text,
-										Channels:        []string{ev.Channel},
-										Filetype:        "image/png",
-										ThreadTimestamp: ev.ThreadTimeStamp,
-									}
-									file, err := s.Client.UploadFile(fileuploadparams)
-									if err != nil {
-										log.Println(err)
-									} else {
-										log.Printf("%+v\n", file)
-									}
-								}
+							// Hack
+							words := strings.Split(ev.Text, " ")
+							if len(words) > 1 {
+								words = words[1:]
 							}
-						}
-						sliced_content := strings.SplitN(content, " ", 2)
-						if len(sliced_content) > 1 {
-							command := sliced_content[0]
-							actual_content := sliced_content[1]
-
-							ih, ok := MsgInputHandlers[command]
-							if ok {
-								response := ih(Request{actual_content, "slack", "", ""})
-								if response != "" {
-									_, _, err := s.Client.PostMessage(ev.Channel, slack.MsgOptionText(response, false))
-									if err != nil {
-										log.Println(err)
-									}
-								}
-							}
-						}
+							s.uploadImages(ev.Channel, ev.ThreadTimeStamp, r.Text, r.AllImages(), strings.ToLower(strings.Join(words, " ")))
+						})
 
 					default:
 						log.Printf("Inner event %+v %T\n", ev, ev)
@@ -310,8 +256,118 @@ func (s *SlackMessagePlatform) ChannelMessageSend(channel, message string) error
 	log.Println("sending", message, "to", channelId)
 	// m := sSock.NewOutgoingMessage(message, channelId)
 	// s.Rtm.SendMessage(m)
-	msg := slack.MsgOptionText(message, true)
-	_, _, err := s.Client.PostMessage(channelId, msg)
+	// Nobody to say !more, send it all
+	for _, page := range SplitMessage(message, slackMaxMessage, slackSize) {
+		msg := slack.MsgOptionText(RenderSlack(page), false)
+		_, _, err := s.Client.PostMessage(channelId, msg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadImages uploads images to channel, titled with text. base names
+// images without a filename.
+func (s *SlackMessagePlatform) uploadImages(channel, thread, text string, images []*Attachment, base string) {
+	for _, a := range images {
+		if len(a.Data) == 0 {
+			_, _, err := s.Client.PostMessage(channel, slack.MsgOptionText(a.Fallback(base), false))
+			if err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		fileuploadparams := slack.FileUploadParameters{
+			Reader:          bytes.NewReader(a.Data),
+			Filename:        a.Name(base),
+			Title:           RenderPlain(text),
+			Channels:        []string{channel},
+			Filetype:        a.MimeType,
+			ThreadTimestamp: thread,
+		}
+		file, err := s.Client.UploadFile(fileuploadparams)
+		if err != nil {
+			log.Println(err)
+		} else {
+			log.Printf("%+v\n", file)
+		}
+	}
+}
 
-	return err
+// userName looks up a user's display name for mentions, caching it.
+func (s *SlackMessagePlatform) userName(id string) string {
+	if name, ok := s.userNames[id]; ok {
+		return name
+	}
+	name := ""
+	user, err := s.Client.GetUserInfo(id)
+	if err != nil {
+		log.Println(err)
+	} else {
+		name = user.Profile.DisplayName
+		if name == "" {
+			name = user.Name
+		}
+	}
+	if s.userNames == nil {
+		s.userNames = map[string]string{}
+	}
+	s.userNames[id] = name
+	return name
+}
+
+// resolveChannel finds the channel ID for a channel registry alias, a
+// channel name, or an ID.
+func (s *SlackMessagePlatform) resolveChannel(channel string) string {
+	if channel == "" {
+		channel = s.DefaultChannel
+	}
+	if id, ok := Channels.Resolve("slack", channel); ok {
+		return id
+	}
+	if id, ok := s.ChannelId[strings.TrimPrefix(channel, "#")]; ok {
+		return id
+	}
+	return channel
+}
+
+// SendMessage sends to a channel or thread, or a user ID for a direct
+// message. Slack has no silent messages.
+func (s *SlackMessagePlatform) SendMessage(m OutboundMessage) error {
+	channelId := s.resolveChannel(m.Channel)
+	if m.User != "" {
+		dm, _, _, err := s.Client.OpenConversation(&slack.OpenConversationParameters{Users: []string{m.User}})
+		if err != nil {
+			return err
+		}
+		channelId = dm.ID
+	}
+	if channelId == "" {
+		return fmt.Errorf("no channel specified")
+	}
+
+	for _, page := range outboundPages(m.Text, slackMaxMessage, slackSize) {
+		options := []slack.MsgOption{slack.MsgOptionText(RenderSlack(page), false)}
+		if m.Thread != "" {
+			options = append(options, slack.MsgOptionTS(m.Thread))
+		}
+		_, _, err := s.Client.PostMessage(channelId, options...)
+		if err != nil {
+			return err
+		}
+	}
+	for _, a := range m.Files {
+		_, err := s.Client.UploadFile(slack.FileUploadParameters{
+			Reader:          bytes.NewReader(a.Data),
+			Filename:        a.Name("file"),
+			Channels:        []string{channelId},
+			Filetype:        a.MimeType,
+			ThreadTimestamp: m.Thread,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -3,6 +3,8 @@ package bothandler
 import (
 	"fmt"
 	"log"
+	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/flytam/filenamify"
@@ -17,12 +19,28 @@ type Request struct {
 	// ClientId string
 	Channel string
 	From    string
+	// UserId is From's ID, on platforms where usernames can change, so it
+	// can't be taken over by someone else.
+	UserId string
+	// Unverified is set when the sender chose Platform and From, e.g. over
+	// the HTTP bot, so they can't be trusted for admin commands.
+	Unverified bool
+	// Entities are the mentions, links, code and emoji in the message, whose
+	// Content the platform normalized to plain text.
+	Entities []Entity
 }
 
 type MessageHandler func() string
 type MessageWithInputHandler func(Request) string
 type CatchallHandler func(Request) string
-type ImageHandler func(string, Request) string
+
+// ImageHandler handles an attachment, with its caption in Request.Content.
+// Despite the name, it gets any type of attachment it was registered for.
+type ImageHandler func(*Attachment, Request) string
+
+// ImageResponseHandler handles an attachment like ImageHandler, but can
+// respond with images, e.g. the uploaded image transformed.
+type ImageResponseHandler func(*Attachment, Request) *ExtendedMessage
 
 type SendOptions struct {
 	Silent bool
@@ -31,9 +49,61 @@ type SendOptions struct {
 type ExtendedMessage struct {
 	Text  string
 	Image []byte
+	// Images are sent after Image, for responses with more than one, or
+	// that have a filename or a URL. Platforms that can't upload show the
+	// filename or URL instead.
+	Images []*Attachment
+	// Platform and Entities are as in Request, for inbound messages.
+	Platform string
+	Entities []Entity
+	// Buttons are rendered on platforms that support them (Telegram inline
+	// keyboards), and ignored elsewhere.
+	Buttons []Button
 }
 type CatchallExtendedHandler func(ExtendedMessage) *ExtendedMessage
 
+// AllImages returns Image and Images as attachments.
+func (m *ExtendedMessage) AllImages() []*Attachment {
+	if m.Image == nil {
+		return m.Images
+	}
+	return append([]*Attachment{NewAttachment("", m.Image, "image/png")}, m.Images...)
+}
+
+// HasImages is whether there are any images to send.
+func (m *ExtendedMessage) HasImages() bool {
+	return m.Image != nil || len(m.Images) > 0
+}
+
+// buttonPlatforms render ExtendedMessage.Buttons.
+var buttonPlatforms = map[string]bool{"telegram": true}
+
+// HasButtons is whether responses on the platform can have buttons, e.g.
+// to page through results instead of saying "!more".
+func HasButtons(platform string) bool {
+	return buttonPlatforms[strings.ToLower(platform)]
+}
+
+// Button is an interactive button attached to a response. When clicked,
+// Data is passed back to the CallbackHandler registered for Plugin.
+type Button struct {
+	Text   string
+	Plugin string
+	Data   string
+}
+
+// CallbackHandler handles a button click. Request.Content is Button.Data.
+// A text-only response replaces the message the button was on, a response
+// with an image is sent as a new message.
+type CallbackHandler func(Request) *ExtendedMessage
+
+// Command describes a bot command for platforms that can show a command
+// menu, e.g. Telegram's setMyCommands.
+type Command struct {
+	Command     string
+	Description string
+}
+
 type MessagePlatform interface {
 	Send(string)
 	SendWithOptions(string, SendOptions)
@@ -48,7 +118,13 @@ var Handlers = map[string]MessageHandler{}
 var MsgInputHandlers = map[string]MessageWithInputHandler{}
 var CatchallHandlers = []CatchallHandler{}
 var CatchallExtendedHandlers = []CatchallExtendedHandler{}
-var ImageHandlers = []ImageHandler{}
+var AttachmentHandlers = []AttachmentHandler{}
+var CallbackHandlers = map[string]CallbackHandler{}
+var CommandDescriptions = map[string]string{}
+var HTTPHandlers = map[string]http.Handler{}
+
+// Admins are the users allowed to run admin commands, added with AddAdmin.
+var Admins = map[string]bool{}
 var AddMessagePlatforms = []AddMessagePlatform{}
 var ActiveMessagePlatforms = []MessagePlatform{}
 
@@ -67,8 +143,95 @@ func RegisterCatchallExtendeHandler(h CatchallExtendedHandler) {
 	CatchallExtendedHandlers = append(CatchallExtendedHandlers, h)
 }
 
+// RegisterImageHandler registers h for image attachments.
 func RegisterImageHandler(h ImageHandler) {
-	ImageHandlers = append(ImageHandlers, h)
+	RegisterAttachmentHandler([]string{"image/*"}, h)
+}
+
+// RegisterAttachmentHandler registers h for attachments of the given MIME
+// types, e.g. "application/pdf" or "image/*".
+func RegisterAttachmentHandler(accept []string, h ImageHandler) {
+	RegisterImageResponseHandler(accept, func(a *Attachment, r Request) *ExtendedMessage {
+		return &ExtendedMessage{Text: h(a, r)}
+	})
+}
+
+// RegisterImageResponseHandler registers h for attachments of the given MIME
+// types, like RegisterAttachmentHandler.
+func RegisterImageResponseHandler(accept []string, h ImageResponseHandler) {
+	AttachmentHandlers = append(AttachmentHandlers, AttachmentHandler{accept, h})
+}
+
+func RegisterCallbackHandler(plugin string, h CallbackHandler) {
+	CallbackHandlers[plugin] = h
+}
+
+// RegisterHTTPHandler serves h for pattern, e.g. "POST /webhooks/git", on
+// the HTTP bot's and the API's listeners. The listeners' tokens aren't
+// checked, h authenticates requests itself, e.g. with webhook signatures.
+func RegisterHTTPHandler(pattern string, h http.Handler) {
+	HTTPHandlers[pattern] = h
+}
+
+func mountHTTPHandlers(mux *http.ServeMux) {
+	for pattern, h := range HTTPHandlers {
+		mux.Handle(pattern, h)
+	}
+}
+
+// RegisterCommand describes a command for command menus. Commands handled
+// by MsgInputHandlers are listed even without a description, catchall
+// handlers that look for a prefix such as "!dict" need to register it here.
+func RegisterCommand(command string, description string) {
+	CommandDescriptions[command] = description
+}
+
+// ListCommands returns all known commands, sorted.
+func ListCommands() []Command {
+	all := map[string]string{}
+	for k := range MsgInputHandlers {
+		all[k] = k
+	}
+	for k, v := range CommandDescriptions {
+		all[k] = v
+	}
+	out := make([]Command, 0, len(all))
+	for k, v := range all {
+		out = append(out, Command{k, v})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Command < out[j].Command })
+	return out
+}
+
+// AddAdmin allows a user to run admin commands. The user is
+// platform:username, which matches whoever has the username now, or
+// platform:id:userid, which matches Request.UserId and survives renames.
+func AddAdmin(user string) {
+	platform, username, ok := strings.Cut(strings.TrimSpace(user), ":")
+	if !ok || username == "" {
+		return
+	}
+	if id, ok := strings.CutPrefix(username, "id:"); ok {
+		Admins[strings.ToLower(platform)+":id:"+id] = true
+		return
+	}
+	Admins[strings.ToLower(platform)+":"+strings.ToLower(username)] = true
+}
+
+// IsAdmin is whether the request is from an admin, by user ID, or by
+// username regardless of case.
+func IsAdmin(request Request) bool {
+	if request.Unverified {
+		return false
+	}
+	platform := strings.ToLower(request.Platform)
+	if request.UserId != "" && Admins[platform+":id:"+request.UserId] {
+		return true
+	}
+	if request.From == "" {
+		return false
+	}
+	return Admins[platform+":"+strings.ToLower(request.From)]
 }
 
 func RegisterMessagePlatform(m MessagePlatform) {
@@ -80,6 +243,7 @@ func RegisterPassiveMessagePlatform(m MessagePlatform) {
 }
 
 func Shutdown() {
+	StopPlugins()
 	for _, v := range ActiveMessagePlatforms {
 		v.Close()
 	}
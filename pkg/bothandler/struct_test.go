@@ -23,3 +23,31 @@ func Test_sanitizeFilename(t *testing.T) {
 		})
 	}
 }
+
+func TestIsAdmin(t *testing.T) {
+	oldAdmins := Admins
+	Admins = map[string]bool{}
+	defer func() { Admins = oldAdmins }()
+	AddAdmin("Telegram:Alice")
+	AddAdmin("discord:id:1234")
+
+	tests := []struct {
+		name    string
+		request Request
+		want    bool
+	}{
+		{"username", Request{Platform: "telegram", From: "alice"}, true},
+		{"case", Request{Platform: "telegram", From: "ALICE"}, true},
+		{"otherplatform", Request{Platform: "discord", From: "alice"}, false},
+		{"id", Request{Platform: "discord", From: "renamed", UserId: "1234"}, true},
+		{"takenover", Request{Platform: "discord", From: "1234", UserId: "5678"}, false},
+		{"nobody", Request{Platform: "telegram"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsAdmin(tt.request); got != tt.want {
+				t.Errorf("IsAdmin(%+v) = %v, want %v", tt.request, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,12 +1,10 @@
 package bothandler
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -21,13 +19,32 @@ type TelegramMessagePlatform struct {
 	KnownUsers     map[string]tgbotapi.User
 	KnownUsersLock sync.RWMutex
 	DefaultChannel string
+	// Webhook switches ProcessMessages from long polling to a local HTTP
+	// listener, if set.
+	Webhook       *TelegramWebhookConfig
+	webhookServer *http.Server
+	webhookClosed bool
+	webhookLock   sync.Mutex
+	// webhookUpdates are the updates from the webhook being handled.
+	webhookUpdates sync.WaitGroup
+	// InlineCacheChat is where images are uploaded to get a file_id for
+	// inline query results. Image results are skipped if unset.
+	InlineCacheChat int64
+	// MaxImageSize skips images bigger than this, capped at Telegram's
+	// 20MB download limit.
+	MaxImageSize int
+
+	callbacks      map[string]string
+	callbacksOrder []string
+	callbacksSeq   uint64
+	callbacksLock  sync.Mutex
 	// Me             *tgbotapi.User // Superflous, get it from Client.Self
 }
 
 func NewMessagePlatformFromTelegram(telegrambottoken string) (*TelegramMessagePlatform, error) {
 	bot, err := tgbotapi.NewBotAPI(telegrambottoken)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 	log.Printf("Connected to Telegram on account %s", bot.Self.UserName)
 
@@ -39,6 +56,19 @@ func NewMessagePlatformFromTelegram(telegrambottoken string) (*TelegramMessagePl
 }
 
 func (s *TelegramMessagePlatform) ProcessMessages() {
+	s.publishCommands()
+
+	if s.Webhook != nil {
+		s.processWebhook()
+		return
+	}
+
+	// getUpdates fails while a webhook is set, e.g. from a previous run in
+	// webhook mode.
+	_, err := s.Client.RemoveWebhook()
+	if err != nil {
+		log.Fatal("deleteWebhook ", err)
+	}
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 	updates, err := s.Client.GetUpdatesChan(u)
@@ -46,183 +76,223 @@ func (s *TelegramMessagePlatform) ProcessMessages() {
 		log.Fatal(err)
 	}
 	for update := range updates {
-		if update.Message == nil { // ignore any non-Message Updates
-			continue
-		}
+		s.handleUpdate(update)
+	}
+}
 
-		// log.Printf("[%s] %s %d %d", update.Message.From.UserName, update.Message.Text, update.Message.From.ID, update.Message.Chat.ID)
-		s.KnownUsersLock.Lock()
-		s.KnownUsers[update.Message.From.UserName] = *update.Message.From
-		s.KnownUsersLock.Unlock()
+// handleUpdate dispatches a single update, whether it came from long polling
+// or from the webhook listener.
+func (s *TelegramMessagePlatform) handleUpdate(update tgbotapi.Update) {
+	switch {
+	case update.Message != nil:
+		s.handleMessage(update.Message)
+	case update.EditedMessage != nil:
+		s.handleMessage(update.EditedMessage)
+	case update.ChannelPost != nil:
+		s.handleMessage(update.ChannelPost)
+	case update.EditedChannelPost != nil:
+		s.handleMessage(update.EditedChannelPost)
+	case update.InlineQuery != nil:
+		s.handleInlineQuery(update.InlineQuery)
+	case update.CallbackQuery != nil:
+		s.handleCallbackQuery(update.CallbackQuery)
+	}
+}
 
-		content := update.Message.Text
+// telegramUser is the username and user ID of from, which is nil for
+// channel posts.
+func telegramUser(from *tgbotapi.User) (string, string) {
+	if from == nil {
+		return "", ""
+	}
+	return from.UserName, strconv.Itoa(from.ID)
+}
 
-		h, ok := Handlers[content]
-		if ok {
-			response := h()
+func (s *TelegramMessagePlatform) handleMessage(m *tgbotapi.Message) {
+	// Channel posts have no sender
+	username, userId := telegramUser(m.From)
+	if m.From != nil {
+		s.KnownUsersLock.Lock()
+		s.KnownUsers[m.From.UserName] = *m.From
+		s.KnownUsersLock.Unlock()
+	}
+	channel := strconv.FormatInt(m.Chat.ID, 10)
+	s.seeChat(m)
 
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, response)
-			msg.ReplyToMessageID = update.Message.MessageID
-			_, err := s.Client.Send(msg)
-			if err != nil {
-				log.Println(err)
-			}
-		}
+	content, entities := NormalizeText(s.normalizeCommand(m.Text))
+	request := Request{Content: content, Platform: "telegram", Channel: channel, From: username, UserId: userId, Entities: entities}
 
-		// Can be better to decouple 1 to 1 of message : response
-		for _, v := range CatchallHandlers {
-			// FIXME
-			r := v(Request{content, "telegram", "", ""})
-			if r != "" {
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, r)
-				msg.ReplyToMessageID = update.Message.MessageID
-				_, err := s.Client.Send(msg)
-				if err != nil {
-					log.Println(err)
-				}
-			}
+	Dispatch(request, func(r *ExtendedMessage) {
+		if r.HasImages() {
+			s.sendImages(m.Chat.ID, m.MessageID, r, content)
+			return
 		}
+		s.reply(m, request, r.Text, r.Buttons)
+	})
 
-		// Can be better to decouple 1 to 1 of message : response
-		for _, v := range CatchallExtendedHandlers {
-			r := v(ExtendedMessage{Text: content})
-			if r != nil {
-				if r.Text != "" && r.Image == nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, r.Text)
-					msg.ReplyToMessageID = update.Message.MessageID
-					_, err := s.Client.Send(msg)
-					if err != nil {
-						log.Println(err)
-					}
-				}
-				if r.Image != nil {
-					photoFileBytes := tgbotapi.FileBytes{
-						Name:  sanitizeFilename(content, ".png"),
-						Bytes: r.Image,
-					}
-					msg := tgbotapi.NewPhotoUpload(update.Message.Chat.ID, photoFileBytes)
-					msg.ReplyToMessageID = update.Message.MessageID
-					msg.Caption = r.Text
-					_, err := s.Client.Send(msg)
-					if err != nil {
-						log.Println("NewPhotoUpload", err)
-					}
-				}
-			}
-		}
+	file := s.attachmentFile(m)
+	if file != nil {
+		s.handleAttachment(m, file, channel, username, userId)
+	}
+}
 
-		sliced_content := strings.SplitN(content, " ", 2)
-		if len(sliced_content) > 1 {
-			command := sliced_content[0]
-			actual_content := sliced_content[1]
-
-			ih, ok := MsgInputHandlers[command]
-			if ok {
-				response := ih(Request{actual_content, "telegram", "", ""})
-				if response != "" {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, response)
-					msg.ReplyToMessageID = update.Message.MessageID
-					_, err := s.Client.Send(msg)
-					if err != nil {
-						log.Println(err)
-					}
-				}
-			}
-		}
+// Telegram bots can only download files up to 20MB with getFile.
+const telegramMaxDownloadSize = 20 << 20
 
-		m := update.Message
-
-		if m.Photo != nil {
-			// log.Printf("photo %+v\n", m)
-			// FIXME: Actual exec path never goes through here.
-			// targetPixels := 512 * 512
-			targetPixels := 1024 * 1024
-			best := &tgbotapi.PhotoSize{}
-			bestSize := 100_000_000
-
-			for _, v := range *m.Photo {
-				pixels := v.Height * v.Width
-				diff := targetPixels - pixels
-				if diff < 0 {
-					diff = -diff
-				}
-				if diff < bestSize {
-					bestSize = diff
-					best = &v
-				}
-			}
+type telegramFile struct {
+	FileID   string
+	FileSize int
+	FileName string
+	MimeType string
+	Sticker  bool
+}
 
-			// Nah, pick the biggest
-			biggestSoFar := 0
-			for k, v := range *m.Photo {
-				if v.FileSize > biggestSoFar {
-					biggestSoFar = v.FileSize
-					best = &v
-				}
-				_ = k
-				// log.Printf("photo %v %+v\n", k, v)
-			}
+func (s *TelegramMessagePlatform) maxImageSize() int {
+	if s.MaxImageSize > 0 && s.MaxImageSize < telegramMaxDownloadSize {
+		return s.MaxImageSize
+	}
+	return telegramMaxDownloadSize
+}
 
-			debug, _ := json.Marshal(update)
-			log.Printf("message %+v\n", string(debug))
-			// FIXME:
-			filename := "tmp/" + best.FileID
-			err := s.botDownload(best.FileID, filename)
-			if err != nil {
-				log.Println(err)
-			}
-			for _, v := range ImageHandlers {
-				// log.Printf("image handlers %+v\n", m)
-				c := content
-				if c == "" {
-					c = m.Caption
-				}
-				r := v(filename, Request{c, "telegram", "", ""})
-				if r != "" {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, r)
-					msg.ReplyToMessageID = update.Message.MessageID
-					_, err := s.Client.Send(msg)
-					if err != nil {
-						log.Println(err)
-					}
-				}
+// attachmentFile picks the file in m to hand to the attachment handlers:
+// the biggest photo size we are allowed to download, a document, or a
+// sticker. Returns nil if there is none, nobody wants it, or it is too big.
+func (s *TelegramMessagePlatform) attachmentFile(m *tgbotapi.Message) *telegramFile {
+	limit := s.maxImageSize()
+	switch {
+	case m.Photo != nil:
+		if !WantsAttachment("image/jpeg") {
+			return nil
+		}
+		var best *tgbotapi.PhotoSize
+		for k, v := range *m.Photo {
+			if v.FileSize > limit {
+				continue
 			}
-
-			if false {
-				os.Remove(filename)
+			if best == nil || v.Width*v.Height > best.Width*best.Height {
+				best = &(*m.Photo)[k]
 			}
 		}
+		if best == nil {
+			log.Println("Photo too big, skipping")
+			return nil
+		}
+		return &telegramFile{FileID: best.FileID, FileSize: best.FileSize, MimeType: "image/jpeg"}
+	case m.Document != nil:
+		if !WantsAttachment(m.Document.MimeType) {
+			return nil
+		}
+		if m.Document.FileSize > limit {
+			log.Println("Document too big, skipping", m.Document.FileName, m.Document.FileSize)
+			return nil
+		}
+		return &telegramFile{FileID: m.Document.FileID, FileSize: m.Document.FileSize, FileName: m.Document.FileName, MimeType: m.Document.MimeType}
+	case m.Sticker != nil:
+		if !WantsAttachment("image/webp") || m.Sticker.FileSize > limit {
+			return nil
+		}
+		return &telegramFile{FileID: m.Sticker.FileID, FileSize: m.Sticker.FileSize, MimeType: "image/webp", Sticker: true}
 	}
+	return nil
 }
 
-func (s *TelegramMessagePlatform) botDownload(fileId string, localFilename string) error {
-	bot := s.Client
-	downloadUrl, err := bot.GetFileDirectURL(fileId)
+func (s *TelegramMessagePlatform) handleAttachment(m *tgbotapi.Message, file *telegramFile, channel, username, userId string) {
+	limit := s.maxImageSize()
+
+	f, err := s.Client.GetFile(tgbotapi.FileConfig{FileID: file.FileID})
 	if err != nil {
 		log.Println(err)
-		return err
+		return
+	}
+	// Animated (.tgs) and video (.webm) stickers aren't images
+	if file.Sticker && !strings.HasSuffix(f.FilePath, ".webp") {
+		return
+	}
+	if f.FileSize > limit {
+		log.Println("File too big, skipping", f.FilePath, f.FileSize)
+		return
 	}
-	log.Println("Downloading", downloadUrl)
 
-	get, err := http.Get(downloadUrl)
-	if err != nil || get == nil || get.Body == nil {
+	a, err := DownloadAttachment(s.Client.Client, f.Link(s.Client.Token), file.FileName, file.MimeType, limit)
+	if err != nil {
 		log.Println(err)
-		return err
+		return
 	}
-	reader := get.Body
-	defer reader.Close()
 
-	out, err := os.Create(localFilename)
-	if err != nil {
-		return err
+	content, entities := NormalizeText(s.normalizeCommand(m.Caption))
+	request := Request{Content: content, Platform: "telegram", Channel: channel, From: username, UserId: userId, Entities: entities}
+	DispatchAttachment(a, request, func(r *ExtendedMessage) {
+		if r.HasImages() {
+			s.sendImages(m.Chat.ID, m.MessageID, r, a.Name("image"))
+			return
+		}
+		s.reply(m, request, r.Text, r.Buttons)
+	})
+}
+
+// sendImages sends the images in r as a reply to replyTo, the first with
+// r.Text as its caption and r.Buttons. Files that aren't images are sent as
+// documents, and images without data by URL. base names images without a
+// filename.
+func (s *TelegramMessagePlatform) sendImages(chatID int64, replyTo int, r *ExtendedMessage, base string) {
+	for i, a := range r.AllImages() {
+		caption := ""
+		var markup *tgbotapi.InlineKeyboardMarkup
+		if i == 0 {
+			caption = RenderHTML(r.Text)
+			markup = s.keyboard(r.Buttons)
+		}
+		file := tgbotapi.FileBytes{Name: a.Name(base), Bytes: a.Data}
+
+		var msg tgbotapi.Chattable
+		if a.IsImage() || len(a.Data) == 0 {
+			photo := tgbotapi.NewPhotoUpload(chatID, file)
+			if len(a.Data) == 0 {
+				photo = tgbotapi.NewPhotoShare(chatID, a.URL)
+			}
+			photo.ReplyToMessageID = replyTo
+			photo.Caption = caption
+			photo.ParseMode = tgbotapi.ModeHTML
+			if markup != nil {
+				photo.ReplyMarkup = markup
+			}
+			msg = photo
+		} else {
+			document := tgbotapi.NewDocumentUpload(chatID, file)
+			document.ReplyToMessageID = replyTo
+			document.Caption = caption
+			document.ParseMode = tgbotapi.ModeHTML
+			if markup != nil {
+				document.ReplyMarkup = markup
+			}
+			msg = document
+		}
+		_, err := s.Client.Send(msg)
+		if err != nil {
+			log.Println("sendImages", err)
+		}
 	}
-	defer out.Close()
+}
 
-	_, err = io.Copy(out, reader)
+// reply answers m with text, keeping what doesn't fit in one message for
+// "!more".
+func (s *TelegramMessagePlatform) reply(m *tgbotapi.Message, r Request, text string, buttons []Button) {
+	msg := telegramMessage(m.Chat.ID, Paginate(r, text, telegramMaxMessage, telegramSize))
+	msg.ReplyToMessageID = m.MessageID
+	if k := s.keyboard(buttons); k != nil {
+		msg.ReplyMarkup = k
+	}
+	_, err := s.Client.Send(msg)
 	if err != nil {
-		return err
+		log.Println(err)
 	}
-	return nil
+}
+
+// telegramMessage is a text message, with neutral markup rendered as HTML.
+func telegramMessage(chatID int64, text string) tgbotapi.MessageConfig {
+	msg := tgbotapi.NewMessage(chatID, RenderHTML(text))
+	msg.ParseMode = tgbotapi.ModeHTML
+	return msg
 }
 
 func (s *TelegramMessagePlatform) Send(text string) {
@@ -251,40 +321,138 @@ func (s *TelegramMessagePlatform) SendWithOptions(text string, options SendOptio
 }
 
 func (s *TelegramMessagePlatform) Close() {
+	if s != nil {
+		s.closeWebhook()
+	}
 }
 
-func (s *TelegramMessagePlatform) ChannelMessageSend(channel, message string) error {
+// resolveChat finds the chat id for a channel name: an alias or known chat
+// in the channel registry, one of engineersmy's hardcoded channels, or a
+// chat id.
+func (s *TelegramMessagePlatform) resolveChat(channel string) (int64, error) {
 	if channel == "" {
 		channel = s.DefaultChannel
 	}
+	id, ok := Channels.Resolve("telegram", channel)
+	if ok {
+		return strconv.ParseInt(id, 10, 64)
+	}
 	channelId, ok := engineersmy.KnownTelegramChannels[channel]
-	if !ok {
-		log.Println("Unknown channel", channel)
-		return fmt.Errorf("unknown channel %s", channel)
+	if ok {
+		return channelId, nil
 	}
-	msg := tgbotapi.NewMessage(int64(channelId), message)
-	_, err := s.Client.Send(msg)
+	if chatId, err := strconv.ParseInt(channel, 10, 64); err == nil {
+		return chatId, nil
+	}
+	log.Println("Unknown channel", channel)
+	return 0, fmt.Errorf("unknown channel %s", channel)
+}
+
+// seeChat records the chat a message came from in the channel registry.
+func (s *TelegramMessagePlatform) seeChat(m *tgbotapi.Message) {
+	if m.Chat == nil {
+		return
+	}
+	chat := KnownChat{
+		Platform: "telegram",
+		ID:       strconv.FormatInt(m.Chat.ID, 10),
+		Title:    m.Chat.Title,
+		Type:     m.Chat.Type,
+		Username: m.Chat.UserName,
+	}
+	if chat.Title == "" {
+		chat.Title = strings.TrimSpace(m.Chat.FirstName + " " + m.Chat.LastName)
+	}
+	if m.NewChatMembers != nil {
+		for _, v := range *m.NewChatMembers {
+			if v.ID == s.Client.Self.ID {
+				log.Println("Added to Telegram chat", chat.ID, chat.Title)
+			}
+		}
+	}
+	Channels.See(chat)
+}
+
+func (s *TelegramMessagePlatform) ChannelMessageSend(channel, message string) error {
+	channelId, err := s.resolveChat(channel)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
-	return err
+	// Nobody to say !more, send it all
+	for _, page := range SplitMessage(message, telegramMaxMessage, telegramSize) {
+		_, err = s.Client.Send(telegramMessage(channelId, page))
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+	return nil
 }
 
 // ChannelMessageSilentSend is FIXME: dupe of ChannelMessageSend with DisableNotification
 func (s *TelegramMessagePlatform) ChannelMessageSilentSend(channel, message string) error {
-	if channel == "" {
-		channel = s.DefaultChannel
+	channelId, err := s.resolveChat(channel)
+	if err != nil {
+		return err
 	}
-	channelId, ok := engineersmy.KnownTelegramChannels[channel]
-	if !ok {
-		log.Println("Unknown channel", channel)
-		return fmt.Errorf("unknown channel %s", channel)
+	for _, page := range SplitMessage(message, telegramMaxMessage, telegramSize) {
+		msg := telegramMessage(channelId, page)
+		msg.DisableNotification = true
+		_, err = s.Client.Send(msg)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
 	}
-	msg := tgbotapi.NewMessage(int64(channelId), message)
-	msg.DisableNotification = true
-	_, err := s.Client.Send(msg)
+	return nil
+}
+
+// SendMessage sends to a chat, or to a user's private chat with the bot,
+// which they must have started. Thread is a message ID to reply to.
+func (s *TelegramMessagePlatform) SendMessage(m OutboundMessage) error {
+	channel := m.Channel
+	if m.User != "" {
+		channel = m.User
+	}
+	chatId, err := s.resolveChat(channel)
 	if err != nil {
-		log.Println(err)
+		return err
+	}
+	replyTo := 0
+	if m.Thread != "" {
+		replyTo, err = strconv.Atoi(m.Thread)
+		if err != nil {
+			return fmt.Errorf("bad telegram message id %s", m.Thread)
+		}
 	}
-	return err
+
+	for _, page := range outboundPages(m.Text, telegramMaxMessage, telegramSize) {
+		msg := telegramMessage(chatId, page)
+		msg.ReplyToMessageID = replyTo
+		msg.DisableNotification = m.Silent
+		_, err = s.Client.Send(msg)
+		if err != nil {
+			return err
+		}
+	}
+	for _, a := range m.Files {
+		file := tgbotapi.FileBytes{Name: a.Name("file"), Bytes: a.Data}
+		var msg tgbotapi.Chattable
+		if a.IsImage() {
+			photo := tgbotapi.NewPhotoUpload(chatId, file)
+			photo.ReplyToMessageID = replyTo
+			photo.DisableNotification = m.Silent
+			msg = photo
+		} else {
+			document := tgbotapi.NewDocumentUpload(chatId, file)
+			document.ReplyToMessageID = replyTo
+			document.DisableNotification = m.Silent
+			msg = document
+		}
+		_, err = s.Client.Send(msg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,92 @@
+package bothandler
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestTelegramImageHandlers(t *testing.T) {
+	s, calls := newFakeTelegram(t)
+	s.MaxImageSize = 15
+
+	oldHandlers := AttachmentHandlers
+	AttachmentHandlers = nil
+	RegisterAttachmentHandler([]string{"image/*"}, func(a *Attachment, r Request) string {
+		filename, _ := a.Path()
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			return err.Error()
+		}
+		return r.Content + ":" + string(b)
+	})
+	defer func() { AttachmentHandlers = oldHandlers }()
+
+	chat := &tgbotapi.Chat{ID: -100}
+	tests := []struct {
+		name string
+		m    tgbotapi.Message
+		want string
+	}{
+		{"photo", tgbotapi.Message{Caption: "!addface bob", Photo: &[]tgbotapi.PhotoSize{
+			{FileID: "photo_small.jpg", Width: 90, Height: 90, FileSize: 5},
+			{FileID: "photo_big.jpg", Width: 800, Height: 800, FileSize: 13},
+		}}, "!addface bob:biggest photo"},
+		{"photo-too-big", tgbotapi.Message{Photo: &[]tgbotapi.PhotoSize{
+			{FileID: "photo_small.jpg", Width: 90, Height: 90, FileSize: 5},
+			{FileID: "photo_big.jpg", Width: 8000, Height: 8000, FileSize: 5000},
+		}}, ":small"},
+		{"document", tgbotapi.Message{Caption: "qr", Document: &tgbotapi.Document{FileID: "doc.png", MimeType: "image/png", FileSize: 8}}, "qr:document"},
+		{"document-not-image", tgbotapi.Message{Document: &tgbotapi.Document{FileID: "doc.png", MimeType: "application/pdf", FileSize: 8}}, ""},
+		{"document-too-big", tgbotapi.Message{Document: &tgbotapi.Document{FileID: "doc.png", MimeType: "image/png", FileSize: 50 << 20}}, ""},
+		{"sticker", tgbotapi.Message{Sticker: &tgbotapi.Sticker{FileID: "sticker.webp", FileSize: 7}}, ":sticker"},
+		{"sticker-animated", tgbotapi.Message{Sticker: &tgbotapi.Sticker{FileID: "animated.tgs", FileSize: 16}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.m.Chat = chat
+			s.handleMessage(&tt.m)
+			select {
+			case call := <-calls:
+				if tt.want == "" {
+					t.Fatalf("unexpected reply %+v", call)
+				}
+				if got := call.Params.Get("text"); got != tt.want {
+					t.Errorf("got %q want %q", got, tt.want)
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.want != "" {
+					t.Fatal("no reply sent")
+				}
+			}
+		})
+	}
+}
+
+func TestTelegramStickerImage(t *testing.T) {
+	s, calls := newFakeTelegram(t)
+
+	oldHandlers := AttachmentHandlers
+	AttachmentHandlers = nil
+	RegisterAttachmentHandler([]string{"image/*"}, func(a *Attachment, r Request) string {
+		img, err := a.Image()
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprint(a.MimeType, " ", img.Bounds())
+	})
+	defer func() { AttachmentHandlers = oldHandlers }()
+
+	s.handleMessage(&tgbotapi.Message{Chat: &tgbotapi.Chat{ID: -100}, Sticker: &tgbotapi.Sticker{FileID: "image.webp", FileSize: len(testWebP)}})
+	select {
+	case call := <-calls:
+		if got := call.Params.Get("text"); got != "image/webp (0,0)-(1,1)" {
+			t.Errorf("expected the sticker decoded, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply sent")
+	}
+}
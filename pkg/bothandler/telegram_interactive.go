@@ -0,0 +1,293 @@
+package bothandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Telegram limits callback_data to 64 bytes, longer payloads are kept here
+// and referenced by a short key instead.
+const telegramMaxCallbackData = 64
+const telegramMaxStoredCallbacks = 1000
+const telegramMaxInlineResults = 10
+
+var telegramCommandName = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// telegramInlineCachedPhoto is InlineQueryResultCachedPhoto, missing from
+// tgbotapi v4.
+type telegramInlineCachedPhoto struct {
+	Type        string                         `json:"type"`
+	ID          string                         `json:"id"`
+	PhotoFileID string                         `json:"photo_file_id"`
+	Caption     string                         `json:"caption,omitempty"`
+	ParseMode   string                         `json:"parse_mode,omitempty"`
+	ReplyMarkup *tgbotapi.InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+// publishCommands sets the bot's command menu from the registered handlers.
+func (s *TelegramMessagePlatform) publishCommands() {
+	type botCommand struct {
+		Command     string `json:"command"`
+		Description string `json:"description"`
+	}
+	commands := []botCommand{}
+	for _, v := range ListCommands() {
+		name := strings.TrimLeft(v.Command, "!/")
+		if !telegramCommandName.MatchString(name) {
+			continue
+		}
+		commands = append(commands, botCommand{name, telegramCut(v.Description, 256)})
+	}
+	b, err := json.Marshal(commands)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	_, err = s.Client.MakeRequest("setMyCommands", url.Values{"commands": {string(b)}})
+	if err != nil {
+		log.Println("setMyCommands", err)
+	}
+}
+
+// telegramCut cuts text to one of Telegram's character limits without
+// splitting a character, which Telegram would reject.
+func telegramCut(text string, max int) string {
+	r := []rune(text)
+	if len(r) > max {
+		return string(r[:max])
+	}
+	return text
+}
+
+// normalizeCommand turns Telegram's "/xkcd@botname 356" into "!xkcd 356",
+// if "!xkcd" is a known command.
+func (s *TelegramMessagePlatform) normalizeCommand(content string) string {
+	if !strings.HasPrefix(content, "/") {
+		return content
+	}
+	command, rest, hasRest := strings.Cut(content, " ")
+	command, botname, _ := strings.Cut(command, "@")
+	if botname != "" && !strings.EqualFold(botname, s.Client.Self.UserName) {
+		return content
+	}
+	command = "!" + command[1:]
+	if !isKnownCommand(command) {
+		return content
+	}
+	if hasRest {
+		return command + " " + rest
+	}
+	return command
+}
+
+func isKnownCommand(command string) bool {
+	if _, ok := MsgInputHandlers[command]; ok {
+		return true
+	}
+	_, ok := CommandDescriptions[command]
+	return ok
+}
+
+func (s *TelegramMessagePlatform) encodeCallback(b Button) string {
+	data := b.Plugin + "|" + b.Data
+	if len(data) <= telegramMaxCallbackData {
+		return data
+	}
+
+	s.callbacksLock.Lock()
+	defer s.callbacksLock.Unlock()
+	if s.callbacks == nil {
+		s.callbacks = map[string]string{}
+	}
+	s.callbacksSeq++
+	key := "#" + strconv.FormatUint(s.callbacksSeq, 36)
+	s.callbacks[key] = data
+	s.callbacksOrder = append(s.callbacksOrder, key)
+	if len(s.callbacksOrder) > telegramMaxStoredCallbacks {
+		delete(s.callbacks, s.callbacksOrder[0])
+		s.callbacksOrder = s.callbacksOrder[1:]
+	}
+	return key
+}
+
+func (s *TelegramMessagePlatform) decodeCallback(data string) (plugin string, payload string, ok bool) {
+	if strings.HasPrefix(data, "#") {
+		s.callbacksLock.Lock()
+		data, ok = s.callbacks[data]
+		s.callbacksLock.Unlock()
+		if !ok {
+			return "", "", false
+		}
+	}
+	return strings.Cut(data, "|")
+}
+
+func (s *TelegramMessagePlatform) keyboard(buttons []Button) *tgbotapi.InlineKeyboardMarkup {
+	if len(buttons) == 0 {
+		return nil
+	}
+	row := make([]tgbotapi.InlineKeyboardButton, 0, len(buttons))
+	for _, b := range buttons {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(b.Text, s.encodeCallback(b)))
+	}
+	k := tgbotapi.NewInlineKeyboardMarkup(row)
+	return &k
+}
+
+func (s *TelegramMessagePlatform) handleCallbackQuery(q *tgbotapi.CallbackQuery) {
+	_, err := s.Client.AnswerCallbackQuery(tgbotapi.NewCallback(q.ID, ""))
+	if err != nil {
+		log.Println(err)
+	}
+
+	plugin, payload, ok := s.decodeCallback(q.Data)
+	if !ok {
+		log.Println("Unknown callback", q.Data)
+		return
+	}
+	h, ok := CallbackHandlers[plugin]
+	if !ok {
+		log.Println("No callback handler for", plugin)
+		return
+	}
+
+	username, userId := telegramUser(q.From)
+	channel := ""
+	if q.Message != nil {
+		channel = strconv.FormatInt(q.Message.Chat.ID, 10)
+	}
+	r := h(Request{Content: payload, Platform: "telegram", Channel: channel, From: username, UserId: userId})
+	if r == nil {
+		return
+	}
+
+	if r.HasImages() {
+		if q.Message == nil {
+			// Can't post new messages from inline messages
+			return
+		}
+		s.sendImages(q.Message.Chat.ID, q.Message.MessageID, r, payload)
+		return
+	}
+
+	var edit tgbotapi.EditMessageTextConfig
+	if q.Message != nil {
+		edit = tgbotapi.NewEditMessageText(q.Message.Chat.ID, q.Message.MessageID, RenderHTML(r.Text))
+	} else {
+		edit = tgbotapi.EditMessageTextConfig{
+			BaseEdit: tgbotapi.BaseEdit{InlineMessageID: q.InlineMessageID},
+			Text:     RenderHTML(r.Text),
+		}
+	}
+	edit.ParseMode = tgbotapi.ModeHTML
+	edit.ReplyMarkup = s.keyboard(r.Buttons)
+	_, err = s.Client.Send(edit)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// handleInlineQuery answers "@botname unicode hello" by running "!unicode
+// hello" through the handlers. Only registered commands are run, so inline
+// queries don't trigger the chatty catchall handlers.
+func (s *TelegramMessagePlatform) handleInlineQuery(q *tgbotapi.InlineQuery) {
+	results := []interface{}{}
+	defer func() {
+		_, err := s.Client.AnswerInlineQuery(tgbotapi.InlineConfig{
+			InlineQueryID: q.ID,
+			Results:       results,
+			CacheTime:     0,
+			IsPersonal:    true,
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	}()
+
+	query := strings.TrimSpace(q.Query)
+	if query == "" {
+		return
+	}
+	content, entities := NormalizeText("!" + strings.TrimLeft(query, "!/"))
+	command, rest, _ := strings.Cut(content, " ")
+	if !isKnownCommand(command) {
+		return
+	}
+	username, userId := telegramUser(q.From)
+
+	addText := func(text string, buttons []Button) {
+		if text == "" || len(results) >= telegramMaxInlineResults {
+			return
+		}
+		// Nobody to say !more to
+		text = SplitMessage(text, telegramMaxMessage, telegramSize)[0]
+		plain := RenderPlain(text)
+		title := telegramCut(plain, 64)
+		article := tgbotapi.NewInlineQueryResultArticleHTML(strconv.Itoa(len(results)), title, RenderHTML(text))
+		article.Description = plain
+		article.ReplyMarkup = s.keyboard(buttons)
+		results = append(results, article)
+	}
+
+	if ih, ok := MsgInputHandlers[command]; ok && rest != "" {
+		addText(ih(Request{Content: rest, Platform: "telegram", Channel: "inline", From: username, UserId: userId, Entities: entities}), nil)
+	}
+	for _, v := range CatchallHandlers {
+		addText(v(Request{Content: content, Platform: "telegram", Channel: "inline", From: username, UserId: userId, Entities: entities}), nil)
+	}
+	for _, v := range CatchallExtendedHandlers {
+		r := v(ExtendedMessage{Text: content, Platform: "telegram", Entities: entities})
+		if r == nil {
+			continue
+		}
+		if r.Image == nil {
+			addText(r.Text, r.Buttons)
+			continue
+		}
+		fileID, err := s.cachePhoto(r.Image, content)
+		if err != nil {
+			log.Println(err)
+			addText(r.Text, r.Buttons)
+			continue
+		}
+		if len(results) < telegramMaxInlineResults {
+			results = append(results, telegramInlineCachedPhoto{
+				Type:        "photo",
+				ID:          strconv.Itoa(len(results)),
+				PhotoFileID: fileID,
+				Caption:     RenderHTML(r.Text),
+				ParseMode:   tgbotapi.ModeHTML,
+				ReplyMarkup: s.keyboard(r.Buttons),
+			})
+		}
+	}
+}
+
+// cachePhoto uploads an image to InlineCacheChat, since inline results can
+// only refer to photos by URL or by an already uploaded file_id.
+func (s *TelegramMessagePlatform) cachePhoto(image []byte, content string) (string, error) {
+	if s.InlineCacheChat == 0 {
+		return "", fmt.Errorf("no inline cache chat configured for images")
+	}
+	msg := tgbotapi.NewPhotoUpload(s.InlineCacheChat, tgbotapi.FileBytes{
+		Name:  sanitizeFilename(content, "png"),
+		Bytes: image,
+	})
+	msg.DisableNotification = true
+	sent, err := s.Client.Send(msg)
+	if err != nil {
+		return "", err
+	}
+	if sent.Photo == nil || len(*sent.Photo) == 0 {
+		return "", fmt.Errorf("no photo in upload response")
+	}
+	photos := *sent.Photo
+	return photos[len(photos)-1].FileID, nil
+}
@@ -0,0 +1,90 @@
+package bothandler
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestTelegramNormalizeCommand(t *testing.T) {
+	s, _ := newFakeTelegram(t)
+
+	oldHandlers := MsgInputHandlers
+	MsgInputHandlers = map[string]MessageWithInputHandler{"!xkcd": func(Request) string { return "" }}
+	defer func() { MsgInputHandlers = oldHandlers }()
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"/xkcd 356", "!xkcd 356"},
+		{"/xkcd@testbot 356", "!xkcd 356"},
+		{"/xkcd@otherbot 356", "/xkcd@otherbot 356"},
+		{"/unknown 356", "/unknown 356"},
+		{"xkcd 356", "xkcd 356"},
+	}
+	for _, tt := range tests {
+		if got := s.normalizeCommand(tt.in); got != tt.want {
+			t.Errorf("normalizeCommand(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTelegramCut(t *testing.T) {
+	long := strings.Repeat("é", 300)
+	got := telegramCut(long, 256)
+	if !utf8.ValidString(got) || utf8.RuneCountInString(got) != 256 {
+		t.Errorf("got %d runes, valid %v, want 256 valid runes", utf8.RuneCountInString(got), utf8.ValidString(got))
+	}
+	// Inline result titles, e.g. from unicodefont
+	got = telegramCut(strings.Repeat("𝐛", 100), 64)
+	if !utf8.ValidString(got) || utf8.RuneCountInString(got) != 64 {
+		t.Errorf("got %d runes, valid %v, want 64 valid runes", utf8.RuneCountInString(got), utf8.ValidString(got))
+	}
+	if got := telegramCut("short", 256); got != "short" {
+		t.Errorf("got %q, want %q", got, "short")
+	}
+}
+
+func TestTelegramCallback(t *testing.T) {
+	s, calls := newFakeTelegram(t)
+
+	oldHandlers := CallbackHandlers
+	CallbackHandlers = map[string]CallbackHandler{
+		"test": func(r Request) *ExtendedMessage {
+			return &ExtendedMessage{Text: "page " + r.Content}
+		},
+	}
+	defer func() { CallbackHandlers = oldHandlers }()
+
+	// Longer than Telegram's 64 byte limit, so it goes through the lookup table
+	long := strings.Repeat("x", 100)
+	data := s.encodeCallback(Button{Text: "Next", Plugin: "test", Data: long})
+	if len(data) > telegramMaxCallbackData {
+		t.Fatalf("callback data too long: %d", len(data))
+	}
+
+	s.handleUpdate(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "1",
+		Message: &tgbotapi.Message{MessageID: 7, Chat: &tgbotapi.Chat{ID: -100}},
+		Data:    data,
+	}})
+
+	want := []string{"answerCallbackQuery", "editMessageText"}
+	for _, method := range want {
+		select {
+		case call := <-calls:
+			if call.Method != method {
+				t.Fatalf("got %s want %s", call.Method, method)
+			}
+			if method == "editMessageText" && call.Params.Get("text") != "page "+long {
+				t.Errorf("unexpected text %q", call.Params.Get("text"))
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no call to", method)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package bothandler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Telegram sends this header on every webhook call when a secret_token was
+// given to setWebhook.
+const telegramSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Updates are small JSON documents, anything bigger is not from Telegram.
+const telegramMaxUpdateSize = 1 << 20
+
+// TelegramWebhookConfig configures webhook mode for TelegramMessagePlatform.
+type TelegramWebhookConfig struct {
+	// URL is the public URL Telegram posts updates to, e.g.
+	// https://bot.example.com/telegram. If empty, setWebhook is not called,
+	// which is useful when the webhook is managed elsewhere or in tests.
+	URL string
+	// Listen is the local address for the HTTP listener, e.g. ":8443".
+	Listen string
+	// Path to serve updates on, defaults to the path of URL.
+	Path string
+	// SecretToken is checked against the X-Telegram-Bot-Api-Secret-Token
+	// header. Empty means no check, only sensible behind a trusted proxy.
+	SecretToken string
+	// CertFile and KeyFile serve HTTPS directly. Leave empty when running
+	// behind a reverse proxy that terminates TLS.
+	CertFile string
+	KeyFile  string
+}
+
+func (c *TelegramWebhookConfig) path() string {
+	if c.Path != "" {
+		return c.Path
+	}
+	u, err := url.Parse(c.URL)
+	if err == nil && u.Path != "" {
+		return u.Path
+	}
+	return "/"
+}
+
+func (s *TelegramMessagePlatform) processWebhook() {
+	config := s.Webhook
+	if config.URL != "" {
+		// tgbotapi's SetWebhook predates secret_token, so call the API directly.
+		v := url.Values{}
+		v.Add("url", config.URL)
+		if config.SecretToken != "" {
+			v.Add("secret_token", config.SecretToken)
+		}
+		v.Add("allowed_updates", `["message","edited_message","channel_post","edited_channel_post","inline_query","callback_query"]`)
+		_, err := s.Client.MakeRequest("setWebhook", v)
+		if err != nil {
+			log.Fatal("setWebhook ", err)
+		}
+		log.Println("Telegram webhook set to", config.URL)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(config.path(), s.webhookHandler)
+	server := &http.Server{
+		Addr:              config.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.webhookLock.Lock()
+	if s.webhookClosed {
+		s.webhookLock.Unlock()
+		return
+	}
+	s.webhookServer = server
+	s.webhookLock.Unlock()
+
+	var err error
+	if config.CertFile != "" && config.KeyFile != "" {
+		err = server.ListenAndServeTLS(config.CertFile, config.KeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Println(err)
+	}
+}
+
+func (s *TelegramMessagePlatform) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Webhook != nil && s.Webhook.SecretToken != "" {
+		got := r.Header.Get(telegramSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.Webhook.SecretToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, telegramMaxUpdateSize))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var update tgbotapi.Update
+	err = json.Unmarshal(body, &update)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	// Ack straight away, Telegram retries if we take too long, and some
+	// handlers (e.g. !sd) are slow.
+	w.WriteHeader(http.StatusOK)
+	s.webhookUpdates.Add(1)
+	go func() {
+		defer s.webhookUpdates.Done()
+		s.handleUpdate(update)
+	}()
+}
+
+// closeWebhook stops the listener, and waits a while for the updates being
+// handled to be answered.
+func (s *TelegramMessagePlatform) closeWebhook() {
+	s.webhookLock.Lock()
+	s.webhookClosed = true
+	server := s.webhookServer
+	s.webhookLock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if server != nil {
+		err := server.Shutdown(ctx)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		s.webhookUpdates.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Println("Telegram updates still being handled at shutdown")
+	}
+}
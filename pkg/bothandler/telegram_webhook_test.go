@@ -0,0 +1,144 @@
+package bothandler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// fakeTelegramFiles are served by the fake Bot API, keyed by file_id, which
+// is also the file name.
+var fakeTelegramFiles = map[string]string{
+	"photo_small.jpg": "small",
+	"photo_big.jpg":   "biggest photo",
+	"doc.png":         "document",
+	"sticker.webp":    "sticker",
+	"animated.tgs":    "animated sticker",
+	"image.webp":      string(testWebP),
+}
+
+type fakeTelegramCall struct {
+	Method string
+	Params url.Values
+}
+
+// rewriteTransport sends all api.telegram.org traffic to a local test server.
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newFakeTelegram returns a platform talking to a fake Bot API, and a channel
+// of the API calls it made, other than getMe.
+func newFakeTelegram(t *testing.T) (*TelegramMessagePlatform, chan fakeTelegramCall) {
+	calls := make(chan fakeTelegramCall, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/file/") {
+			w.Write([]byte(fakeTelegramFiles[r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]]))
+			return
+		}
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		switch method {
+		case "getMe":
+			w.Write([]byte(`{"ok":true,"result":{"id":1,"is_bot":true,"username":"testbot"}}`))
+			return
+		case "getFile":
+			r.ParseForm()
+			id := r.Form.Get("file_id")
+			fmt.Fprintf(w, `{"ok":true,"result":{"file_id":%q,"file_size":%d,"file_path":"files/%s"}}`, id, len(fakeTelegramFiles[id]), id)
+			return
+		}
+		r.ParseMultipartForm(1 << 20)
+		calls <- fakeTelegramCall{method, r.Form}
+		w.Write([]byte(`{"ok":true,"result":{"message_id":2,"chat":{"id":-100}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	target, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: rewriteTransport{target}}
+	bot, err := tgbotapi.NewBotAPIWithClient("TOKEN", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TelegramMessagePlatform{
+		Client:     bot,
+		ChannelId:  map[string]string{},
+		KnownUsers: map[string]tgbotapi.User{},
+	}, calls
+}
+
+func TestTelegramWebhook(t *testing.T) {
+	s, calls := newFakeTelegram(t)
+	s.Webhook = &TelegramWebhookConfig{SecretToken: "s3cret"}
+
+	oldHandlers := Handlers
+	Handlers = map[string]MessageHandler{"ping": func() string { return "pong" }}
+	defer func() { Handlers = oldHandlers }()
+	// Before putting the handlers back
+	defer s.Close()
+
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		status int
+		reply  bool
+	}{
+		{"message", "s3cret", `{"update_id":1,"message":{"message_id":1,"from":{"id":5,"username":"alice"},"chat":{"id":-100,"type":"group"},"text":"ping"}}`, http.StatusOK, true},
+		{"edited", "s3cret", `{"update_id":2,"edited_message":{"message_id":1,"from":{"id":5,"username":"alice"},"chat":{"id":-100,"type":"group"},"text":"ping"}}`, http.StatusOK, true},
+		{"channelpost", "s3cret", `{"update_id":3,"channel_post":{"message_id":1,"chat":{"id":-100,"type":"channel"},"text":"ping"}}`, http.StatusOK, true},
+		{"badsecret", "wrong", `{"update_id":4,"message":{"message_id":1,"chat":{"id":-100},"text":"ping"}}`, http.StatusUnauthorized, false},
+		{"badjson", "s3cret", `{"update_id":`, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(tt.body))
+			req.Header.Set(telegramSecretHeader, tt.secret)
+			w := httptest.NewRecorder()
+			s.webhookHandler(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status got %d want %d", w.Code, tt.status)
+			}
+			if !tt.reply {
+				return
+			}
+			select {
+			case call := <-calls:
+				if call.Method != "sendMessage" || call.Params.Get("text") != "pong" || call.Params.Get("chat_id") != "-100" {
+					t.Errorf("unexpected call %+v", call)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("no reply sent")
+			}
+		})
+	}
+}
+
+func TestTelegramWebhookClose(t *testing.T) {
+	s, _ := newFakeTelegram(t)
+	s.Webhook = &TelegramWebhookConfig{Listen: "127.0.0.1:0"}
+
+	done := make(chan struct{})
+	go func() {
+		s.processWebhook()
+		close(done)
+	}()
+	s.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook listener still running after Close")
+	}
+}
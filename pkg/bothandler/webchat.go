@@ -0,0 +1,222 @@
+package bothandler
+
+import (
+	"context"
+	_ "embed"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//go:embed webchat.html
+var webchatPage []byte
+
+// Implements MessagePlatform, as a chat page in the browser talking over a
+// WebSocket, to try handlers locally, images included. The page picks the
+// platform and user, so admin commands are refused.
+type WebchatMessagePlatform struct {
+	Listen         string
+	DefaultChannel string
+
+	server   *http.Server
+	upgrader websocket.Upgrader
+
+	connsLock sync.Mutex
+	conns     map[*webchatConn]bool
+}
+
+type webchatConn struct {
+	ws      *websocket.Conn
+	lock    sync.Mutex
+	channel string // of the last message, for ChannelMessageSend
+}
+
+// webchatFrame is the JSON sent both ways over the WebSocket. The browser
+// sends "message" and "callback" frames, and gets "reply" and "event"
+// frames back.
+type webchatFrame struct {
+	Type     string `json:"type"`
+	Platform string `json:"platform,omitempty"`
+	Channel  string `json:"channel,omitempty"`
+	User     string `json:"user,omitempty"`
+	Text     string `json:"text,omitempty"`
+	// Image is base64 encoded.
+	Image   string   `json:"image,omitempty"`
+	Buttons []Button `json:"buttons,omitempty"`
+	// Plugin and Data are a clicked Button.
+	Plugin string `json:"plugin,omitempty"`
+	Data   string `json:"data,omitempty"`
+}
+
+const webchatMaxMessageSize = 20 << 20
+
+func NewMessagePlatformFromWebchat(listen string) (*WebchatMessagePlatform, error) {
+	s := &WebchatMessagePlatform{
+		Listen: listen,
+		conns:  map[*webchatConn]bool{},
+	}
+	s.server = &http.Server{
+		Addr:    listen,
+		Handler: s.Handler(),
+	}
+	return s, nil
+}
+
+func (s *WebchatMessagePlatform) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(webchatPage)
+	})
+	mux.HandleFunc("GET /ws", s.handleWebSocket)
+	return mux
+}
+
+func (s *WebchatMessagePlatform) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	ws.SetReadLimit(webchatMaxMessageSize)
+	c := &webchatConn{ws: ws, channel: s.DefaultChannel}
+	s.connsLock.Lock()
+	s.conns[c] = true
+	s.connsLock.Unlock()
+	defer func() {
+		s.connsLock.Lock()
+		delete(s.conns, c)
+		s.connsLock.Unlock()
+		ws.Close()
+	}()
+
+	for {
+		frame := webchatFrame{}
+		err := ws.ReadJSON(&frame)
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Println(err)
+			}
+			return
+		}
+		if frame.Platform == "" {
+			frame.Platform = "webchat"
+		}
+		c.lock.Lock()
+		c.channel = frame.Channel
+		c.lock.Unlock()
+		// One at a time per browser, so replies stay in order.
+		s.handleFrame(c, frame)
+	}
+}
+
+func (s *WebchatMessagePlatform) handleFrame(c *webchatConn, frame webchatFrame) {
+	text, entities := NormalizeText(frame.Text)
+	request := Request{Content: text, Platform: frame.Platform, Channel: frame.Channel, From: frame.User, Unverified: true, Entities: entities}
+	reply := func(r *ExtendedMessage) {
+		out := webchatFrame{Type: "reply", Channel: frame.Channel, Text: r.Text, Buttons: r.Buttons}
+		if !r.HasImages() {
+			c.send(out)
+			return
+		}
+		// One frame per image, the text and buttons go with the first
+		for _, a := range r.AllImages() {
+			if len(a.Data) == 0 {
+				out.Text = strings.TrimSpace(out.Text + "\n" + a.Fallback("image"))
+			} else {
+				out.Image = base64.StdEncoding.EncodeToString(a.Data)
+			}
+			c.send(out)
+			out = webchatFrame{Type: "reply", Channel: frame.Channel}
+		}
+	}
+
+	switch frame.Type {
+	case "message":
+		if frame.Image == "" {
+			Dispatch(request, reply)
+			return
+		}
+		image, err := base64.StdEncoding.DecodeString(frame.Image)
+		if err != nil {
+			c.send(webchatFrame{Type: "reply", Text: "Bad image: " + err.Error()})
+			return
+		}
+		DispatchAttachment(NewAttachment("", image, ""), request, reply)
+	case "callback":
+		h, ok := CallbackHandlers[frame.Plugin]
+		if !ok {
+			log.Println("No callback handler for", frame.Plugin)
+			return
+		}
+		request.Content = frame.Data
+		r := h(request)
+		if r != nil {
+			reply(r)
+		}
+	}
+}
+
+func (c *webchatConn) send(frame webchatFrame) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	err := c.ws.WriteJSON(frame)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *WebchatMessagePlatform) ProcessMessages() {
+	log.Println("Webchat on http://" + s.Listen + "/")
+	err := s.server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Println(err)
+	}
+}
+
+func (s *WebchatMessagePlatform) Send(text string) {
+	if s == nil {
+		return
+	}
+	s.SendWithOptions(text, SendOptions{})
+}
+
+func (s *WebchatMessagePlatform) SendWithOptions(text string, options SendOptions) {
+	if s == nil {
+		return
+	}
+	s.ChannelMessageSend("", text)
+}
+
+func (s *WebchatMessagePlatform) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+}
+
+// ChannelMessageSend shows the message in browsers whose last message was
+// to channel, or in all of them if channel is empty.
+func (s *WebchatMessagePlatform) ChannelMessageSend(channel, message string) error {
+	s.connsLock.Lock()
+	conns := make([]*webchatConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.connsLock.Unlock()
+
+	for _, c := range conns {
+		c.lock.Lock()
+		current := c.channel
+		c.lock.Unlock()
+		if channel != "" && current != channel {
+			continue
+		}
+		c.send(webchatFrame{Type: "event", Channel: channel, Text: message})
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package bothandler
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebchat(t *testing.T) {
+	oldInput, oldAttachmentHandlers, oldExtended, oldCallbacks := MsgInputHandlers, AttachmentHandlers, CatchallExtendedHandlers, CallbackHandlers
+	MsgInputHandlers = map[string]MessageWithInputHandler{"!whoami": func(r Request) string {
+		return r.Platform + "/" + r.Channel + "/" + r.From + ": " + r.Content
+	}}
+	AttachmentHandlers = nil
+	RegisterAttachmentHandler(nil, func(a *Attachment, r Request) string {
+		filename, _ := a.Path()
+		b, _ := os.ReadFile(filename)
+		return r.Content + " " + string(b)
+	})
+	CatchallExtendedHandlers = []CatchallExtendedHandler{func(m ExtendedMessage) *ExtendedMessage {
+		if m.Text != "draw" {
+			return nil
+		}
+		return &ExtendedMessage{Image: []byte("PNG"), Buttons: []Button{{"Again", "draw", "more"}}}
+	}}
+	CallbackHandlers = map[string]CallbackHandler{"draw": func(r Request) *ExtendedMessage {
+		return &ExtendedMessage{Text: "clicked " + r.Content + " by " + r.From}
+	}}
+	defer func() {
+		MsgInputHandlers, AttachmentHandlers, CatchallExtendedHandlers, CallbackHandlers = oldInput, oldAttachmentHandlers, oldExtended, oldCallbacks
+	}()
+
+	s, _ := NewMessagePlatformFromWebchat("")
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "new WebSocket") {
+		t.Error("page not served")
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	roundtrip := func(frame webchatFrame) webchatFrame {
+		t.Helper()
+		err := ws.WriteJSON(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := webchatFrame{}
+		err = ws.ReadJSON(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	got := roundtrip(webchatFrame{Type: "message", Platform: "discord", Channel: "spacetraders", User: "alice", Text: "!whoami hi"})
+	if got.Type != "reply" || got.Text != "discord/spacetraders/alice: hi" {
+		t.Errorf("whoami got %+v", got)
+	}
+	got = roundtrip(webchatFrame{Type: "message", Text: "caption", Image: base64.StdEncoding.EncodeToString([]byte("cat"))})
+	if got.Text != "caption cat" {
+		t.Errorf("image got %+v", got)
+	}
+	got = roundtrip(webchatFrame{Type: "message", Channel: "general", Text: "draw"})
+	if got.Image != base64.StdEncoding.EncodeToString([]byte("PNG")) || len(got.Buttons) != 1 {
+		t.Fatalf("draw got %+v", got)
+	}
+	got = roundtrip(webchatFrame{Type: "callback", Channel: "general", User: "bob", Plugin: got.Buttons[0].Plugin, Data: got.Buttons[0].Data})
+	if got.Text != "clicked more by bob" {
+		t.Errorf("callback got %+v", got)
+	}
+
+	s.ChannelMessageSend("random", "not shown")
+	s.ChannelMessageSend("general", "announcement")
+	got = webchatFrame{}
+	err = ws.ReadJSON(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != "event" || got.Text != "announcement" {
+		t.Errorf("event got %+v", got)
+	}
+}
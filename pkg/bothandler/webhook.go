@@ -0,0 +1,388 @@
+package bothandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Webhook platforms send through a channel's webhook URL instead of a bot
+// account, for sending from scripts and plugins without a bot token. They
+// are send-only: ProcessMessages returns at once, and nobody can talk to
+// them. A webhook posts to the one channel it was made for, so Webhooks
+// maps channel names to URLs, with "" for the default channel.
+
+// webhookRetries is how many times a rate limited message is retried.
+const webhookRetries = 3
+
+// ParseWebhooks parses a comma separated list of webhook URLs, each
+// optionally prefixed by a channel name and "=", e.g.
+// "https://hooks.slack.com/services/...,alerts=https://...". A URL without
+// a name is the default channel's.
+func ParseWebhooks(s string) (map[string]string, error) {
+	webhooks := map[string]string{}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		name := ""
+		if i := strings.Index(v, "="); i >= 0 && !strings.Contains(v[:i], "/") {
+			name, v = v[:i], v[i+1:]
+		}
+		u, err := url.Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL for channel %q", name)
+		}
+		webhooks[name] = v
+	}
+	if len(webhooks) == 0 {
+		return nil, fmt.Errorf("no webhook URLs")
+	}
+	return webhooks, nil
+}
+
+// WebhookError is a webhook request the service refused.
+type WebhookError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *WebhookError) Error() string {
+	return fmt.Sprintf("webhook: %d %s", e.StatusCode, e.Body)
+}
+
+// webhookSender posts to webhooks one request at a time, waiting out rate
+// limits.
+type webhookSender struct {
+	Client   *http.Client
+	Webhooks map[string]string
+
+	platform string
+	lock     sync.Mutex
+	// until is when the rate limit resets, if the last response said no
+	// more requests are left.
+	until time.Time
+}
+
+// webhook finds the URL for a channel name, a channel registry alias, or
+// "" for the default.
+func (s *webhookSender) webhook(channel string) (string, error) {
+	if u, ok := s.Webhooks[channel]; ok {
+		return u, nil
+	}
+	if id, ok := Channels.Resolve(s.platform, channel); ok {
+		if u, ok := s.Webhooks[id]; ok {
+			return u, nil
+		}
+	}
+	if channel == "" {
+		return "", fmt.Errorf("no default webhook")
+	}
+	return "", fmt.Errorf("no webhook for channel %s", channel)
+}
+
+// do sends the request made by newRequest, retrying when rate limited, and
+// returns the response body.
+func (s *webhookSender) do(newRequest func() (*http.Request, error)) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for try := 0; ; try++ {
+		if wait := s.until.Sub(Now()); wait > 0 {
+			Sleep(wait)
+		}
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		s.until = time.Time{}
+		// Discord says when a bucket is empty, before it refuses requests.
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			if reset, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Reset-After"), 64); err == nil {
+				s.until = Now().Add(time.Duration(reset * float64(time.Second)))
+			}
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && try < webhookRetries {
+			wait := retryAfter(resp.Header, b)
+			log.Printf("%s webhook rate limited, retrying in %s", s.platform, wait)
+			s.until = Now().Add(wait)
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return b, &WebhookError{resp.StatusCode, strings.TrimSpace(string(b))}
+		}
+		return b, nil
+	}
+}
+
+// retryAfter is how long a 429 response says to wait: Discord has it in
+// the body with fractions of a second, Slack only in the header.
+func retryAfter(header http.Header, body []byte) time.Duration {
+	limited := struct {
+		RetryAfter float64 `json:"retry_after"`
+	}{}
+	if json.Unmarshal(body, &limited) == nil && limited.RetryAfter > 0 {
+		return time.Duration(limited.RetryAfter * float64(time.Second))
+	}
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	return time.Second
+}
+
+func (s *webhookSender) postJSON(u string, v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return s.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+}
+
+// Implements MessagePlatform with Slack incoming webhooks. Incoming webhooks
+// can't upload files, so files are sent as links if they have a URL.
+type SlackWebhookMessagePlatform struct {
+	webhookSender
+	// Username and the icon replace the app's name and icon, if the
+	// webhook allows it. IconEmoji is e.g. ":robot_face:".
+	Username  string
+	IconURL   string
+	IconEmoji string
+}
+
+func NewMessagePlatformFromSlackWebhook(webhooks string) (*SlackWebhookMessagePlatform, error) {
+	w, err := ParseWebhooks(webhooks)
+	if err != nil {
+		return nil, err
+	}
+	return &SlackWebhookMessagePlatform{
+		webhookSender: webhookSender{
+			Client:   &http.Client{Timeout: 60 * time.Second},
+			Webhooks: w,
+			platform: "slack",
+		},
+	}, nil
+}
+
+type slackWebhookMessage struct {
+	Text      string `json:"text"`
+	Username  string `json:"username,omitempty"`
+	IconURL   string `json:"icon_url,omitempty"`
+	IconEmoji string `json:"icon_emoji,omitempty"`
+	ThreadTs  string `json:"thread_ts,omitempty"`
+}
+
+func (s *SlackWebhookMessagePlatform) Send(text string) {
+	s.SendWithOptions(text, SendOptions{})
+}
+
+// SendWithOptions sends to the default channel. Slack has no silent
+// messages.
+func (s *SlackWebhookMessagePlatform) SendWithOptions(text string, options SendOptions) {
+	err := s.SendMessage(OutboundMessage{Text: text, Silent: options.Silent})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *SlackWebhookMessagePlatform) ProcessMessages() {}
+
+func (s *SlackWebhookMessagePlatform) Close() {}
+
+func (s *SlackWebhookMessagePlatform) ChannelMessageSend(channel, message string) error {
+	return s.SendMessage(OutboundMessage{Channel: channel, Text: message})
+}
+
+// SendMessage sends to the channel's webhook, in a thread if Thread is a
+// message timestamp.
+func (s *SlackWebhookMessagePlatform) SendMessage(m OutboundMessage) error {
+	if m.User != "" {
+		return &ErrUnsupported{"slack webhook", "direct messages"}
+	}
+	text := m.Text
+	for _, a := range m.Files {
+		if a.URL == "" {
+			return &ErrUnsupported{"slack webhook", "files"}
+		}
+		text = strings.TrimSpace(text + "\n" + a.URL)
+	}
+	u, err := s.webhook(m.Channel)
+	if err != nil {
+		return err
+	}
+	for _, page := range outboundPages(text, slackMaxMessage, slackSize) {
+		_, err := s.postJSON(u, slackWebhookMessage{
+			Text:      RenderSlack(page),
+			Username:  s.Username,
+			IconURL:   s.IconURL,
+			IconEmoji: s.IconEmoji,
+			ThreadTs:  m.Thread,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Implements MessagePlatform with Discord channel webhooks.
+type DiscordWebhookMessagePlatform struct {
+	webhookSender
+	// Username and AvatarURL replace the webhook's name and avatar.
+	Username  string
+	AvatarURL string
+}
+
+func NewMessagePlatformFromDiscordWebhook(webhooks string) (*DiscordWebhookMessagePlatform, error) {
+	w, err := ParseWebhooks(webhooks)
+	if err != nil {
+		return nil, err
+	}
+	return &DiscordWebhookMessagePlatform{
+		webhookSender: webhookSender{
+			Client:   &http.Client{Timeout: 60 * time.Second},
+			Webhooks: w,
+			platform: "discord",
+		},
+	}, nil
+}
+
+type discordWebhookMessage struct {
+	Content   string                 `json:"content,omitempty"`
+	Username  string                 `json:"username,omitempty"`
+	AvatarURL string                 `json:"avatar_url,omitempty"`
+	Flags     discordgo.MessageFlags `json:"flags,omitempty"`
+}
+
+func (s *DiscordWebhookMessagePlatform) Send(text string) {
+	s.SendWithOptions(text, SendOptions{})
+}
+
+func (s *DiscordWebhookMessagePlatform) SendWithOptions(text string, options SendOptions) {
+	err := s.SendMessage(OutboundMessage{Text: text, Silent: options.Silent})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *DiscordWebhookMessagePlatform) ProcessMessages() {}
+
+func (s *DiscordWebhookMessagePlatform) Close() {}
+
+func (s *DiscordWebhookMessagePlatform) ChannelMessageSend(channel, message string) error {
+	return s.SendMessage(OutboundMessage{Channel: channel, Text: message})
+}
+
+// SendMessage sends to the channel's webhook, in a thread of the channel if
+// Thread is its ID.
+func (s *DiscordWebhookMessagePlatform) SendMessage(m OutboundMessage) error {
+	if m.User != "" {
+		return &ErrUnsupported{"discord webhook", "direct messages"}
+	}
+	u, err := s.webhook(m.Channel)
+	if err != nil {
+		return err
+	}
+	// wait makes Discord confirm the message was posted.
+	query := url.Values{"wait": {"true"}}
+	if m.Thread != "" {
+		query.Set("thread_id", m.Thread)
+	}
+	if strings.Contains(u, "?") {
+		u += "&" + query.Encode()
+	} else {
+		u += "?" + query.Encode()
+	}
+
+	message := discordWebhookMessage{Username: s.Username, AvatarURL: s.AvatarURL}
+	if m.Silent {
+		message.Flags = discordgo.MessageFlagsSuppressNotifications
+	}
+	for _, page := range outboundPages(m.Text, discordMaxMessage, runeSize) {
+		message.Content = page
+		_, err := s.postJSON(u, message)
+		if err != nil {
+			return err
+		}
+	}
+	message.Content = ""
+	files := m.Files
+	for len(files) > 0 {
+		n := min(len(files), discordMaxFiles)
+		err := s.upload(u, message, files[:n])
+		if err != nil {
+			return err
+		}
+		files = files[n:]
+	}
+	return nil
+}
+
+// upload sends files in one message, as multipart form data with the rest
+// of the message in payload_json.
+func (s *DiscordWebhookMessagePlatform) upload(u string, message discordWebhookMessage, files []*Attachment) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	w.WriteField("payload_json", string(payload))
+	for i, a := range files {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="files[%d]"; filename="%s"`, i, escapeQuotes(a.Name("file"))))
+		h.Set("Content-Type", a.MimeType)
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		part.Write(a.Data)
+	}
+	w.Close()
+
+	_, err = s.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		return req, nil
+	})
+	return err
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
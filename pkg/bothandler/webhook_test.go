@@ -0,0 +1,207 @@
+package bothandler
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sleepClock records sleeps without waiting.
+type sleepClock struct {
+	lock  sync.Mutex
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *sleepClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *sleepClock) Sleep(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.slept = append(c.slept, d)
+	c.now = c.now.Add(d)
+}
+
+func TestParseWebhooks(t *testing.T) {
+	w, err := ParseWebhooks("https://hooks.slack.com/services/A, alerts=https://hooks.slack.com/services/B?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w[""] != "https://hooks.slack.com/services/A" || w["alerts"] != "https://hooks.slack.com/services/B?x=1" {
+		t.Error(w)
+	}
+	for _, v := range []string{"", "alerts=", "hooks.slack.com/services/A", "ftp://x/y"} {
+		_, err := ParseWebhooks(v)
+		if err == nil {
+			t.Errorf("%q: expected an error", v)
+		}
+	}
+}
+
+func TestSlackWebhook(t *testing.T) {
+	clock := &sleepClock{now: time.Unix(1700000000, 0)}
+	defer SetClock(SetClock(clock))
+
+	posts := []slackWebhookMessage{}
+	limited := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "no_service")
+			return
+		}
+		if !limited {
+			limited = true
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		m := slackWebhookMessage{}
+		json.NewDecoder(r.Body).Decode(&m)
+		posts = append(posts, m)
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	s, err := NewMessagePlatformFromSlackWebhook(server.URL + "/default,alerts=" + server.URL + "/alerts,old=" + server.URL + "/gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Username = "multibot"
+	s.IconEmoji = ":robot_face:"
+
+	s.Send("hello **world**")
+	if len(clock.slept) != 1 || clock.slept[0] != 2*time.Second {
+		t.Errorf("expected to wait out the rate limit, slept %v", clock.slept)
+	}
+	err = s.SendMessage(OutboundMessage{Channel: "alerts", Thread: "1700000000.000100", Text: "build failed",
+		Files: []*Attachment{{Filename: "log.txt", URL: "https://example.com/log.txt"}}})
+	if err != nil {
+		t.Error(err)
+	}
+	want := []slackWebhookMessage{
+		{Text: "hello *world*", Username: "multibot", IconEmoji: ":robot_face:"},
+		{Text: "build failed\nhttps://example.com/log.txt", Username: "multibot", IconEmoji: ":robot_face:", ThreadTs: "1700000000.000100"},
+	}
+	if len(posts) != len(want) {
+		t.Fatalf("expected %d posts, got %+v", len(want), posts)
+	}
+	for i := range want {
+		if posts[i] != want[i] {
+			t.Errorf("post %d: expected %+v, got %+v", i, want[i], posts[i])
+		}
+	}
+
+	if err := s.ChannelMessageSend("random", "hi"); err == nil || !strings.Contains(err.Error(), "no webhook") {
+		t.Error("expected no webhook for an unknown channel, got", err)
+	}
+	err = s.SendMessage(OutboundMessage{Text: "x", Files: []*Attachment{NewAttachment("a.png", testPNG, "")}})
+	if _, ok := err.(*ErrUnsupported); !ok {
+		t.Error("expected files to be unsupported, got", err)
+	}
+	err = s.ChannelMessageSend("old", "hi")
+	if e, ok := err.(*WebhookError); !ok || e.StatusCode != http.StatusNotFound || e.Body != "no_service" {
+		t.Error("expected the webhook's error, got", err)
+	}
+}
+
+func TestDiscordWebhook(t *testing.T) {
+	clock := &sleepClock{now: time.Unix(1700000000, 0)}
+	defer SetClock(SetClock(clock))
+
+	type post struct {
+		Query   string
+		Message discordWebhookMessage
+		Files   []string
+	}
+	posts := []post{}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 2 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, `{"message": "You are being rate limited.", "retry_after": 0.5, "global": false}`)
+			return
+		}
+		p := post{Query: r.URL.RawQuery}
+		mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "multipart/form-data" {
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			for {
+				part, err := mr.NextPart()
+				if err != nil {
+					break
+				}
+				b, _ := io.ReadAll(part)
+				if part.FormName() == "payload_json" {
+					json.Unmarshal(b, &p.Message)
+				} else {
+					p.Files = append(p.Files, part.FormName()+" "+part.FileName()+" "+part.Header.Get("Content-Type"))
+				}
+			}
+		} else {
+			json.NewDecoder(r.Body).Decode(&p.Message)
+		}
+		posts = append(posts, p)
+		// The bucket is empty after the first post.
+		if requests == 1 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset-After", "1.5")
+		}
+		io.WriteString(w, `{"id": "1"}`)
+	}))
+	defer server.Close()
+
+	s, err := NewMessagePlatformFromDiscordWebhook(server.URL + "/api/webhooks/1/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Username = "CI"
+	s.AvatarURL = "https://example.com/ci.png"
+
+	files := []*Attachment{}
+	for range discordMaxFiles + 1 {
+		files = append(files, NewAttachment("a.png", testPNG, ""))
+	}
+	err = s.SendMessage(OutboundMessage{Thread: "42", Text: "deployed", Silent: true, Files: files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.slept) != 2 || clock.slept[0] != 1500*time.Millisecond || clock.slept[1] != 500*time.Millisecond {
+		t.Errorf("expected to wait for the bucket and the retry, slept %v", clock.slept)
+	}
+	if len(posts) != 3 {
+		t.Fatalf("expected the text and two posts of files, got %+v", posts)
+	}
+	for _, p := range posts {
+		if p.Query != "thread_id=42&wait=true" {
+			t.Error("expected to wait in the thread, got", p.Query)
+		}
+		if p.Message.Username != "CI" || p.Message.AvatarURL != "https://example.com/ci.png" || p.Message.Flags != 4096 {
+			t.Errorf("expected a silent message as CI, got %+v", p.Message)
+		}
+	}
+	if posts[0].Message.Content != "deployed" || len(posts[0].Files) != 0 {
+		t.Errorf("expected the text first, got %+v", posts[0])
+	}
+	if len(posts[1].Files) != discordMaxFiles || len(posts[2].Files) != 1 || posts[2].Files[0] != "files[0] a.png image/png" {
+		t.Errorf("expected files in batches, got %v and %v", posts[1].Files, posts[2].Files)
+	}
+
+	err = s.SendMessage(OutboundMessage{User: "1234", Text: "hi"})
+	if _, ok := err.(*ErrUnsupported); !ok {
+		t.Error("expected direct messages to be unsupported, got", err)
+	}
+}
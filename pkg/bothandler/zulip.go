@@ -0,0 +1,539 @@
+package bothandler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Implements MessagePlatform, using Zulip's event queue API.
+//
+// Request.Channel is "stream>topic" for stream messages, like Zulip's
+// #**stream>topic** links, and the sender's email for direct messages.
+type ZulipMessagePlatform struct {
+	Client *http.Client
+	Site   string
+	Email  string
+	APIKey string
+	// DefaultChannel is "stream>topic", or a stream, which then uses
+	// DefaultTopic.
+	DefaultChannel string
+	DefaultTopic   string
+	MaxImageSize   int
+
+	queueId     string
+	lastEventId int64
+	stopChan    chan bool
+	stopOnce    sync.Once
+}
+
+// zulipMaxDownloadSize is the default size limit for attachments.
+const zulipMaxDownloadSize = DefaultMaxAttachmentSize
+
+type zulipMessage struct {
+	Id               int64           `json:"id"`
+	Type             string          `json:"type"`
+	SenderId         int64           `json:"sender_id"`
+	SenderEmail      string          `json:"sender_email"`
+	SenderFullName   string          `json:"sender_full_name"`
+	DisplayRecipient json.RawMessage `json:"display_recipient"`
+	Subject          string          `json:"subject"`
+	Content          string          `json:"content"`
+}
+
+type zulipEvent struct {
+	Type    string       `json:"type"`
+	Id      int64        `json:"id"`
+	Message zulipMessage `json:"message"`
+}
+
+// zulipTarget is where a message goes, a stream and topic, or a user for
+// direct messages.
+type zulipTarget struct {
+	Stream string
+	Topic  string
+	To     string
+}
+
+func (t zulipTarget) Channel() string {
+	if t.Stream != "" {
+		return t.Stream + ">" + t.Topic
+	}
+	return t.To
+}
+
+// ParseZuliprc reads the email, key and site from the [api] section of a
+// zuliprc file, as downloaded from Zulip's bot settings.
+func ParseZuliprc(filename string) (email, key, site string, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", "", "", err
+	}
+	defer f.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok || section != "api" {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "email":
+			email = strings.TrimSpace(v)
+		case "key":
+			key = strings.TrimSpace(v)
+		case "site":
+			site = strings.TrimSpace(v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", "", err
+	}
+	if email == "" || key == "" || site == "" {
+		return "", "", "", fmt.Errorf("%s needs email, key and site in [api]", filename)
+	}
+	if !strings.Contains(site, "://") {
+		site = "https://" + site
+	}
+	return email, key, site, nil
+}
+
+func NewMessagePlatformFromZuliprc(filename string) (*ZulipMessagePlatform, error) {
+	email, key, site, err := ParseZuliprc(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewMessagePlatformFromZulip(site, email, key)
+}
+
+func NewMessagePlatformFromZulip(site, email, apiKey string) (*ZulipMessagePlatform, error) {
+	s := &ZulipMessagePlatform{
+		Client:       &http.Client{Timeout: 120 * time.Second},
+		Site:         strings.TrimSuffix(site, "/"),
+		Email:        email,
+		APIKey:       apiKey,
+		DefaultTopic: "multibot",
+		MaxImageSize: zulipMaxDownloadSize,
+		stopChan:     make(chan bool),
+	}
+	me := struct {
+		FullName string `json:"full_name"`
+	}{}
+	err := s.call(http.MethodGet, "/api/v1/users/me", nil, &me)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Connected to Zulip on account %s (%s)", me.FullName, email)
+	return s, nil
+}
+
+func (s *ZulipMessagePlatform) newRequest(method, apiPath string, form url.Values) (*http.Request, error) {
+	var body io.Reader
+	u := s.Site + apiPath
+	if method == http.MethodGet {
+		if form != nil {
+			u += "?" + form.Encode()
+		}
+	} else if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return req, nil
+}
+
+// call does a REST API request with form parameters, and decodes the JSON
+// response into out.
+func (s *ZulipMessagePlatform) call(method, apiPath string, form url.Values, out any) error {
+	req, err := s.newRequest(method, apiPath, form)
+	if err != nil {
+		return err
+	}
+	return s.do(req, out)
+}
+
+// zulipError is the error body of failed API calls.
+type zulipError struct {
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (e *zulipError) Error() string {
+	return "zulip: " + e.Code + " " + e.Msg
+}
+
+func (s *ZulipMessagePlatform) do(req *http.Request, out any) error {
+	req.SetBasicAuth(s.Email, s.APIKey)
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		zerr := &zulipError{}
+		json.Unmarshal(b, zerr)
+		if zerr.Code == "" {
+			zerr.Code = resp.Status
+		}
+		return zerr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+func (s *ZulipMessagePlatform) register() error {
+	form := url.Values{}
+	form.Set("event_types", `["message"]`)
+	// We want the markdown as typed, not rendered HTML.
+	form.Set("apply_markdown", "false")
+	out := struct {
+		QueueId     string `json:"queue_id"`
+		LastEventId int64  `json:"last_event_id"`
+	}{}
+	err := s.call(http.MethodPost, "/api/v1/register", form, &out)
+	if err != nil {
+		return err
+	}
+	s.queueId = out.QueueId
+	s.lastEventId = out.LastEventId
+	return nil
+}
+
+func (s *ZulipMessagePlatform) ProcessMessages() {
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		default:
+		}
+
+		err := s.poll()
+		if err != nil {
+			log.Println(err)
+			if zerr, ok := err.(*zulipError); ok && zerr.Code == "BAD_EVENT_QUEUE_ID" {
+				// The queue expired, we'll miss what happened in between.
+				s.queueId = ""
+				continue
+			}
+			select {
+			case <-s.stopChan:
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+}
+
+func (s *ZulipMessagePlatform) poll() error {
+	if s.queueId == "" {
+		err := s.register()
+		if err != nil {
+			return err
+		}
+	}
+	form := url.Values{}
+	form.Set("queue_id", s.queueId)
+	form.Set("last_event_id", strconv.FormatInt(s.lastEventId, 10))
+	out := struct {
+		Events []zulipEvent `json:"events"`
+	}{}
+	err := s.call(http.MethodGet, "/api/v1/events", form, &out)
+	if err != nil {
+		return err
+	}
+	for _, e := range out.Events {
+		if e.Id > s.lastEventId {
+			s.lastEventId = e.Id
+		}
+		if e.Type == "message" {
+			s.handleMessage(e.Message)
+		}
+	}
+	return nil
+}
+
+func (s *ZulipMessagePlatform) handleMessage(m zulipMessage) {
+	if strings.EqualFold(m.SenderEmail, s.Email) {
+		return
+	}
+
+	target := zulipTarget{To: m.SenderEmail}
+	if m.Type == "stream" {
+		stream := ""
+		json.Unmarshal(m.DisplayRecipient, &stream)
+		target = zulipTarget{Stream: stream, Topic: m.Subject}
+		Channels.See(KnownChat{Platform: "zulip", ID: stream, Type: "stream"})
+	}
+	channel := target.Channel()
+	content := strings.TrimSpace(m.Content)
+
+	text, entities := NormalizeZulip(content)
+	request := Request{Content: text, Platform: "zulip", Channel: channel, From: m.SenderEmail, UserId: strconv.FormatInt(m.SenderId, 10), Entities: entities}
+
+	if uploads := zulipUploads(content); len(uploads) > 0 {
+		// The caption is whatever was typed around the upload links
+		caption := request
+		caption.Content, caption.Entities = NormalizeZulip(strings.TrimSpace(zulipUploadLink.ReplaceAllString(content, "")))
+		for _, upload := range uploads {
+			s.handleAttachment(target, caption, upload)
+		}
+	}
+	Dispatch(request, s.replier(request, target, text))
+}
+
+// replier sends responses to req to target, content is used to name images.
+func (s *ZulipMessagePlatform) replier(req Request, target zulipTarget, content string) Replier {
+	return func(r *ExtendedMessage) {
+		if !r.HasImages() {
+			s.reply(target, Paginate(req, r.Text, zulipMaxMessage, runeSize))
+			return
+		}
+		// The text goes with the first image only
+		text := r.Text
+		for _, a := range r.AllImages() {
+			if len(a.Data) == 0 {
+				s.reply(target, strings.TrimSpace(text+"\n"+a.Fallback(content)))
+				text = ""
+				continue
+			}
+			err := s.sendImage(target, text, a.Data, a.Name(content))
+			if err != nil {
+				log.Println(err)
+			}
+			text = ""
+		}
+	}
+}
+
+var zulipUploadLink = regexp.MustCompile(`\[([^\]]*)\]\((/user_uploads/[^)\s]+)\)`)
+
+// zulipUploads returns the /user_uploads/ paths of files linked in a
+// message, which is how Zulip sends pasted or attached files.
+func zulipUploads(content string) []string {
+	out := []string{}
+	for _, v := range zulipUploadLink.FindAllStringSubmatch(content, -1) {
+		out = append(out, v[2])
+	}
+	return out
+}
+
+// handleAttachment dispatches an upload, with request's Content as its caption.
+func (s *ZulipMessagePlatform) handleAttachment(target zulipTarget, request Request, upload string) {
+	// Zulip doesn't say what the upload is, guess from its name
+	filename := path.Base(upload)
+	if !WantsAttachment(mime.TypeByExtension(path.Ext(filename))) {
+		return
+	}
+	req, err := s.newRequest(http.MethodGet, upload, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	req.SetBasicAuth(s.Email, s.APIKey)
+	a, err := FetchAttachment(s.Client, req, filename, "", s.MaxImageSize)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	DispatchAttachment(a, request, s.replier(request, target, filename))
+}
+
+func (s *ZulipMessagePlatform) reply(target zulipTarget, text string) {
+	err := s.send(target, text)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *ZulipMessagePlatform) send(target zulipTarget, text string) error {
+	form := url.Values{}
+	form.Set("content", text)
+	if target.Stream != "" {
+		form.Set("type", "stream")
+		form.Set("to", target.Stream)
+		form.Set("topic", target.Topic)
+	} else {
+		to, _ := json.Marshal([]string{target.To})
+		form.Set("type", "private")
+		form.Set("to", string(to))
+	}
+	return s.call(http.MethodPost, "/api/v1/messages", form, nil)
+}
+
+func (s *ZulipMessagePlatform) upload(data []byte, filename string) (string, error) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile("filename", filename)
+	if err != nil {
+		return "", err
+	}
+	part.Write(data)
+	w.Close()
+
+	req, err := http.NewRequest(http.MethodPost, s.Site+"/api/v1/user_uploads", body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	out := struct {
+		URI string `json:"uri"`
+		URL string `json:"url"`
+	}{}
+	err = s.do(req, &out)
+	if out.URL == "" {
+		// Before Zulip 9.0
+		out.URL = out.URI
+	}
+	return out.URL, err
+}
+
+// sendImage uploads the image and links it in a message, which Zulip shows
+// inline.
+func (s *ZulipMessagePlatform) sendImage(target zulipTarget, text string, image []byte, filename string) error {
+	uri, err := s.upload(image, filename)
+	if err != nil {
+		// Better than nothing
+		if text != "" {
+			s.reply(target, text)
+		}
+		return err
+	}
+	link := fmt.Sprintf("[%s](%s)", path.Base(filename), uri)
+	if text != "" {
+		link = text + "\n" + link
+	}
+	return s.send(target, link)
+}
+
+// resolveTarget turns "stream>topic", a stream, a channel registry alias
+// for either, or an email address for a direct message into a target.
+func (s *ZulipMessagePlatform) resolveTarget(channel string) (zulipTarget, error) {
+	if channel == "" {
+		channel = s.DefaultChannel
+	}
+	if channel == "" {
+		return zulipTarget{}, fmt.Errorf("no channel specified")
+	}
+	if id, ok := Channels.Resolve("zulip", channel); ok {
+		channel = id
+	}
+	if strings.Contains(channel, "@") && !strings.Contains(channel, ">") {
+		return zulipTarget{To: channel}, nil
+	}
+	stream, topic, ok := strings.Cut(channel, ">")
+	if !ok {
+		topic = s.DefaultTopic
+	}
+	return zulipTarget{Stream: strings.TrimPrefix(stream, "#"), Topic: topic}, nil
+}
+
+func (s *ZulipMessagePlatform) Send(text string) {
+	if s == nil {
+		return
+	}
+	s.SendWithOptions(text, SendOptions{})
+}
+
+func (s *ZulipMessagePlatform) SendWithOptions(text string, options SendOptions) {
+	if s == nil {
+		return
+	}
+	// Zulip has no silent messages, notifications are per user settings.
+	err := s.ChannelMessageSend("", text)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *ZulipMessagePlatform) Close() {
+	if s.stopChan != nil {
+		s.stopOnce.Do(func() { close(s.stopChan) })
+	}
+	// The event queue is left to expire on the server.
+}
+
+func (s *ZulipMessagePlatform) ChannelMessageSend(channel, message string) error {
+	target, err := s.resolveTarget(channel)
+	if err != nil {
+		return err
+	}
+	// Nobody to say !more, send it all
+	for _, page := range SplitMessage(message, zulipMaxMessage, runeSize) {
+		err = s.send(target, page)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendMessage sends to a stream, with Thread as the topic, or an email
+// address for a direct message. Files are uploaded and linked, which Zulip
+// shows inline. Zulip has no silent messages.
+func (s *ZulipMessagePlatform) SendMessage(m OutboundMessage) error {
+	target := zulipTarget{To: m.User}
+	if m.User == "" {
+		var err error
+		target, err = s.resolveTarget(m.Channel)
+		if err != nil {
+			return err
+		}
+		if m.Thread != "" && target.Stream != "" {
+			target.Topic = m.Thread
+		}
+	}
+
+	for _, page := range outboundPages(m.Text, zulipMaxMessage, runeSize) {
+		err := s.send(target, page)
+		if err != nil {
+			return err
+		}
+	}
+	for _, a := range m.Files {
+		filename := a.Name("file")
+		uri, err := s.upload(a.Data, filename)
+		if err != nil {
+			return err
+		}
+		err = s.send(target, fmt.Sprintf("[%s](%s)", path.Base(filename), uri))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
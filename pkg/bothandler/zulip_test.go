@@ -0,0 +1,145 @@
+package bothandler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeZulipCall struct {
+	Path string
+	Form url.Values
+	Body string
+}
+
+func TestZulip(t *testing.T) {
+	oldHandlers, oldAttachmentHandlers, oldExtended := Handlers, AttachmentHandlers, CatchallExtendedHandlers
+	Handlers = map[string]MessageHandler{"ping": func() string { return "pong" }}
+	AttachmentHandlers = nil
+	RegisterAttachmentHandler(nil, func(a *Attachment, r Request) string {
+		filename, _ := a.Path()
+		b, _ := os.ReadFile(filename)
+		return strings.TrimSpace(r.Channel + " got " + string(b) + " " + r.Content)
+	})
+	CatchallExtendedHandlers = []CatchallExtendedHandler{func(m ExtendedMessage) *ExtendedMessage {
+		if m.Text != "draw" {
+			return nil
+		}
+		return &ExtendedMessage{Text: "here", Image: []byte("\x89PNG\r\n\x1a\n")}
+	}}
+	defer func() {
+		Handlers, AttachmentHandlers, CatchallExtendedHandlers = oldHandlers, oldAttachmentHandlers, oldExtended
+	}()
+
+	events := make(chan string, 3)
+	events <- `{"result":"success","events":[{"type":"heartbeat","id":1}]}`
+	events <- `{"result":"error","code":"BAD_EVENT_QUEUE_ID","msg":"Bad event queue id"}`
+	events <- `{"result":"success","events":[
+		{"type":"message","id":11,"message":{"type":"stream","sender_email":"bot@test","display_recipient":"general","subject":"chat","content":"ping"}},
+		{"type":"message","id":12,"message":{"type":"stream","sender_email":"alice@test","display_recipient":"general","subject":"chat","content":"ping"}},
+		{"type":"message","id":13,"message":{"type":"private","sender_email":"alice@test","display_recipient":[{"email":"alice@test"}],"subject":"","content":"ping"}},
+		{"type":"message","id":14,"message":{"type":"stream","sender_email":"alice@test","display_recipient":"general","subject":"pics","content":"!addface tom\n[cat.png](/user_uploads/1/ab/cat.png)"}},
+		{"type":"message","id":15,"message":{"type":"stream","sender_email":"alice@test","display_recipient":"general","subject":"chat","content":"draw"}}]}`
+
+	registers := atomic.Int32{}
+	calls := make(chan fakeZulipCall, 20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, key, _ := r.BasicAuth(); user != "bot@test" || key != "KEY" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/users/me":
+			w.Write([]byte(`{"result":"success","full_name":"Bot"}`))
+			return
+		case "/api/v1/register":
+			fmt.Fprintf(w, `{"result":"success","queue_id":"q%d","last_event_id":-1}`, registers.Add(1))
+			return
+		case "/api/v1/events":
+			select {
+			case v := <-events:
+				if strings.Contains(v, "BAD_EVENT_QUEUE_ID") {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+				w.Write([]byte(v))
+			case <-time.After(100 * time.Millisecond):
+				w.Write([]byte(`{"result":"success","events":[]}`))
+			}
+			return
+		case "/user_uploads/1/ab/cat.png":
+			w.Write([]byte("meow"))
+			return
+		}
+		if r.URL.Path == "/api/v1/user_uploads" {
+			r.ParseMultipartForm(1 << 20)
+			f, _, _ := r.FormFile("filename")
+			b, _ := io.ReadAll(f)
+			calls <- fakeZulipCall{r.URL.Path, nil, string(b)}
+			w.Write([]byte(`{"result":"success","uri":"/user_uploads/2/cd/draw.png"}`))
+			return
+		}
+		r.ParseForm()
+		calls <- fakeZulipCall{r.URL.Path, r.PostForm, ""}
+		w.Write([]byte(`{"result":"success","id":100}`))
+	}))
+	defer server.Close()
+
+	zuliprc := filepath.Join(t.TempDir(), "zuliprc")
+	os.WriteFile(zuliprc, []byte("[api]\nemail=bot@test\nkey=KEY\nsite="+server.URL+"\n"), 0600)
+	s, err := NewMessagePlatformFromZuliprc(zuliprc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.DefaultChannel = "announce"
+	go s.ProcessMessages()
+	defer s.Close()
+	// sendmsg closes it, then Shutdown again
+	defer s.Close()
+
+	next := func() fakeZulipCall {
+		t.Helper()
+		select {
+		case call := <-calls:
+			return call
+		case <-time.After(2 * time.Second):
+			t.Fatal("no call")
+		}
+		return fakeZulipCall{}
+	}
+	expectMessage := func(typ, to, topic, content string) {
+		t.Helper()
+		call := next()
+		f := call.Form
+		if call.Path != "/api/v1/messages" || f.Get("type") != typ || f.Get("to") != to || f.Get("topic") != topic || f.Get("content") != content {
+			t.Errorf("unexpected call %+v", call)
+		}
+	}
+
+	expectMessage("stream", "general", "chat", "pong")
+	expectMessage("private", `["alice@test"]`, "", "pong")
+	expectMessage("stream", "general", "pics", "general>pics got meow !addface tom")
+	call := next()
+	if call.Path != "/api/v1/user_uploads" || call.Body != "\x89PNG\r\n\x1a\n" {
+		t.Errorf("expected upload, got %+v", call)
+	}
+	expectMessage("stream", "general", "chat", "here\n[draw.png](/user_uploads/2/cd/draw.png)")
+	if registers.Load() != 2 {
+		t.Errorf("expected a new queue after BAD_EVENT_QUEUE_ID, registered %d times", registers.Load())
+	}
+
+	s.Send("hello")
+	expectMessage("stream", "announce", "multibot", "hello")
+	err = s.ChannelMessageSend("general>news", "extra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMessage("stream", "general", "news", "extra")
+}
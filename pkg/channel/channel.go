@@ -0,0 +1,80 @@
+package channel
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/angch/multibot/pkg/bothandler"
+)
+
+const usage = "Usage: !channel list | !channel alias <name> here|<chat> | !channel unalias <name>"
+
+func init() {
+	bothandler.RegisterHandlerPlugin("channel", func() {
+		bothandler.RegisterMessageWithInputHandler("!channel", ChannelHandler)
+		bothandler.RegisterCommand("!channel", "Name chats for sendmsg and scheduled posts (admins only)")
+	})
+}
+
+// ChannelHandler manages aliases in the channel registry, so new chats can be
+// targeted by name without a code change.
+func ChannelHandler(request bothandler.Request) string {
+	if !bothandler.IsAdmin(request) {
+		return ""
+	}
+	platform := strings.ToLower(request.Platform)
+	words := strings.Fields(request.Content)
+	if len(words) == 0 {
+		return usage
+	}
+
+	switch words[0] {
+	case "list":
+		chats, aliases := bothandler.Channels.List(platform)
+		if len(chats) == 0 {
+			return "No known chats"
+		}
+		out := []string{}
+		for _, v := range chats {
+			line := fmt.Sprintf("%s %s (%s)", v.ID, v.Title, v.Type)
+			if v.Username != "" {
+				line += " @" + v.Username
+			}
+			if a, ok := aliases[v.ID]; ok {
+				line += " = " + strings.Join(a, ", ")
+			}
+			out = append(out, line)
+		}
+		return strings.Join(out, "\n")
+	case "alias":
+		if len(words) != 3 {
+			return usage
+		}
+		name, target := words[1], words[2]
+		if target == "here" {
+			target = request.Channel
+		}
+		id, ok := bothandler.Channels.Resolve(platform, target)
+		if !ok {
+			return "Unknown chat " + target
+		}
+		err := bothandler.Channels.Alias(platform, name, id)
+		if err != nil {
+			return err.Error()
+		}
+		title := id
+		if chat, ok := bothandler.Channels.Chat(platform, id); ok && chat.Title != "" {
+			title = chat.Title
+		}
+		return fmt.Sprintf("%s is now %s", name, title)
+	case "unalias":
+		if len(words) != 2 {
+			return usage
+		}
+		if !bothandler.Channels.Unalias(platform, words[1]) {
+			return "No such alias " + words[1]
+		}
+		return "Removed " + words[1]
+	}
+	return usage
+}
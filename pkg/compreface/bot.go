@@ -1,14 +1,14 @@
 package compreface
 
 import (
+	"fmt"
 	"log"
-	"os"
 	"strings"
 
 	"github.com/angch/multibot/pkg/bothandler"
 )
 
-func ComprefaceHandler(filename string, request bothandler.Request) string {
+func ComprefaceHandler(a *bothandler.Attachment, request bothandler.Request) string {
 	if !strings.HasPrefix(request.Content, "!addface") {
 		return ""
 	}
@@ -19,12 +19,8 @@ func ComprefaceHandler(filename string, request bothandler.Request) string {
 	}
 	subject := strings.Join(words[1:], " ")
 
-	filedata, err := os.ReadFile(filename)
-	if err != nil {
-		return "compreface: Error opening file"
-	}
-	log.Println("File size is", len(filedata))
-	output := botFaceRecognition.AddFace(subject, 1.0, filedata)
+	log.Println("File size is", len(a.Data))
+	output := botFaceRecognition.AddFace(subject, 1.0, a.Data)
 
 	return "Added " + string(output)
 }
@@ -33,8 +29,22 @@ var botCompreface *Compreface
 var botFaceRecognition *RecognitionService
 
 func init() {
-	botCompreface = New("http://localhost:8000")
-	botFaceRecognition = botCompreface.InitFaceRecognition("92a551ee-3bf6-447d-97dd-824c61846192")
+	bothandler.RegisterPluginFunc("compreface", Init)
+}
+
+// Init uses the CompreFace server at COMPREFACE_URL, e.g.
+// http://localhost:8000, with the recognition service's COMPREFACE_API_KEY.
+func Init(config bothandler.PluginConfig) error {
+	baseURL, apiKey := config.Get("COMPREFACE_URL"), config.Get("COMPREFACE_API_KEY")
+	if baseURL == "" || apiKey == "" {
+		return fmt.Errorf("need COMPREFACE_URL and COMPREFACE_API_KEY")
+	}
+	botCompreface = New(baseURL)
+	if botCompreface == nil {
+		return fmt.Errorf("invalid COMPREFACE_URL %q", baseURL)
+	}
+	botFaceRecognition = botCompreface.InitFaceRecognition(apiKey)
 
 	bothandler.RegisterImageHandler(ComprefaceHandler)
+	return nil
 }
@@ -1,6 +1,7 @@
 package dict
 
 import (
+	"fmt"
 	"sort"
 	"strconv"
 	"strings"
@@ -9,22 +10,92 @@ import (
 )
 
 func init() {
-	bothandler.RegisterCatchallHandler(DictHandler)
-	myDict = NewMetaDictionary()
+	bothandler.RegisterHandlerPlugin("dict", func() {
+		bothandler.RegisterCatchallExtendeHandler(DictExtendedHandler)
+		bothandler.RegisterCallbackHandler("dict", DictCallbackHandler)
+		bothandler.RegisterCommand("!dict", "Word finder, e.g. !dict 5 +a -e =?r??? ~ab")
+		myDict = NewMetaDictionary()
+	})
 }
 
 var myDict *MetaDictionary
 
-func DictHandler(r bothandler.Request) string {
-	input := r.Content
+const pageSize = 9
+
+// maxWords caps the words listed where there are no buttons to page with,
+// the platform pages those with "!more", and more would only pile up there.
+const maxWords = 200
+
+// DictExtendedHandler returns the matching words, with buttons to page
+// through them on platforms that have buttons, or else all of them, up to
+// maxWords, for the platform to page with "!more".
+func DictExtendedHandler(input bothandler.ExtendedMessage) *bothandler.ExtendedMessage {
+	if bothandler.HasButtons(input.Platform) {
+		return dictPage(input.Text, 0)
+	}
+	o := dictLookup(input.Text)
+	if o == nil {
+		return nil
+	}
+	text := strings.Join(o[:min(len(o), maxWords)], ", ")
+	if len(o) > maxWords {
+		text += fmt.Sprintf(", ... and %d more", len(o)-maxWords)
+	}
+	return &bothandler.ExtendedMessage{Text: text}
+}
+
+// DictCallbackHandler handles the paging buttons, Content is "page query".
+func DictCallbackHandler(r bothandler.Request) *bothandler.ExtendedMessage {
+	p, query, ok := strings.Cut(r.Content, " ")
+	if !ok {
+		return nil
+	}
+	page, err := strconv.Atoi(p)
+	if err != nil {
+		return nil
+	}
+	return dictPage(query, page)
+}
+
+func dictPage(query string, page int) *bothandler.ExtendedMessage {
+	o := dictLookup(query)
+	if o == nil {
+		return nil
+	}
+	pages := (len(o) + pageSize - 1) / pageSize
+	if page < 0 || (page > 0 && page >= pages) {
+		return nil
+	}
+
+	start := page * pageSize
+	end := min(start+pageSize, len(o))
+	text := strings.Join(o[start:end], ", ")
+
+	buttons := []bothandler.Button{}
+	if page > 0 {
+		buttons = append(buttons, bothandler.Button{Text: "« Prev", Plugin: "dict", Data: strconv.Itoa(page-1) + " " + query})
+	}
+	if end < len(o) {
+		text += ", ..."
+		buttons = append(buttons, bothandler.Button{Text: "Next »", Plugin: "dict", Data: strconv.Itoa(page+1) + " " + query})
+	}
+	return &bothandler.ExtendedMessage{
+		Text:    text,
+		Buttons: buttons,
+	}
+}
+
+// dictLookup returns the sorted words matching a "!dict ..." query, or nil if
+// it is not a dict query.
+func dictLookup(input string) []string {
 	args := strings.Split(input, " ")
 
 	if len(args) == 0 || args[0] != "!dict" {
-		return ""
+		return nil
 	}
 
 	if myDict == nil {
-		return ""
+		return nil
 	}
 	w := myDict.All
 	d := myDict
@@ -63,16 +134,11 @@ func DictHandler(r bothandler.Request) string {
 		o = append(o, k)
 	}
 
+	// Stable, so paging through the results gives the same order every time
+	sort.Strings(o)
 	switch sorttype {
 	case "len":
-		sort.Slice(o, func(i, j int) bool { return len(o[i]) > len(o[j]) })
-	default:
-		sort.Strings(o)
+		sort.SliceStable(o, func(i, j int) bool { return len(o[i]) > len(o[j]) })
 	}
-	if len(o) >= 10 {
-		o = o[:9]
-		o = append(o, "...")
-	}
-
-	return strings.Join(o, ", ")
+	return o
 }
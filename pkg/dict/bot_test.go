@@ -0,0 +1,36 @@
+package dict
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/angch/multibot/pkg/bothandler"
+)
+
+func TestDictExtendedHandler(t *testing.T) {
+	words := map[string]bool{}
+	for i := 0; i < maxWords+5; i++ {
+		words[fmt.Sprintf("w%03d", i)] = true
+	}
+	oldDict := myDict
+	myDict = &MetaDictionary{All: &Dictionary{Words: words}, Five: &Dictionary{Words: map[string]bool{}}}
+	defer func() { myDict = oldDict }()
+
+	r := DictExtendedHandler(bothandler.ExtendedMessage{Text: "!dict", Platform: "telegram"})
+	if r == nil || len(r.Buttons) != 1 || strings.Count(r.Text, ",") != pageSize {
+		t.Fatalf("telegram got %+v", r)
+	}
+	r = DictCallbackHandler(bothandler.Request{Content: r.Buttons[0].Data})
+	if r == nil || len(r.Buttons) != 2 || !strings.HasPrefix(r.Text, "w009, ") {
+		t.Errorf("next page got %+v", r)
+	}
+
+	r = DictExtendedHandler(bothandler.ExtendedMessage{Text: "!dict", Platform: "discord"})
+	if r == nil || len(r.Buttons) != 0 || !strings.HasSuffix(r.Text, "w199, ... and 5 more") {
+		t.Errorf("discord got %+v", r)
+	}
+	if r := DictExtendedHandler(bothandler.ExtendedMessage{Text: "hello", Platform: "discord"}); r != nil {
+		t.Errorf("not a dict query, got %+v", r)
+	}
+}
@@ -5,14 +5,16 @@ import (
 )
 
 func init() {
-	bothandler.RegisterCatchallHandler(EchoHandler)
-
-	// for k, v := range fragments {
-	// 	vl := strings.ToLower(v.From)
-	// 	if vl != v.From {
-	// 		fragments[k].From = vl
-	// 	}
-	// }
+	bothandler.RegisterHandlerPlugin("echo", func() {
+		bothandler.RegisterCatchallHandler(EchoHandler)
+
+		// for k, v := range fragments {
+		// 	vl := strings.ToLower(v.From)
+		// 	if vl != v.From {
+		// 		fragments[k].From = vl
+		// 	}
+		// }
+	})
 }
 
 var echos = map[string]string{
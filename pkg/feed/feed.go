@@ -0,0 +1,459 @@
+// Package feed watches RSS, Atom and JSON feeds, and posts new items to the
+// channels they were added to.
+package feed
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angch/multibot/pkg/bothandler"
+)
+
+// Feed is a feed posted to a channel.
+type Feed struct {
+	ID       int
+	URL      string
+	Platform string
+	Channel  string
+	// Filter and Exclude are regular expressions that items' titles must,
+	// and must not, match to be posted.
+	Filter  string `json:",omitempty"`
+	Exclude string `json:",omitempty"`
+	// DigestHours, if set, collects new items into Pending and posts them
+	// together this often, instead of as they come.
+	DigestHours int       `json:",omitempty"`
+	Pending     []Item    `json:",omitempty"`
+	LastDigest  time.Time `json:",omitempty"`
+
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+	// Seen are the IDs of the items already in the feed, oldest first.
+	Seen []string
+}
+
+// Store is the feeds, saved to a file on every change.
+type Store struct {
+	lock     sync.Mutex
+	filename string
+
+	Feeds  []*Feed
+	NextID int
+}
+
+// maxSeen is how many item IDs are remembered per feed, more than feeds
+// usually have.
+const maxSeen = 1000
+
+// maxSize is the largest feed that is read.
+const maxSize = 10 << 20
+
+// Where the feeds are kept, and how often they are checked.
+var feedsFile = "feeds.js"
+var interval = 15 * time.Minute
+
+var client = &http.Client{Timeout: 60 * time.Second}
+
+var feeds = &Store{}
+
+const usage = "Usage: !feed add <url> here|<chat> | !feed list | !feed remove <n> | !feed filter <n> [regexp] | !feed exclude <n> [regexp] | !feed digest <n> <hours>|off"
+
+// plugin checks the feeds every interval.
+type plugin struct {
+	done chan struct{}
+}
+
+func init() {
+	bothandler.RegisterPlugin(&plugin{})
+}
+
+func (p *plugin) Name() string { return "feed" }
+
+func (p *plugin) Init(config bothandler.PluginConfig) error {
+	if v := config.Get("FEED_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("FEED_INTERVAL: %w", err)
+		}
+		interval = d
+	}
+	err := feeds.Load(feedsFile)
+	if err != nil {
+		return err
+	}
+	bothandler.RegisterMessageWithInputHandler("!feed", FeedHandler)
+	bothandler.RegisterCommand("!feed", "Post new items from RSS, Atom and JSON feeds (admins only)")
+	return nil
+}
+
+func (p *plugin) Start() error {
+	p.done = make(chan struct{})
+	go Watch(p.done)
+	return nil
+}
+
+func (p *plugin) Stop() {
+	close(p.done)
+}
+
+// Load reads the feeds from filename, and saves to it on every change. A
+// missing file is not an error.
+func (s *Store) Load(filename string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.filename = filename
+	b, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, s)
+}
+
+// save must be called with the lock held.
+func (s *Store) save() {
+	if s.filename == "" {
+		return
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = os.WriteFile(s.filename, b, 0644)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// get returns a copy of the feed, to fetch without holding the lock.
+func (s *Store) get(id int, platform string) (Feed, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, f := range s.Feeds {
+		if f.ID == id && (platform == "" || f.Platform == platform) {
+			return *f, true
+		}
+	}
+	return Feed{}, false
+}
+
+// update changes a feed with fn, and saves. It is false if there is no
+// such feed.
+func (s *Store) update(id int, fn func(f *Feed)) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, f := range s.Feeds {
+		if f.ID == id {
+			fn(f)
+			s.save()
+			return true
+		}
+	}
+	return false
+}
+
+// Watch checks the feeds every interval until done is closed.
+func Watch(done <-chan struct{}) {
+	for {
+		PollAll()
+		if !bothandler.SleepOrDone(interval, done) {
+			return
+		}
+	}
+}
+
+// PollAll checks every feed once.
+func PollAll() {
+	feeds.lock.Lock()
+	ids := []int{}
+	for _, f := range feeds.Feeds {
+		ids = append(ids, f.ID)
+	}
+	feeds.lock.Unlock()
+	for _, id := range ids {
+		err := Poll(id)
+		if err != nil {
+			log.Printf("feed %d: %v", id, err)
+		}
+	}
+}
+
+// Poll fetches a feed, and posts its new items, or the digest if it is due.
+func Poll(id int) error {
+	f, ok := feeds.get(id, "")
+	if !ok {
+		return fmt.Errorf("no such feed")
+	}
+	items, err := fetch(&f)
+	if err != nil {
+		return err
+	}
+
+	// The feed may have been changed while fetching, e.g. by "!feed digest",
+	// so the new items are merged into the stored feed, not f.
+	posts, digest := []Item{}, []Item{}
+	ok = feeds.update(id, func(stored *Feed) {
+		stored.ETag, stored.LastModified = f.ETag, f.LastModified
+		include, exclude := stored.filters()
+		seen := map[string]bool{}
+		for _, v := range stored.Seen {
+			seen[v] = true
+		}
+		for _, item := range items {
+			if seen[item.ID] {
+				continue
+			}
+			stored.Seen = append(stored.Seen, item.ID)
+			if (include == nil || include.MatchString(item.Title)) && (exclude == nil || !exclude.MatchString(item.Title)) {
+				posts = append(posts, item)
+			}
+		}
+		if len(stored.Seen) > maxSeen {
+			stored.Seen = stored.Seen[len(stored.Seen)-maxSeen:]
+		}
+
+		if stored.DigestHours > 0 {
+			stored.Pending = append(stored.Pending, posts...)
+			posts = nil
+			if len(stored.Pending) > 0 && !bothandler.Now().Before(stored.LastDigest.Add(time.Duration(stored.DigestHours)*time.Hour)) {
+				digest, stored.Pending = stored.Pending, nil
+				stored.LastDigest = bothandler.Now()
+			}
+		}
+		f = *stored
+	})
+	if !ok {
+		// Removed while fetching
+		return nil
+	}
+
+	for _, item := range posts {
+		f.send(item.String())
+	}
+	if len(digest) > 0 {
+		lines := []string{fmt.Sprintf("**%d new from %s**", len(digest), f.URL)}
+		for _, item := range digest {
+			lines = append(lines, fmt.Sprintf("- %s %s", item.Title, item.Link))
+		}
+		f.send(strings.Join(lines, "\n"))
+	}
+	return nil
+}
+
+// fetch gets the feed's items, oldest first, or none if it hasn't changed.
+func fetch(f *Feed) ([]Item, error) {
+	req, err := http.NewRequest(http.MethodGet, f.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "multibot feed watcher")
+	if f.ETag != "" {
+		req.Header.Set("If-None-Match", f.ETag)
+	}
+	if f.LastModified != "" {
+		req.Header.Set("If-Modified-Since", f.LastModified)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize))
+	if err != nil {
+		return nil, err
+	}
+	items, err := Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	f.ETag = resp.Header.Get("ETag")
+	f.LastModified = resp.Header.Get("Last-Modified")
+
+	// Feeds list the newest first, usually
+	dated := !slices.ContainsFunc(items, func(i Item) bool { return i.Published.IsZero() })
+	if dated {
+		sort.SliceStable(items, func(i, j int) bool { return items[i].Published.Before(items[j].Published) })
+	} else {
+		slices.Reverse(items)
+	}
+	return items, nil
+}
+
+func (f *Feed) filters() (include, exclude *regexp.Regexp) {
+	if f.Filter != "" {
+		include, _ = regexp.Compile("(?i)" + f.Filter)
+	}
+	if f.Exclude != "" {
+		exclude, _ = regexp.Compile("(?i)" + f.Exclude)
+	}
+	return include, exclude
+}
+
+// send posts silently to the feed's channel.
+func (f *Feed) send(text string) {
+	m := bothandler.OutboundMessage{Channel: f.Channel, Text: text, Silent: true}
+	results := bothandler.SendAll(m, []string{f.Platform})
+	if len(results) == 0 {
+		log.Printf("feed %d: %s isn't connected", f.ID, f.Platform)
+	}
+	for _, v := range results {
+		if v.Err != nil {
+			log.Printf("feed %d: %v", f.ID, v.Err)
+		}
+	}
+}
+
+// Add watches a feed, after checking it is one. The items already in it
+// aren't posted.
+func Add(url, platform, channel string) (*Feed, int, error) {
+	f := &Feed{URL: url, Platform: platform, Channel: channel}
+	items, err := fetch(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, item := range items {
+		f.Seen = append(f.Seen, item.ID)
+	}
+	f.LastDigest = bothandler.Now()
+
+	feeds.lock.Lock()
+	defer feeds.lock.Unlock()
+	feeds.NextID++
+	f.ID = feeds.NextID
+	feeds.Feeds = append(feeds.Feeds, f)
+	feeds.save()
+	return f, len(items), nil
+}
+
+// FeedHandler manages the feeds of the platform it is used on.
+func FeedHandler(request bothandler.Request) string {
+	if !bothandler.IsAdmin(request) {
+		return ""
+	}
+	platform := strings.ToLower(request.Platform)
+	words := strings.Fields(request.Content)
+	if len(words) == 0 {
+		return usage
+	}
+
+	switch words[0] {
+	case "add":
+		if len(words) != 3 {
+			return usage
+		}
+		channel := words[2]
+		if channel == "here" {
+			channel = request.Channel
+		} else if id, ok := bothandler.Channels.Resolve(platform, channel); ok {
+			channel = id
+		}
+		f, n, err := Add(words[1], platform, channel)
+		if err != nil {
+			return "Can't read the feed: " + err.Error()
+		}
+		return fmt.Sprintf("Added feed %d with %d items, new ones will be posted", f.ID, n)
+	case "list":
+		feeds.lock.Lock()
+		defer feeds.lock.Unlock()
+		out := []string{}
+		for _, f := range feeds.Feeds {
+			if f.Platform != platform {
+				continue
+			}
+			line := fmt.Sprintf("%d %s in %s", f.ID, f.URL, f.Channel)
+			if f.Filter != "" {
+				line += " filter " + f.Filter
+			}
+			if f.Exclude != "" {
+				line += " exclude " + f.Exclude
+			}
+			if f.DigestHours > 0 {
+				line += fmt.Sprintf(" digest every %dh", f.DigestHours)
+			}
+			out = append(out, line)
+		}
+		if len(out) == 0 {
+			return "No feeds"
+		}
+		return strings.Join(out, "\n")
+	}
+
+	if len(words) < 2 {
+		return usage
+	}
+	var id int
+	_, err := fmt.Sscanf(words[1], "%d", &id)
+	if _, ok := feeds.get(id, platform); err != nil || !ok {
+		return "No such feed " + words[1]
+	}
+	switch words[0] {
+	case "remove":
+		feeds.lock.Lock()
+		defer feeds.lock.Unlock()
+		feeds.Feeds = slices.DeleteFunc(feeds.Feeds, func(f *Feed) bool { return f.ID == id })
+		feeds.save()
+		return fmt.Sprintf("Removed feed %d", id)
+	case "filter", "exclude":
+		pattern := strings.Join(words[2:], " ")
+		if _, err := regexp.Compile("(?i)" + pattern); err != nil {
+			return err.Error()
+		}
+		feeds.update(id, func(f *Feed) {
+			if words[0] == "filter" {
+				f.Filter = pattern
+			} else {
+				f.Exclude = pattern
+			}
+		})
+		if pattern == "" {
+			return fmt.Sprintf("Removed the %s of feed %d", words[0], id)
+		}
+		return fmt.Sprintf("Feed %d %s is now %s", id, words[0], pattern)
+	case "digest":
+		if len(words) != 3 {
+			return usage
+		}
+		hours := 0
+		if words[2] != "off" {
+			_, err := fmt.Sscanf(words[2], "%d", &hours)
+			if err != nil || hours <= 0 {
+				return usage
+			}
+		}
+		pending := []Item{}
+		feeds.update(id, func(f *Feed) {
+			f.DigestHours = hours
+			f.LastDigest = bothandler.Now()
+			if hours == 0 {
+				pending, f.Pending = f.Pending, nil
+			}
+		})
+		if hours == 0 {
+			f, _ := feeds.get(id, platform)
+			for _, item := range pending {
+				f.send(item.String())
+			}
+			return fmt.Sprintf("Feed %d items will be posted as they come", id)
+		}
+		return fmt.Sprintf("Feed %d items will be posted every %dh", id, hours)
+	}
+	return usage
+}
@@ -0,0 +1,220 @@
+package feed
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/angch/multibot/pkg/bothandler"
+	"github.com/angch/multibot/pkg/bothandler/bothandlertest"
+)
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		filename string
+		want     []Item
+	}{
+		{"testdata/rss.xml", []Item{
+			{"engineers.my-2", "Meetup: Go in production", "https://engineers.my/posts/go-in-production", "Talks on Go services", time.Date(2024, 4, 30, 1, 0, 0, 0, time.UTC)},
+			{"engineers.my-1", "Welcome", "https://engineers.my/posts/welcome", "Hello", time.Date(2024, 4, 1, 1, 0, 0, 0, time.UTC)},
+		}},
+		{"testdata/atom.xml", []Item{
+			{"tag:github.com,2008:Repository/363271538/v1.1.0", "v1.1.0", "https://github.com/angch/multibot/releases/tag/v1.1.0", "Matrix support", time.Date(2024, 4, 20, 10, 0, 0, 0, time.UTC)},
+		}},
+		{"testdata/feed.json", []Item{
+			{"2", "Teh tarik, properly", "https://kopi.example.com/2024/05/teh-tarik", "Pull it high .", time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+			{"1", "", "https://kopi.example.com/2024/04/kopi-o", "Kopi O, no sugar.", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		}},
+	} {
+		b, err := os.ReadFile(c.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Parse(b)
+		if err != nil {
+			t.Errorf("%s: %v", c.filename, err)
+			continue
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%s: expected %d items, got %+v", c.filename, len(c.want), got)
+			continue
+		}
+		for i := range got {
+			if got[i].ID != c.want[i].ID || got[i].Title != c.want[i].Title || got[i].Link != c.want[i].Link ||
+				got[i].Summary != c.want[i].Summary || !got[i].Published.Equal(c.want[i].Published) {
+				t.Errorf("%s: expected %+v, got %+v", c.filename, c.want[i], got[i])
+			}
+		}
+	}
+
+	for _, v := range []string{"", "<html><body>Not found</body></html>", `{"items": []}`} {
+		if _, err := Parse([]byte(v)); err == nil {
+			t.Errorf("%q: expected an error", v)
+		}
+	}
+}
+
+// fixtures serves a feed from testdata at each path, with ETags, and counts
+// the requests answered with 304 Not Modified. during, if set, is called
+// once while answering the next request.
+type fixtures struct {
+	lock        sync.Mutex
+	files       map[string]string
+	notModified int
+	during      func()
+}
+
+func (f *fixtures) set(path, filename string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.files[path] = filename
+}
+
+func (f *fixtures) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.during != nil {
+		f.during()
+		f.during = nil
+	}
+	b, err := os.ReadFile(f.files[r.URL.Path])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	sum := sha256.Sum256(b)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		f.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Write(b)
+}
+
+func expectMessages(t *testing.T, p *bothandlertest.Platform, want ...string) {
+	t.Helper()
+	got := []string{}
+	for _, m := range p.Messages() {
+		got = append(got, m.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	bothandlertest.InitPlugin(t, "feed")
+	feeds.lock.Lock()
+	feeds.Feeds, feeds.NextID = nil, 0
+	feeds.lock.Unlock()
+	filename := filepath.Join(t.TempDir(), "feeds.js")
+	feeds.Load(filename)
+	bothandler.Admins["test:alice"] = true
+	defer delete(bothandler.Admins, "test:alice")
+
+	server := &fixtures{files: map[string]string{
+		"/rss.xml":  "testdata/rss.xml",
+		"/atom.xml": "testdata/atom.xml",
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	clock := bothandlertest.NewClock(t, time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC))
+
+	conv := bothandlertest.NewConversation(t)
+	alice := conv.User("alice", "test", "news")
+	conv.User("bob", "test", "news").Says("!feed list").ExpectNothing()
+	alice.Says("!feed list").Expect(`^No feeds$`)
+	alice.Says("!feed add " + ts.URL + "/missing.xml here").Expect(`Can't read the feed: 404`)
+	alice.Says("!feed add " + ts.URL + "/rss.xml here").Expect(`^Added feed 1 with 2 items`)
+
+	// Nothing new, and the feed isn't downloaded again
+	if err := Poll(1); err != nil {
+		t.Fatal(err)
+	}
+	expectMessages(t, conv.Platform)
+	if server.notModified != 1 {
+		t.Error("expected the ETag to be sent")
+	}
+
+	server.set("/rss.xml", "testdata/rss_new.xml")
+	Poll(1)
+	expectMessages(t, conv.Platform,
+		"* (news, silent) **Go 1.22 release party**\nhttps://engineers.my/posts/go-1.22",
+		"* (news, silent) **Rust & WebAssembly night**\nhttps://engineers.my/posts/rust-wasm",
+	)
+	Poll(1)
+	expectMessages(t, conv.Platform)
+
+	// Filters, and a digest, of another feed
+	alice.Says("!feed add " + ts.URL + "/atom.xml here").Expect(`^Added feed 2 with 1 items`)
+	alice.Says("!feed exclude 2 \\.1$").Expect(`Feed 2 exclude is now \\.1\$`)
+	alice.Says("!feed digest 2 24").Expect(`every 24h`)
+	alice.Says("!feed list").Expect(`(?m)^1 http://\S+/rss.xml in news$`).Expect(`(?m)^2 http://\S+/atom.xml in news exclude \\.1\$ digest every 24h$`)
+	server.set("/atom.xml", "testdata/atom_new.xml")
+	Poll(2)
+	expectMessages(t, conv.Platform)
+	clock.Advance(24 * time.Hour)
+	Poll(2)
+	expectMessages(t, conv.Platform, "* (news, silent) **1 new from "+ts.URL+"/atom.xml**\n- v1.2.0 https://github.com/angch/multibot/releases/tag/v1.2.0")
+
+	// The feeds and what was seen are kept
+	saved := &Store{}
+	if err := saved.Load(filename); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Feeds) != 2 || len(saved.Feeds[0].Seen) != 4 || saved.Feeds[1].Exclude != `\.1$` || saved.NextID != 2 {
+		t.Errorf("expected the feeds to be saved, got %+v", saved)
+	}
+
+	alice.Says("!feed remove 1").Expect(`Removed feed 1`)
+	alice.Says("!feed remove 1").Expect(`No such feed 1`)
+	conv.User("alice", "other", "news").Says("!feed remove 2").ExpectNothing()
+	alice.Says("!feed digest 2 off").Expect(`as they come`)
+	alice.Says("!feed list").Expect(`^2 http://\S+/atom.xml in news exclude \\.1\$$`)
+}
+
+func TestFeedDigestOffWhileFetching(t *testing.T) {
+	bothandlertest.InitPlugin(t, "feed")
+	feeds.lock.Lock()
+	feeds.Feeds, feeds.NextID = nil, 0
+	feeds.lock.Unlock()
+	feeds.Load(filepath.Join(t.TempDir(), "feeds.js"))
+	bothandler.Admins["test:alice"] = true
+	defer delete(bothandler.Admins, "test:alice")
+
+	server := &fixtures{files: map[string]string{"/rss.xml": "testdata/rss.xml"}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	bothandlertest.NewClock(t, time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC))
+
+	conv := bothandlertest.NewConversation(t)
+	alice := conv.User("alice", "test", "news")
+	alice.Says("!feed add " + ts.URL + "/rss.xml here").Expect(`^Added feed 1`)
+	alice.Says("!feed digest 1 24").Expect(`every 24h`)
+	server.set("/rss.xml", "testdata/rss_new.xml")
+	Poll(1)
+	expectMessages(t, conv.Platform)
+
+	// The digest is turned off, and what was pending posted, during the next fetch
+	server.during = func() {
+		alice.Says("!feed digest 1 off").Expect(`as they come`)
+	}
+	Poll(1)
+	expectMessages(t, conv.Platform,
+		"* (news, silent) **Go 1.22 release party**\nhttps://engineers.my/posts/go-1.22",
+		"* (news, silent) **Rust & WebAssembly night**\nhttps://engineers.my/posts/rust-wasm",
+	)
+	f, _ := feeds.get(1, "")
+	if f.DigestHours != 0 || len(f.Pending) != 0 {
+		t.Errorf("the poll undid digest off, got %+v", f)
+	}
+}
@@ -0,0 +1,198 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Item is an entry of an RSS, Atom or JSON feed.
+type Item struct {
+	// ID is the guid or id, or the link if the feed has neither.
+	ID        string
+	Title     string
+	Link      string
+	Summary   string
+	Published time.Time
+}
+
+// String is the title and link, as posted.
+func (i Item) String() string {
+	if i.Title == "" {
+		return i.Link
+	}
+	return "**" + i.Title + "**\n" + i.Link
+}
+
+// xmlFeed is RSS 2.0, RSS 1.0 (RDF) and Atom at once, told apart by the
+// root element.
+type xmlFeed struct {
+	XMLName xml.Name
+	// RSS 2.0
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	// RSS 1.0 has the items next to the channel
+	Items []rssItem `xml:"item"`
+	// Atom
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	GUID        string `xml:"guid"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	About       string `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+}
+
+type atomEntry struct {
+	ID    string `xml:"id"`
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Summary   string `xml:"summary"`
+	Content   string `xml:"content"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+}
+
+type jsonFeed struct {
+	Version string `json:"version"`
+	Items   []struct {
+		ID            any    `json:"id"`
+		URL           string `json:"url"`
+		Title         string `json:"title"`
+		Summary       string `json:"summary"`
+		ContentText   string `json:"content_text"`
+		ContentHTML   string `json:"content_html"`
+		DatePublished string `json:"date_published"`
+	} `json:"items"`
+}
+
+// Parse reads an RSS, Atom or JSON feed.
+func Parse(body []byte) ([]Item, error) {
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("{")) {
+		return parseJSON(body)
+	}
+	f := xmlFeed{}
+	d := xml.NewDecoder(bytes.NewReader(body))
+	// Feeds declaring other encodings are read as is, which garbles
+	// accents at worst.
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	err := d.Decode(&f)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []Item{}
+	switch strings.ToLower(f.XMLName.Local) {
+	case "rss", "rdf":
+		for _, v := range append(f.Channel.Items, f.Items...) {
+			item := Item{
+				ID:        firstOf(v.GUID, v.About, v.Link),
+				Title:     clean(v.Title),
+				Link:      strings.TrimSpace(v.Link),
+				Summary:   clean(v.Description),
+				Published: parseTime(firstOf(v.PubDate, v.Date)),
+			}
+			items = append(items, item)
+		}
+	case "feed":
+		for _, v := range f.Entries {
+			item := Item{
+				ID:        strings.TrimSpace(v.ID),
+				Title:     clean(v.Title),
+				Summary:   clean(firstOf(v.Summary, v.Content)),
+				Published: parseTime(firstOf(v.Published, v.Updated)),
+			}
+			for _, l := range v.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					item.Link = strings.TrimSpace(l.Href)
+					break
+				}
+			}
+			item.ID = firstOf(item.ID, item.Link)
+			items = append(items, item)
+		}
+	default:
+		return nil, fmt.Errorf("not a feed: <%s>", f.XMLName.Local)
+	}
+	return items, nil
+}
+
+func parseJSON(body []byte) ([]Item, error) {
+	f := jsonFeed{}
+	err := json.Unmarshal(body, &f)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(f.Version, "https://jsonfeed.org/version/") {
+		return nil, fmt.Errorf("not a JSON feed")
+	}
+	items := []Item{}
+	for _, v := range f.Items {
+		id := ""
+		if v.ID != nil {
+			id = fmt.Sprint(v.ID)
+		}
+		items = append(items, Item{
+			ID:        firstOf(id, v.URL),
+			Title:     clean(v.Title),
+			Link:      v.URL,
+			Summary:   clean(firstOf(v.Summary, v.ContentText, v.ContentHTML)),
+			Published: parseTime(v.DatePublished),
+		})
+	}
+	return items, nil
+}
+
+func firstOf(s ...string) string {
+	for _, v := range s {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+var tags = regexp.MustCompile(`<[^>]*>`)
+
+// clean makes HTML in titles and summaries plain text on one line.
+func clean(s string) string {
+	s = html.UnescapeString(tags.ReplaceAllString(s, " "))
+	return strings.Join(strings.Fields(s), " ")
+}
+
+var timeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02",
+}
+
+func parseTime(s string) time.Time {
+	for _, layout := range timeLayouts {
+		t, err := time.Parse(layout, strings.TrimSpace(s))
+		if err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,212 @@
+package githook
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Event is the parts of GitHub's webhook payloads that are announced.
+// Gitea's payloads are the same, except as noted.
+type Event struct {
+	Action string `json:"action"`
+
+	// push
+	Ref     string   `json:"ref"`
+	Before  string   `json:"before"`
+	After   string   `json:"after"`
+	Compare string   `json:"compare"`
+	Commits []Commit `json:"commits"`
+	// CompareURL is Gitea's Compare.
+	CompareURL string `json:"compare_url"`
+
+	Repository  Repository   `json:"repository"`
+	Sender      User         `json:"sender"`
+	PullRequest *PullRequest `json:"pull_request"`
+	Issue       *Issue       `json:"issue"`
+	Release     *Release     `json:"release"`
+	WorkflowRun *WorkflowRun `json:"workflow_run"`
+}
+
+type Commit struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	URL     string `json:"url"`
+}
+
+type Repository struct {
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+}
+
+type User struct {
+	Login string `json:"login"`
+}
+
+type Label struct {
+	Name string `json:"name"`
+}
+
+type PullRequest struct {
+	Number  int     `json:"number"`
+	Title   string  `json:"title"`
+	HTMLURL string  `json:"html_url"`
+	Merged  bool    `json:"merged"`
+	Draft   bool    `json:"draft"`
+	Labels  []Label `json:"labels"`
+	Base    struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+type Issue struct {
+	Number  int     `json:"number"`
+	Title   string  `json:"title"`
+	HTMLURL string  `json:"html_url"`
+	Labels  []Label `json:"labels"`
+}
+
+type Release struct {
+	TagName    string `json:"tag_name"`
+	Name       string `json:"name"`
+	HTMLURL    string `json:"html_url"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+type WorkflowRun struct {
+	Name       string `json:"name"`
+	RunNumber  int    `json:"run_number"`
+	HeadBranch string `json:"head_branch"`
+	Conclusion string `json:"conclusion"`
+	HTMLURL    string `json:"html_url"`
+}
+
+// maxCommits is how many commits a push announcement lists.
+const maxCommits = 5
+
+// branch is the branch an event is on, for Rule.Branches.
+func (e *Event) branch(event string) string {
+	switch {
+	case event == "push":
+		branch, _ := strings.CutPrefix(e.Ref, "refs/heads/")
+		if branch == e.Ref {
+			return ""
+		}
+		return branch
+	case event == "pull_request" && e.PullRequest != nil:
+		return e.PullRequest.Base.Ref
+	case event == "workflow_run" && e.WorkflowRun != nil:
+		return e.WorkflowRun.HeadBranch
+	}
+	return ""
+}
+
+// labels are an issue's or pull request's labels, for Rule.Labels, or nil
+// for other events.
+func (e *Event) labels(event string) []Label {
+	switch {
+	case event == "issues" && e.Issue != nil:
+		return append([]Label{}, e.Issue.Labels...)
+	case event == "pull_request" && e.PullRequest != nil:
+		return append([]Label{}, e.PullRequest.Labels...)
+	}
+	return nil
+}
+
+func (e *Event) compareURL() string {
+	if e.Compare != "" {
+		return e.Compare
+	}
+	return e.CompareURL
+}
+
+// Announcement is the message for an event, or "" if it isn't worth one,
+// e.g. a pull request being edited. Pushes are announced in batches.
+func (e *Event) Announcement(event string) string {
+	repo := "**" + e.Repository.FullName + "**"
+	who := e.Sender.Login
+	switch event {
+	case "pull_request":
+		pr := e.PullRequest
+		if pr == nil {
+			return ""
+		}
+		action := e.Action
+		switch {
+		case action == "closed" && pr.Merged:
+			action = "merged"
+		case action == "opened" && pr.Draft:
+			action = "opened draft"
+		case action == "ready_for_review":
+			action = "marked ready for review"
+		case action != "opened" && action != "closed" && action != "reopened":
+			return ""
+		}
+		return fmt.Sprintf("%s %s %s pull request [#%d %s](%s) into %s", repo, who, action, pr.Number, pr.Title, pr.HTMLURL, pr.Base.Ref)
+	case "issues":
+		issue := e.Issue
+		if issue == nil || (e.Action != "opened" && e.Action != "closed" && e.Action != "reopened") {
+			return ""
+		}
+		return fmt.Sprintf("%s %s %s issue [#%d %s](%s)", repo, who, e.Action, issue.Number, issue.Title, issue.HTMLURL)
+	case "release":
+		release := e.Release
+		if release == nil || e.Action != "published" || release.Draft {
+			return ""
+		}
+		name := release.TagName
+		if release.Name != "" && release.Name != release.TagName {
+			name += " " + release.Name
+		}
+		action := "released"
+		if release.Prerelease {
+			action = "pre-released"
+		}
+		return fmt.Sprintf("%s %s %s [%s](%s)", repo, who, action, name, release.HTMLURL)
+	case "workflow_run":
+		run := e.WorkflowRun
+		if run == nil || e.Action != "completed" || run.Conclusion == "skipped" {
+			return ""
+		}
+		result := map[string]string{
+			"success":   "passed",
+			"failure":   "**failed**",
+			"cancelled": "was cancelled",
+			"timed_out": "**timed out**",
+		}[run.Conclusion]
+		if result == "" {
+			result = strings.ReplaceAll(run.Conclusion, "_", " ")
+		}
+		return fmt.Sprintf("%s workflow %s %s on %s ([#%d](%s))", repo, run.Name, result, run.HeadBranch, run.RunNumber, run.HTMLURL)
+	}
+	return ""
+}
+
+func (b *batch) announcement() string {
+	commits := "1 commit"
+	if len(b.commits) != 1 {
+		commits = fmt.Sprintf("%d commits", len(b.commits))
+	}
+	lines := []string{fmt.Sprintf("**%s** %s pushed %s to %s ([compare](%s))", b.repo, joinNames(b.pushers), commits, b.branch, b.compareURL())}
+	for i, c := range b.commits {
+		if i == maxCommits {
+			lines = append(lines, fmt.Sprintf("- …and %d more", len(b.commits)-maxCommits))
+			break
+		}
+		title, _, _ := strings.Cut(c.Message, "\n")
+		lines = append(lines, fmt.Sprintf("- [%s](%s) %s", shortSHA(c.ID), c.URL, title))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// joinNames is "a", "a and b" or "a, b and c".
+func joinNames(names []string) string {
+	if len(names) <= 1 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+func shortSHA(sha string) string {
+	return sha[:min(len(sha), 7)]
+}
@@ -0,0 +1,316 @@
+// Package githook announces GitHub and Gitea webhook events (push,
+// pull_request, issues, release and workflow_run) to channels.
+//
+// It is configured with a JSON file, GITHOOK_CONFIG (githook.json by
+// default), and receives webhooks on POST /webhooks/git of the HTTP bot's
+// or the API's listener. Webhooks must be signed with the secret, from the
+// file or GITHOOK_SECRET.
+//
+//	{
+//	  "secret": "...",
+//	  "batch_seconds": 60,
+//	  "rules": [
+//	    {"repo": "angch/multibot", "channel": "dev", "branches": ["main", "release/*"]},
+//	    {"repo": "angch/*", "events": ["issues"], "labels": ["bug"], "channel": "bugs"},
+//	    {"repo": "*", "events": ["release"], "channel": "announcements", "platforms": ["discord"]}
+//	  ]
+//	}
+package githook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angch/multibot/pkg/bothandler"
+)
+
+const maxBodySize = 25 << 20
+
+// Config is what to announce where.
+type Config struct {
+	Secret string `json:"secret"`
+	// BatchSeconds is how long pushes to a branch are collected into one
+	// announcement, so a flood of pushes is one message. 0 announces each.
+	BatchSeconds int    `json:"batch_seconds"`
+	Rules        []Rule `json:"rules"`
+}
+
+// Rule announces a repository's events to a logical channel, resolved by
+// each platform as for bothandler.SendAll.
+type Rule struct {
+	// Repo is "owner/name", a pattern like "owner/*", or "*" for all.
+	Repo string `json:"repo"`
+	// Events are the event types to announce, all of them if empty.
+	Events    []string `json:"events,omitempty"`
+	Channel   string   `json:"channel"`
+	Platforms []string `json:"platforms,omitempty"`
+	// Branches are patterns like "release/*" the push, pull request base
+	// or workflow run branch must match, if any are given.
+	Branches []string `json:"branches,omitempty"`
+	// Labels are the labels an issue or pull request needs one of, if any
+	// are given.
+	Labels []string `json:"labels,omitempty"`
+}
+
+func (r Rule) matches(event string, e *Event) bool {
+	if ok, _ := path.Match(r.Repo, e.Repository.FullName); !ok && r.Repo != "*" {
+		return false
+	}
+	if len(r.Events) > 0 && !slices.Contains(r.Events, event) {
+		return false
+	}
+	if branch := e.branch(event); len(r.Branches) > 0 && branch != "" {
+		if !slices.ContainsFunc(r.Branches, func(pattern string) bool {
+			ok, _ := path.Match(pattern, branch)
+			return ok
+		}) {
+			return false
+		}
+	}
+	if labels := e.labels(event); len(r.Labels) > 0 && labels != nil {
+		if !slices.ContainsFunc(labels, func(l Label) bool { return slices.Contains(r.Labels, l.Name) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func init() {
+	bothandler.RegisterPlugin(&plugin{})
+}
+
+// plugin announces the pushes still batched when it stops.
+type plugin struct {
+	receiver *Receiver
+}
+
+func (p *plugin) Name() string { return "githook" }
+
+func (p *plugin) Init(config bothandler.PluginConfig) error {
+	r, err := Init(config)
+	if err != nil {
+		return err
+	}
+	p.receiver = r
+	return nil
+}
+
+func (p *plugin) Start() error { return nil }
+
+func (p *plugin) Stop() {
+	if p.receiver != nil {
+		p.receiver.Flush()
+	}
+}
+
+// Init reads the rules and receives webhooks with them.
+func Init(config bothandler.PluginConfig) (*Receiver, error) {
+	filename := config.Get("GITHOOK_CONFIG")
+	if filename == "" {
+		filename = "githook.json"
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c := Config{}
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if secret := config.Get("GITHOOK_SECRET"); secret != "" {
+		c.Secret = secret
+	}
+	if c.Secret == "" {
+		return nil, fmt.Errorf("no secret in %s or GITHOOK_SECRET", filename)
+	}
+	r := NewReceiver(c)
+	bothandler.RegisterHTTPHandler("POST /webhooks/git", r)
+	return r, nil
+}
+
+// Receiver is the webhook endpoint.
+type Receiver struct {
+	Config
+
+	lock    sync.Mutex
+	batches map[string]*batch
+}
+
+// batch is pushes to a branch waiting to be announced together.
+type batch struct {
+	rule    Rule
+	repo    string
+	branch  string
+	pushers []string
+	commits []Commit
+	pushes  []*Event
+}
+
+func NewReceiver(c Config) *Receiver {
+	return &Receiver{Config: c, batches: map[string]*batch{}}
+}
+
+// verify checks the body's HMAC-SHA256 signature, as GitHub sends it in
+// X-Hub-Signature-256 and Gitea in X-Gitea-Signature.
+func (r *Receiver) verify(req *http.Request, body []byte) bool {
+	signature, ok := strings.CutPrefix(req.Header.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok {
+		signature = req.Header.Get("X-Gitea-Signature")
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(r.Secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if !r.verify(req, body) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	event := req.Header.Get("X-GitHub-Event")
+	if event == "" {
+		event = req.Header.Get("X-Gitea-Event")
+	}
+	if event == "ping" {
+		fmt.Fprintln(w, "pong")
+		return
+	}
+	e := &Event{}
+	err = json.Unmarshal(body, e)
+	if err != nil {
+		http.Error(w, "bad payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Handle(event, e)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handle announces an event to the channels of the rules it matches.
+func (r *Receiver) Handle(event string, e *Event) {
+	sent := map[string]bool{}
+	for _, rule := range r.Rules {
+		// Once per channel, for overlapping rules
+		target := rule.Channel + "\x00" + strings.Join(rule.Platforms, ",")
+		if sent[target] || !rule.matches(event, e) {
+			continue
+		}
+		if event == "push" {
+			if r.push(rule, e) {
+				sent[target] = true
+			}
+			continue
+		}
+		text := e.Announcement(event)
+		if text == "" {
+			return
+		}
+		sent[target] = true
+		send(rule, text)
+	}
+}
+
+// push announces the commits, or adds them to the branch's batch. It
+// returns whether there was anything to announce.
+func (r *Receiver) push(rule Rule, e *Event) bool {
+	branch := e.branch("push")
+	if branch == "" || len(e.Commits) == 0 {
+		// Tags, and deleted branches
+		return false
+	}
+	b := &batch{rule: rule, repo: e.Repository.FullName, branch: branch}
+	b.add(e)
+	if r.BatchSeconds <= 0 {
+		send(rule, b.announcement())
+		return true
+	}
+
+	key := rule.Channel + "\x00" + strings.Join(rule.Platforms, ",") + "\x00" + b.repo + "\x00" + branch
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if pending, ok := r.batches[key]; ok {
+		pending.add(e)
+		return true
+	}
+	r.batches[key] = b
+	go func() {
+		bothandler.Sleep(time.Duration(r.BatchSeconds) * time.Second)
+		r.flush(key, b)
+	}()
+	return true
+}
+
+// flush announces the batch for key, if it is still b, or any batch if b
+// is nil.
+func (r *Receiver) flush(key string, b *batch) {
+	r.lock.Lock()
+	pending, ok := r.batches[key]
+	ok = ok && (b == nil || pending == b)
+	if ok {
+		delete(r.batches, key)
+	}
+	r.lock.Unlock()
+	if ok {
+		send(pending.rule, pending.announcement())
+	}
+}
+
+// Flush announces the pushes waiting to be batched now.
+func (r *Receiver) Flush() {
+	r.lock.Lock()
+	keys := []string{}
+	for k := range r.batches {
+		keys = append(keys, k)
+	}
+	r.lock.Unlock()
+	slices.Sort(keys)
+	for _, k := range keys {
+		r.flush(k, nil)
+	}
+}
+
+func (b *batch) add(e *Event) {
+	if !slices.Contains(b.pushers, e.Sender.Login) {
+		b.pushers = append(b.pushers, e.Sender.Login)
+	}
+	b.commits = append(b.commits, e.Commits...)
+	b.pushes = append(b.pushes, e)
+}
+
+// compareURL is the changes from before the first push to the last.
+func (b *batch) compareURL() string {
+	first, last := b.pushes[0], b.pushes[len(b.pushes)-1]
+	if len(b.pushes) == 1 || strings.Trim(first.Before, "0") == "" {
+		return last.compareURL()
+	}
+	return fmt.Sprintf("%s/compare/%s...%s", first.Repository.HTMLURL, shortSHA(first.Before), shortSHA(last.After))
+}
+
+func send(rule Rule, text string) {
+	for _, v := range bothandler.SendAll(bothandler.OutboundMessage{Channel: rule.Channel, Text: text}, rule.Platforms) {
+		if v.Err != nil {
+			log.Printf("githook: %s %s: %v", v.Platform, rule.Channel, v.Err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package githook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angch/multibot/pkg/bothandler"
+	"github.com/angch/multibot/pkg/bothandler/bothandlertest"
+)
+
+const secret = "It's a Secret to Everybody"
+
+func sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// post sends a recorded payload as GitHub would, or Gitea if gitea is set.
+func post(t *testing.T, h http.Handler, event, filename string, gitea bool) int {
+	t.Helper()
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/git", bytes.NewReader(body))
+	if gitea {
+		req.Header.Set("X-Gitea-Event", event)
+		req.Header.Set("X-Gitea-Signature", sign(body))
+	} else {
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+sign(body))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w.Code
+}
+
+func expectMessages(t *testing.T, messages []bothandlertest.Message, want ...string) {
+	t.Helper()
+	got := []string{}
+	for _, m := range messages {
+		got = append(got, m.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	p := bothandlertest.NewPlatform(t)
+	clock := bothandlertest.NewClock(t, time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC))
+	r := NewReceiver(Config{
+		Secret:       secret,
+		BatchSeconds: 60,
+		Rules: []Rule{
+			{Repo: "angch/multibot", Events: []string{"push", "pull_request", "workflow_run"}, Channel: "dev", Branches: []string{"main"}},
+			{Repo: "angch/multibot", Events: []string{"push"}, Channel: "dev", Branches: []string{"feature/*"}},
+			{Repo: "angch/*", Events: []string{"issues"}, Labels: []string{"bug"}, Channel: "bugs"},
+			{Repo: "*", Events: []string{"release"}, Channel: "announcements"},
+			{Repo: "*", Events: []string{"release"}, Channel: "announcements"},
+		},
+	})
+
+	// A flood of pushes is one announcement, once the batch is done
+	for _, v := range []string{"testdata/push.json", "testdata/push_again.json", "testdata/push_tag.json"} {
+		if code := post(t, r, "push", v, false); code != http.StatusNoContent {
+			t.Errorf("%s: expected 204, got %d", v, code)
+		}
+	}
+	expectMessages(t, p.Messages())
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	// The batch is sent once its goroutine wakes up
+	got := []bothandlertest.Message{}
+	for i := 0; i < 100 && len(got) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		got = p.Messages()
+	}
+	expectMessages(t, got, strings.Join([]string{
+		"* (dev) **angch/multibot** alice and bob pushed 3 commits to main ([compare](https://github.com/angch/multibot/compare/6113728...a9b8c7d))",
+		"- [0d1a26e](https://github.com/angch/multibot/commit/0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c) Fix IRC reconnect loop",
+		"- [5e4f3a2](https://github.com/angch/multibot/commit/5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f) Add webhook platforms",
+		"- [a9b8c7d](https://github.com/angch/multibot/commit/a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0) Document webhook platforms",
+	}, "\n"))
+
+	post(t, r, "push", "testdata/gitea_push.json", true)
+	r.Flush()
+	expectMessages(t, p.Messages(), strings.Join([]string{
+		"* (dev) **angch/multibot** carol pushed 1 commit to feature/matrix ([compare](https://git.example.com/angch/multibot/compare/1111111111111111111111111111111111111111...2222222222222222222222222222222222222222))",
+		"- [2222222](https://git.example.com/angch/multibot/commit/2222222222222222222222222222222222222222) Matrix threads",
+	}, "\n"))
+
+	post(t, r, "pull_request", "testdata/pull_request_merged.json", false)
+	post(t, r, "pull_request", "testdata/pull_request_synchronize.json", false)
+	post(t, r, "issues", "testdata/issues_opened.json", false)
+	post(t, r, "issues", "testdata/issues_question.json", false)
+	post(t, r, "release", "testdata/release_published.json", false)
+	post(t, r, "workflow_run", "testdata/workflow_run_failed.json", false)
+	expectMessages(t, p.Messages(),
+		"* (dev) **angch/multibot** alice merged pull request [#42 Add Zulip support](https://github.com/angch/multibot/pull/42) into main",
+		"* (bugs) **angch/multibot** dave opened issue [#50 Telegram webhook drops edited messages](https://github.com/angch/multibot/issues/50)",
+		"* (announcements) **angch/multibot** alice released [v1.2.0 Webhooks](https://github.com/angch/multibot/releases/tag/v1.2.0)",
+		"* (dev) **angch/multibot** workflow CI **failed** on main ([#318](https://github.com/angch/multibot/actions/runs/8912345678))",
+	)
+}
+
+func TestReceiverSignature(t *testing.T) {
+	p := bothandlertest.NewPlatform(t)
+	r := NewReceiver(Config{Secret: secret, Rules: []Rule{{Repo: "*", Channel: "dev"}}})
+
+	body, _ := os.ReadFile("testdata/release_published.json")
+	for _, signature := range []string{"", "sha256=", "sha256=" + sign([]byte("{}")), "sha1=" + sign(body), "sha256=zz"} {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/git", bytes.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "release")
+		req.Header.Set("X-Hub-Signature-256", signature)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%q: expected 401, got %d", signature, w.Code)
+		}
+	}
+	expectMessages(t, p.Messages())
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/git", strings.NewReader(`{"zen": "Keep it logically awesome."}`))
+	req.Header.Set("X-GitHub-Event", "ping")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+sign([]byte(`{"zen": "Keep it logically awesome."}`)))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "pong" {
+		t.Errorf("expected pong, got %d %s", w.Code, w.Body.String())
+	}
+
+	// Without batching, pushes are announced at once
+	post(t, r, "push", "testdata/gitea_push.json", true)
+	if got := p.Messages(); len(got) != 1 || !strings.Contains(got[0].Text, "carol pushed 1 commit to feature/matrix") {
+		t.Errorf("expected the push, got %v", got)
+	}
+}
+
+func TestPluginStop(t *testing.T) {
+	p := bothandlertest.NewPlatform(t)
+	bothandlertest.NewClock(t, time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC))
+	filename := filepath.Join(t.TempDir(), "githook.json")
+	err := os.WriteFile(filename, []byte(`{"batch_seconds": 60, "rules": [{"repo": "*", "channel": "dev"}]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin := &plugin{}
+	err = plugin.Init(bothandler.PluginConfig{"GITHOOK_CONFIG": filename, "GITHOOK_SECRET": secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Batched pushes are announced when the bot shuts down
+	post(t, plugin.receiver, "push", "testdata/gitea_push.json", true)
+	expectMessages(t, p.Messages())
+	plugin.Stop()
+	if len(p.Messages()) != 1 {
+		t.Errorf("expected the batch to be announced on stop, got %v", p.Messages())
+	}
+}
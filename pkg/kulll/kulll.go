@@ -40,30 +40,37 @@ var history = map[string]History{}
 var myrand = rand.New(rand.NewSource(time.Now().UnixNano()))
 
 func init() {
-	bothandler.RegisterCatchallHandler(KulllHandler)
-	load()
+	bothandler.RegisterPluginFunc("kulll", func(bothandler.PluginConfig) error {
+		err := load()
+		if err != nil {
+			return err
+		}
+		bothandler.RegisterCatchallHandler(KulllHandler)
+		return nil
+	})
 	// math.Rand()
 }
 
-const savefile string = "kulll.js"
+var savefile = "kulll.js"
 
-func load() {
+func load() error {
 	lock.Lock()
 	defer lock.Unlock()
 
 	history = make(map[string]History)
 	f, err := os.Open(savefile)
 	if err == nil {
+		defer f.Close()
 		b, err := io.ReadAll(f)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		err = json.Unmarshal(b, &history)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("%s: %w", savefile, err)
 		}
-		f.Close()
 	}
+	return nil
 }
 
 func save() {
@@ -72,12 +79,13 @@ func save() {
 
 	f, err := os.OpenFile(savefile, os.O_RDWR|os.O_CREATE, 0755)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return
 	}
 	b, _ := json.Marshal(history)
 	_, err = f.Write(b)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
 	}
 	f.Close()
 }
@@ -85,7 +93,7 @@ func save() {
 func KulllHandler(request bothandler.Request) string {
 	input := request.Content
 	// Jan 2 15:04:05 2006 MST
-	today := time.Now().Local().Format("20060102")
+	today := bothandler.Now().Local().Format("20060102")
 	key := fmt.Sprintf("%s/%s/%s", request.Platform, request.Channel, today)
 	lock.Lock()
 	_, ok := history[key]
@@ -0,0 +1,32 @@
+package kulll
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/angch/multibot/pkg/bothandler/bothandlertest"
+)
+
+func TestKulllDaily(t *testing.T) {
+	savefile = filepath.Join(t.TempDir(), "kulll.js")
+	myrand = rand.New(rand.NewSource(1))
+	bothandlertest.InitPlugin(t, "kulll")
+	clock := bothandlertest.NewClock(t, time.Date(2024, 1, 2, 8, 0, 0, 0, time.Local))
+
+	conv := bothandlertest.NewConversation(t)
+	alice := conv.User("alice", "discord", "general")
+	bob := conv.User("bob", "discord", "general")
+	carol := conv.User("carol", "slack", "random")
+
+	alice.Says("good morning everyone").Expect(`.`)
+	bob.Says("morning!").ExpectNothing()
+	carol.Says("ohayo").Expect(`.`)
+	alice.Says("what's for lunch").ExpectNothing()
+
+	clock.Advance(24 * time.Hour)
+	bob.Says("morning!").Expect(`.`)
+
+	conv.Golden("testdata/daily.golden")
+}
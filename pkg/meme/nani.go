@@ -30,7 +30,9 @@ var asciiExplosion = "```" + `.
 ` + "```"
 
 func init() {
-	bothandler.RegisterCatchallHandler(ReplyNani)
+	bothandler.RegisterHandlerPlugin("meme", func() {
+		bothandler.RegisterCatchallHandler(ReplyNani)
+	})
 }
 
 func ReplyNani(request bothandler.Request) string {
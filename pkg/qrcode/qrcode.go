@@ -8,7 +8,10 @@ import (
 )
 
 func init() {
-	bothandler.RegisterCatchallExtendeHandler(GetMessage)
+	bothandler.RegisterHandlerPlugin("qrcode", func() {
+		bothandler.RegisterCatchallExtendeHandler(GetMessage)
+		bothandler.RegisterCommand("!qrcode", "Encode text as a QR code")
+	})
 }
 
 func GetMessage(input bothandler.ExtendedMessage) *bothandler.ExtendedMessage {
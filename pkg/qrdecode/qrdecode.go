@@ -1,12 +1,7 @@
 package qrdecode
 
 import (
-	"bytes"
-	"image"
-	_ "image/jpeg"
-	_ "image/png"
 	"log"
-	"os"
 	"strings"
 
 	"github.com/liyue201/goqr"
@@ -14,14 +9,8 @@ import (
 	"github.com/angch/multibot/pkg/bothandler"
 )
 
-func QrdecodeHandler(filename string, request bothandler.Request) string {
-	imgdata, err := os.ReadFile(filename)
-	if err != nil {
-		log.Println(err)
-		return ""
-	}
-
-	img, _, err := image.Decode(bytes.NewReader(imgdata))
+func QrdecodeHandler(a *bothandler.Attachment, request bothandler.Request) string {
+	img, err := a.Image()
 	if err != nil {
 		log.Printf("image.Decode error: %v\n", err)
 		return ""
@@ -50,5 +39,7 @@ func QrdecodeHandler(filename string, request bothandler.Request) string {
 }
 
 func init() {
-	bothandler.RegisterImageHandler(QrdecodeHandler)
+	bothandler.RegisterHandlerPlugin("qrdecode", func() {
+		bothandler.RegisterImageHandler(QrdecodeHandler)
+	})
 }
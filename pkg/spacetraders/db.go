@@ -170,12 +170,13 @@ const savefile string = "spacetraders.sqlite"
 
 var lock sync.Mutex
 
-func load() {
+func load() error {
 	lock.Lock()
 
 	gormdb, err := gorm.Open(sqlite.Open(savefile), &defaultGormConfig)
 	if err != nil {
-		log.Fatalf("failed to connect database: %v", err)
+		lock.Unlock()
+		return fmt.Errorf("failed to connect database: %w", err)
 	}
 	gormdb.AutoMigrate(
 		&ChannelAgents{},
@@ -199,6 +200,7 @@ func load() {
 	lock.Unlock()
 
 	this.LoadKnown()
+	return nil
 }
 
 func save() {
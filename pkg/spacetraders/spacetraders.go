@@ -7,6 +7,7 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -69,12 +70,18 @@ var this = SpaceTraders{
 }
 
 func init() {
-	if activeDev {
-		// log.Println("pkg/spacetraders/init")
-	}
-	// Singleton pattern, to fit in with the rest of the bot architecture
-	bothandler.RegisterCatchallHandler(SpaceTradersHandler)
-	load()
+	bothandler.RegisterPluginFunc("spacetraders", func(bothandler.PluginConfig) error {
+		if activeDev {
+			// log.Println("pkg/spacetraders/init")
+		}
+		err := load()
+		if err != nil {
+			return err
+		}
+		// Singleton pattern, to fit in with the rest of the bot architecture
+		bothandler.RegisterCatchallHandler(SpaceTradersHandler)
+		return nil
+	})
 }
 
 func isValidPlatformChannel(platform, channel string) bool {
@@ -160,7 +167,18 @@ func SpaceTradersHandler(request bothandler.Request) string {
 		// return "agent details is work in progress"
 	case "faction":
 		if len(words) < 2 {
-			return "Need id for faction"
+			// Long, the platform pages it with !more
+			a := []string{}
+			this.lock.RLock()
+			for _, v := range this.KnownFactions {
+				a = append(a, v.PrettyPrint())
+			}
+			this.lock.RUnlock()
+			if len(a) == 0 {
+				return "No known factions"
+			}
+			sort.Strings(a)
+			return strings.Join(a, "\n")
 		}
 		factionCode := strings.ToUpper(words[1])
 
@@ -171,10 +189,21 @@ func SpaceTradersHandler(request bothandler.Request) string {
 		return faction.PrettyPrint()
 	case "ship":
 		if len(words) < 2 {
-			a := []string{}
+			// Every ship in full, the platform pages it with !more
+			ships := []string{}
 			this.lock.RLock()
 			for k := range this.AgentShips[agentState.Agent] {
-				a = append(a, " "+k)
+				ships = append(ships, k)
+			}
+			sort.Strings(ships)
+			a := []string{}
+			for _, k := range ships {
+				ship, ok := this.KnownShips[k]
+				if !ok {
+					a = append(a, k+"\n")
+					continue
+				}
+				a = append(a, ship.PrettyPrint())
 			}
 			this.lock.RUnlock()
 			return "Ships:\n" + strings.Join(a, "\n")
@@ -0,0 +1,85 @@
+package sdapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// Img2ImgParameters are the txt2img parameters, and the images to start
+// from, base64 encoded.
+type Img2ImgParameters struct {
+	Txt2ImgParameters
+	InitImages []string `json:"init_images"`
+}
+
+func NewImg2ImgParameters(image []byte) *Img2ImgParameters {
+	return &Img2ImgParameters{
+		Txt2ImgParameters: *NewTxt2ImgParameters(),
+		InitImages:        []string{base64.StdEncoding.EncodeToString(image)},
+	}
+}
+
+func (p *Img2ImgParameters) IoReader() *bytes.Buffer {
+	j, err := json.Marshal(p)
+	if err != nil {
+		return nil
+	}
+	return bytes.NewBuffer(j)
+}
+
+// Img2Img draws n images from image and the prompt.
+func (s *Server) Img2Img(prompt string, image []byte, n int) ([][]byte, error) {
+	p := NewImg2ImgParameters(image)
+	p.RestoreFaces = true
+	p.Width = 768
+	p.Height = 768
+	p.Steps = 30
+	p.DenoisingStrength = 0.6
+	p.BatchSize = n
+
+	prompt = PromptReplace.Replace(prompt)
+
+	pos, neg := s.Prompt2PosNeg(prompt)
+	p.Prompt = pos
+	p.NegativePrompt = neg
+	p.SetSampler("DPM++ SDE")
+
+	u := s.URL.String()
+	u += "/sdapi/v1/img2img"
+	log.Println(u, p.Prompt)
+	t1 := time.Now()
+	resp, err := HttpClient.Post(u, "application/json", p.IoReader())
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Time taken", time.Since(t1))
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	result := Txt2ImgParametersResult{}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Images) < 1 {
+		return nil, fmt.Errorf("img2img: no images in %.200s", body)
+	}
+	images := [][]byte{}
+	for _, v := range result.Images {
+		image, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(v, "data:image/png;base64,"))
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
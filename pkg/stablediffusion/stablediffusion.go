@@ -7,7 +7,6 @@ import (
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 
 	"github.com/angch/multibot/pkg/bothandler"
@@ -18,11 +17,15 @@ var sd_url *url.URL
 var sdapi_server *sdapi.Server
 
 func init() {
-	bothandler.RegisterCatchallExtendeHandler(GetMessage)
-	sdapi_url, sd_urlString := os.Getenv("SDAPI_URL"), os.Getenv("SD_URL")
+	bothandler.RegisterPluginFunc("stablediffusion", Init)
+}
+
+// Init uses the server at SDAPI_URL or SD_URL.
+func Init(config bothandler.PluginConfig) error {
+	sdapi_url, sd_urlString := config.Get("SDAPI_URL"), config.Get("SD_URL")
 
 	if sd_urlString == "" && sdapi_url == "" {
-		log.Fatal("Need valid env SD_URL or SDAPI_URL")
+		return fmt.Errorf("need SD_URL or SDAPI_URL")
 	}
 	if sdapi_url != "" {
 		sdapi_server = sdapi.NewServer(sdapi_url)
@@ -30,14 +33,44 @@ func init() {
 	} else {
 		sd, err := url.Parse(sd_urlString)
 		if err != nil {
-			log.Fatal("Need valid env SD_URL")
+			return fmt.Errorf("invalid SD_URL: %w", err)
 		}
 		if sd.Scheme != "http" && sd.Scheme != "https" {
-			log.Fatal("Need valid env SD_URL")
+			return fmt.Errorf("invalid SD_URL %q, need http or https", sd_urlString)
 		}
 		sd_url = sd
 		sdapi_server = nil
 	}
+
+	bothandler.RegisterCatchallExtendeHandler(GetMessage)
+	bothandler.RegisterCallbackHandler("sd", RerollHandler)
+	bothandler.RegisterCommand("!sd", "Generate an image with Stable Diffusion")
+	if sdapi_server != nil {
+		bothandler.RegisterImageResponseHandler([]string{"image/*"}, ImageHandler)
+		bothandler.RegisterCommand("!sdimg", "Redraw the image sent with Stable Diffusion")
+	}
+	return nil
+}
+
+// sdImages is how many images are drawn from an image.
+const sdImages = 2
+
+// ImageHandler redraws an image sent with "!sdimg <prompt>".
+func ImageHandler(a *bothandler.Attachment, request bothandler.Request) *bothandler.ExtendedMessage {
+	prompt, ok := strings.CutPrefix(strings.ToLower(request.Content), "!sdimg ")
+	if !ok || sdapi_server == nil {
+		return nil
+	}
+	images, err := sdapi_server.Img2Img(prompt, a.Data, sdImages)
+	if err != nil {
+		log.Println(err)
+		return &bothandler.ExtendedMessage{Text: "Zzzz server is sleeping"}
+	}
+	m := &bothandler.ExtendedMessage{}
+	for i, v := range images {
+		m.Images = append(m.Images, bothandler.NewAttachment(fmt.Sprintf("sd-%d.png", i+1), v, "image/png"))
+	}
+	return m
 }
 
 /*
@@ -109,7 +142,13 @@ func GetMessage(input bothandler.ExtendedMessage) *bothandler.ExtendedMessage {
 	}
 
 	return &bothandler.ExtendedMessage{
-		Text:  "",
-		Image: body,
+		Text:    "",
+		Image:   body,
+		Buttons: []bothandler.Button{{Text: "Re-roll", Plugin: "sd", Data: i}},
 	}
 }
+
+// RerollHandler runs the prompt in Content again.
+func RerollHandler(request bothandler.Request) *bothandler.ExtendedMessage {
+	return GetMessage(bothandler.ExtendedMessage{Text: "!sd " + request.Content})
+}
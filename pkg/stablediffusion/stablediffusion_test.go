@@ -0,0 +1,52 @@
+package standarddiffusion
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/angch/multibot/pkg/bothandler"
+	"github.com/angch/multibot/pkg/stablediffusion/sdapi"
+)
+
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestImageHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sdapi/v1/img2img" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		p := sdapi.Img2ImgParameters{}
+		json.NewDecoder(r.Body).Decode(&p)
+		if p.Prompt != "oil painting" || p.BatchSize != 2 || len(p.InitImages) != 1 || p.InitImages[0] != base64.StdEncoding.EncodeToString(testPNG) {
+			t.Errorf("unexpected img2img %+v", p)
+		}
+		png := base64.StdEncoding.EncodeToString(testPNG)
+		json.NewEncoder(w).Encode(map[string]any{"images": []string{png, "data:image/png;base64," + png}})
+	}))
+	defer server.Close()
+
+	oldHandlers := bothandler.AttachmentHandlers
+	defer func() { bothandler.AttachmentHandlers = oldHandlers }()
+	bothandler.AttachmentHandlers = nil
+	err := Init(bothandler.PluginConfig{"SDAPI_URL": server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replies := []*bothandler.ExtendedMessage{}
+	reply := func(m *bothandler.ExtendedMessage) { replies = append(replies, m) }
+	bothandler.DispatchAttachment(bothandler.NewAttachment("cat.png", testPNG, ""), bothandler.Request{Content: "!sdimg Oil painting"}, reply)
+	if len(replies) != 1 || len(replies[0].Images) != 2 || replies[0].Images[1].Filename != "sd-2.png" || replies[0].Images[1].MimeType != "image/png" {
+		t.Fatalf("expected two images, got %+v", replies)
+	}
+
+	// Images sent without the command are left alone
+	bothandler.DispatchAttachment(bothandler.NewAttachment("cat.png", testPNG, ""), bothandler.Request{Content: "!sd robot"}, reply)
+	if len(replies) != 1 {
+		t.Errorf("unexpected reply %+v", replies[1:])
+	}
+}
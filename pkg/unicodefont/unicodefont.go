@@ -46,7 +46,10 @@ abcdefghijklmnopqrstuvwxyz
 🅐🅑🅒🅓🅔🅕🅖🅗🅘🅙🅚🅛🅜🅝🅞🅟🅠🅡🅢🅣🅤🅥🅦🅧🅨🅩`
 
 func init() {
-	bothandler.RegisterCatchallHandler(UnicodeFontReplace)
+	bothandler.RegisterHandlerPlugin("unicodefont", func() {
+		bothandler.RegisterCatchallHandler(UnicodeFontReplace)
+		bothandler.RegisterCommand("!unicode", "Write text in a random unicode font")
+	})
 
 	s := strings.Split(fontmapSrc, "\n")
 	for k, line := range s {
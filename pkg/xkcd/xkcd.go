@@ -8,8 +8,12 @@ import (
 )
 
 func init() {
-	bothandler.RegisterMessageWithInputHandler("!xkcd", GetXKCD)
-	bothandler.RegisterMessageWithInputHandler("!explainxkcd", GetXKCDExplained)
+	bothandler.RegisterHandlerPlugin("xkcd", func() {
+		bothandler.RegisterMessageWithInputHandler("!xkcd", GetXKCD)
+		bothandler.RegisterMessageWithInputHandler("!explainxkcd", GetXKCDExplained)
+		bothandler.RegisterCommand("!xkcd", "Link to an xkcd comic by number")
+		bothandler.RegisterCommand("!explainxkcd", "Link to explainxkcd by comic number")
+	})
 }
 
 func sanitize(input string) int {
@@ -55,7 +55,9 @@ Y8b d88P
 }
 
 func init() {
-	bothandler.RegisterCatchallHandler(YMCAHandler)
+	bothandler.RegisterHandlerPlugin("ymca", func() {
+		bothandler.RegisterCatchallHandler(YMCAHandler)
+	})
 }
 
 func YMCAHandler(request bothandler.Request) string {
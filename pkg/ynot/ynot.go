@@ -43,7 +43,9 @@ var randomBuffer = []int{}
 var randomBufferIdx = 0
 
 func init() {
-	bothandler.RegisterCatchallHandler(YNotHandler)
+	bothandler.RegisterHandlerPlugin("ynot", func() {
+		bothandler.RegisterCatchallHandler(YNotHandler)
+	})
 	randomBuffer = make([]int, len(excuses)/2)
 	for i := 0; i < len(randomBuffer); i++ {
 		randomBuffer[i] = -1
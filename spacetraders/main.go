@@ -1,10 +0,0 @@
-package main
-
-import (
-	"github.com/angch/multibot/cmd"
-	_ "github.com/angch/multibot/pkg/spacetraders"
-)
-
-func main() {
-	cmd.Execute()
-}